@@ -0,0 +1,115 @@
+package chess
+
+import "project-go/util"
+
+// Source https://en.wikipedia.org/wiki/Fischer_random_chess#Castling_rules
+
+// After castling, the King and Rook always land on these columns, regardless of where they started
+const KINGSIDE_KING_COL = 6
+const KINGSIDE_ROOK_COL = 5
+const QUEENSIDE_KING_COL = 2
+const QUEENSIDE_ROOK_COL = 3
+
+func (s *State) isCastle(source Position, dest Position) bool {
+	king, isKing := s.board.State[source.Y][source.X].(*King)
+
+	// Only an unmoved King can castle, and only along its own row
+	if !isKing || king.HasMoved || source.Y != dest.Y {
+		return false
+	}
+
+	_, ok := s.castlingRook(source, dest)
+	return ok
+}
+
+func (s *State) castlingRook(source Position, dest Position) (Position, bool) {
+	// Moving the King directly onto its own unmoved Rook is always a castle
+	// This is the only unambiguous form in Chess960
+	if isUnmovedRook(s.board.State[dest.Y][dest.X], s.turn) {
+		return dest, true
+	}
+
+	// Otherwise, moving the King two squares onto the castling column is the traditional form
+	if util.Abs(dest.X-source.X) != 2 || (dest.X != KINGSIDE_KING_COL && dest.X != QUEENSIDE_KING_COL) {
+		return Position{}, false
+	}
+
+	// Search outwards from the King for the Rook on that side
+	direction := multipliableDirection(dest.X - source.X)
+	for col := source.X + direction; col >= 0 && col < len(s.board.State[source.Y]); col += direction {
+		if isUnmovedRook(s.board.State[source.Y][col], s.turn) {
+			return Position{X: col, Y: source.Y}, true
+		}
+	}
+
+	return Position{}, false
+}
+
+func (s *State) castle(source Position, dest Position) (bool, string) {
+	row := source.Y
+	rookPos, _ := s.castlingRook(source, dest)
+	king := s.board.State[row][source.X]
+	rook := s.board.State[row][rookPos.X]
+
+	// Figure out where the pieces will end up
+	kingCol, rookCol := QUEENSIDE_KING_COL, QUEENSIDE_ROOK_COL
+	if rookPos.X > source.X {
+		kingCol, rookCol = KINGSIDE_KING_COL, KINGSIDE_ROOK_COL
+	}
+
+	// Every square either piece touches must be empty, other than the King and Rook themselves
+	from := util.Min(util.Min(source.X, kingCol), util.Min(rookPos.X, rookCol))
+	to := util.Max(util.Max(source.X, kingCol), util.Max(rookPos.X, rookCol))
+	for col := from; col <= to; col++ {
+		if col != source.X && col != rookPos.X && s.board.State[row][col] != nil {
+			return false, "There is a piece in the way of castling"
+		}
+	}
+
+	if s.kingInCheck() {
+		return false, "You cannot castle out of check"
+	}
+
+	// The King cannot pass through an attacked square
+	// Lift the King off the board so it does not block attacks along its own path
+	s.board.State[row][source.X] = nil
+	direction := multipliableDirection(kingCol - source.X)
+	for col := source.X + direction; col != kingCol+direction && direction != 0; col += direction {
+		if s.squareAttacked(Position{X: col, Y: row}, s.turn) {
+			s.board.State[row][source.X] = king
+			return false, "You cannot castle through check"
+		}
+	}
+
+	// Apply the castle
+	s.board.State[row][rookPos.X] = nil
+	s.board.State[row][kingCol] = king
+	s.board.State[row][rookCol] = rook
+
+	if s.kingInCheck() {
+		// The King would land in check, revert this movement
+		s.board.State[row][kingCol] = nil
+		s.board.State[row][rookCol] = nil
+		s.board.State[row][source.X] = king
+		s.board.State[row][rookPos.X] = rook
+		return false, "That move results in check"
+	}
+
+	king.Moved()
+	rook.Moved()
+
+	s.lastMove = []Position{source, rookPos, {X: kingCol, Y: row}, {X: rookCol, Y: row}}
+	if kingCol == KINGSIDE_KING_COL {
+		s.moves = append(s.moves, "O-O")
+	} else {
+		s.moves = append(s.moves, "O-O-O")
+	}
+	s.history = append(s.history, PlayedMove{Source: source, Dest: dest})
+
+	return true, ""
+}
+
+func isUnmovedRook(piece IPiece, colour Colour) bool {
+	rook, isRook := piece.(*Rook)
+	return isRook && !rook.HasMoved && rook.Colour() == colour
+}
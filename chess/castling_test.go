@@ -0,0 +1,62 @@
+package chess
+
+import "testing"
+
+// A game with just the given pieces on the board, white to move
+func newTestState(rules Rules, pieces map[Position]IPiece) State {
+	state := State{turn: WHITE, rules: rules, startPosition: STANDARD_START_POSITION}
+	for pos, piece := range pieces {
+		state.board.State[pos.Y][pos.X] = piece
+	}
+	return state
+}
+
+func TestChess960CastlingLandings(t *testing.T) {
+	tests := []struct {
+		name     string
+		king     int // Columns on white's back rank
+		rook     int
+		kingDest int
+		rookDest int
+		notation string
+	}{
+		{"king already on its square", 6, 7, KINGSIDE_KING_COL, KINGSIDE_ROOK_COL, "O-O"},
+		{"king lands on the rook's square kingside", 5, 6, KINGSIDE_KING_COL, KINGSIDE_ROOK_COL, "O-O"},
+		{"king lands on the rook's square queenside", 3, 2, QUEENSIDE_KING_COL, QUEENSIDE_ROOK_COL, "O-O-O"},
+		{"rook already on its square", 4, 3, QUEENSIDE_KING_COL, QUEENSIDE_ROOK_COL, "O-O-O"},
+	}
+
+	for _, test := range tests {
+		king, rook := NewKing(WHITE), NewRook(WHITE)
+		state := newTestState(chess960Rules{}, map[Position]IPiece{
+			{X: test.king, Y: 7}: king,
+			{X: test.rook, Y: 7}: rook,
+			{X: 4, Y: 0}:         NewKing(BLACK),
+		})
+
+		// In Chess960 the King castles by moving onto its own Rook
+		ok, reason := state.MovePiece(Position{X: test.king, Y: 7}, Position{X: test.rook, Y: 7})
+		if !ok {
+			t.Errorf("%s: castling refused: %s", test.name, reason)
+			continue
+		}
+
+		for col, piece := range state.board.State[7] {
+			expected := IPiece(nil)
+			if col == test.kingDest {
+				expected = king
+			} else if col == test.rookDest {
+				expected = rook
+			}
+			if piece != expected {
+				t.Errorf("%s: column %d has %v, expected %v", test.name, col, piece, expected)
+			}
+		}
+		if !king.HasMoved || !rook.HasMoved {
+			t.Errorf("%s: the King and Rook were not marked as moved", test.name)
+		}
+		if moves := state.Moves(); len(moves) != 1 || moves[0] != test.notation {
+			t.Errorf("%s: recorded %v, expected %s", test.name, moves, test.notation)
+		}
+	}
+}
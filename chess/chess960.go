@@ -0,0 +1,125 @@
+package chess
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+// Source https://en.wikipedia.org/wiki/Fischer_random_chess_numbering_scheme
+
+// There are 960 legal starting positions, numbered 0 to 959
+const NUM_START_POSITIONS = 960
+
+// The standard chess starting position is number 518 in the numbering scheme
+const STANDARD_START_POSITION = 518
+
+// Every possible placement of the two knights within the five free squares left after the bishops and queen
+var knightPlacements = [10][2]int{
+	{0, 1}, {0, 2}, {0, 3}, {0, 4},
+	{1, 2}, {1, 3}, {1, 4},
+	{2, 3}, {2, 4},
+	{3, 4},
+}
+
+type pieceConstructor func(colour Colour) IPiece
+
+func RandomStartPosition() int {
+	return rand.Intn(NUM_START_POSITIONS)
+}
+
+// Whether the index is one of the numbered starting positions
+func ValidStartPosition(index int) bool {
+	return index >= 0 && index < NUM_START_POSITIONS
+}
+
+func GenerateChess960(index int) (Board, error) {
+	if !ValidStartPosition(index) {
+		return Board{}, fmt.Errorf("invalid starting position %d", index)
+	}
+	backRank := chess960BackRank(index)
+
+	board := Board{}
+	for col := 0; col < len(backRank); col++ {
+		board.State[0][col] = backRank[col](BLACK)
+		board.State[7][col] = backRank[col](WHITE)
+	}
+
+	// The second row for each board is filled with pawns
+	for i := 0; i < 8; i++ {
+		board.State[1][i] = NewPawn(BLACK)
+	}
+	for i := 0; i < 8; i++ {
+		board.State[6][i] = NewPawn(WHITE)
+	}
+
+	return board, nil
+}
+
+func chess960BackRank(index int) [8]pieceConstructor {
+	var backRank [8]pieceConstructor
+
+	// The light squared bishop goes on one of b, d, f, h
+	backRank[(index%4)*2+1] = newBishop
+	index /= 4
+
+	// The dark squared bishop goes on one of a, c, e, g
+	backRank[(index%4)*2] = newBishop
+	index /= 4
+
+	// The queen goes on one of the six remaining squares
+	placeInEmpty(&backRank, index%6, newQueen)
+	index /= 6
+
+	// The knights take two of the five remaining squares
+	// Place the second knight first, so that placing the first does not shift its index
+	knights := knightPlacements[index]
+	placeInEmpty(&backRank, knights[1], newKnight)
+	placeInEmpty(&backRank, knights[0], newKnight)
+
+	// The remaining three squares are always rook, king, rook from left to right
+	placeInEmpty(&backRank, 0, newRook)
+	placeInEmpty(&backRank, 0, newKing)
+	placeInEmpty(&backRank, 0, newRook)
+
+	return backRank
+}
+
+func placeInEmpty(backRank *[8]pieceConstructor, emptyIndex int, piece pieceConstructor) {
+	// Find the nth empty square in the back rank and place the piece there
+	for col := range backRank {
+		if backRank[col] != nil {
+			continue
+		}
+
+		if emptyIndex == 0 {
+			backRank[col] = piece
+			return
+		}
+		emptyIndex--
+	}
+}
+
+// Wrappers so that the piece constructors can be stored generically
+func newKing(colour Colour) IPiece   { return NewKing(colour) }
+func newQueen(colour Colour) IPiece  { return NewQueen(colour) }
+func newRook(colour Colour) IPiece   { return NewRook(colour) }
+func newBishop(colour Colour) IPiece { return NewBishop(colour) }
+func newKnight(colour Colour) IPiece { return NewKnight(colour) }
+
+// Chess960 plays like standard chess, only the starting position changes
+type chess960Rules struct {
+	standardRules
+}
+
+func (r chess960Rules) Variant() Variant {
+	return CHESS960
+}
+
+func (r chess960Rules) StartingBoard(startPosition int) Board {
+	board, err := GenerateChess960(startPosition)
+	if err != nil {
+		// Callers should check the position is valid first, but standard chess is always a legal setup
+		return Generate()
+	}
+	return board
+}
@@ -0,0 +1,100 @@
+package chess
+
+import (
+	"strings"
+	"testing"
+)
+
+// The back rank as piece letters from a to h, eg RNBQKBNR
+func backRankLetters(board Board, row int) string {
+	letters := ""
+	for _, piece := range board.State[row] {
+		if piece == nil {
+			letters += "."
+			continue
+		}
+		letters += piece.Representation()[1:]
+	}
+	return letters
+}
+
+func TestStandardStartPosition(t *testing.T) {
+	board, err := GenerateChess960(STANDARD_START_POSITION)
+	if err != nil {
+		t.Fatalf("generating position %d: %s", STANDARD_START_POSITION, err.Error())
+	}
+
+	for _, row := range []int{0, 7} {
+		if letters := backRankLetters(board, row); letters != "RNBQKBNR" {
+			t.Errorf("row %d is %s, expected RNBQKBNR", row, letters)
+		}
+	}
+	if board.State[0][4].Colour() != BLACK || board.State[7][4].Colour() != WHITE {
+		t.Errorf("the back ranks are the wrong colours")
+	}
+	for col := 0; col < 8; col++ {
+		if _, ok := board.State[1][col].(*Pawn); !ok {
+			t.Errorf("no black pawn on column %d", col)
+		}
+		if _, ok := board.State[6][col].(*Pawn); !ok {
+			t.Errorf("no white pawn on column %d", col)
+		}
+	}
+}
+
+func TestEveryStartPositionIsLegal(t *testing.T) {
+	seen := make(map[string]int)
+	for index := 0; index < NUM_START_POSITIONS; index++ {
+		board, err := GenerateChess960(index)
+		if err != nil {
+			t.Fatalf("generating position %d: %s", index, err.Error())
+		}
+		letters := backRankLetters(board, 7)
+
+		// Both sides mirror each other
+		if black := backRankLetters(board, 0); black != letters {
+			t.Errorf("position %d: black has %s and white has %s", index, black, letters)
+		}
+
+		// The bishops are on opposite colours
+		first, last := strings.Index(letters, "B"), strings.LastIndex(letters, "B")
+		if first == last || (last-first)%2 == 0 {
+			t.Errorf("position %d: %s has its bishops on the same colour", index, letters)
+		}
+
+		// The King is somewhere between the two Rooks
+		king := strings.Index(letters, "K")
+		if king < strings.Index(letters, "R") || king > strings.LastIndex(letters, "R") {
+			t.Errorf("position %d: %s does not have the King between the Rooks", index, letters)
+		}
+
+		// And everything else is there exactly once
+		for piece, count := range map[string]int{"K": 1, "Q": 1, "R": 2, "B": 2, "N": 2} {
+			if strings.Count(letters, piece) != count {
+				t.Errorf("position %d: %s does not have %d of %s", index, letters, count, piece)
+			}
+		}
+
+		if other, ok := seen[letters]; ok {
+			t.Errorf("positions %d and %d are both %s", other, index, letters)
+		}
+		seen[letters] = index
+	}
+}
+
+func TestOutOfRangeStartPositionIsRejected(t *testing.T) {
+	for _, index := range []int{-1, NUM_START_POSITIONS, NUM_START_POSITIONS + STANDARD_START_POSITION} {
+		if ValidStartPosition(index) {
+			t.Errorf("position %d is called valid", index)
+		}
+		if _, err := GenerateChess960(index); err == nil {
+			t.Errorf("generated position %d", index)
+		}
+
+		// A game asked to start from it gets the standard setup instead
+		state := CreateVariantState(CHESS960, index)
+		if state.StartPosition() != STANDARD_START_POSITION || backRankLetters(state.Board(), 7) != "RNBQKBNR" {
+			t.Errorf("a game from position %d started from %d", index, state.StartPosition())
+		}
+	}
+}
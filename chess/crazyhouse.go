@@ -0,0 +1,191 @@
+package chess
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Source https://en.wikipedia.org/wiki/Crazyhouse
+
+type PieceKind int
+
+const (
+	PAWN PieceKind = iota
+	KNIGHT
+	BISHOP
+	ROOK
+	QUEEN
+	NUM_RESERVE_KINDS
+)
+
+// Crazyhouse plays like standard chess, but captured pieces join the capturer's reserve and can be dropped back in
+type crazyhouseRules struct {
+	standardRules
+}
+
+func (r crazyhouseRules) Variant() Variant {
+	return CRAZYHOUSE
+}
+
+func (r crazyhouseRules) Captured(s *State, piece IPiece) {
+	kind, ok := KindOf(piece)
+	if !ok {
+		return
+	}
+
+	// The captured piece changes sides, and goes into the capturer's reserve
+	s.reserves[piece.Colour().Opponent()][kind]++
+}
+
+func (r crazyhouseRules) CanDrop(s *State, kind PieceKind, dest Position) (bool, string) {
+	// Pawns can never be dropped on the first or last row
+	if kind == PAWN && (dest.Y == 0 || dest.Y == len(s.board.State)-1) {
+		return false, "Pawns cannot be dropped on the first or last row"
+	}
+
+	return true, ""
+}
+
+func (s *State) Reserve(colour Colour, kind PieceKind) int {
+	return s.reserves[colour][kind]
+}
+
+func (s *State) DropPiece(kind PieceKind, dest Position) (bool, string) {
+	if kind < PAWN || kind >= NUM_RESERVE_KINDS {
+		return false, "That piece cannot be dropped"
+	}
+
+	if !dest.OnBoard() {
+		return false, "That position is not on the board"
+	}
+
+	// The variant decides whether drops are allowed at all
+	allowed, reason := s.rules.CanDrop(s, kind, dest)
+	if !allowed {
+		return false, reason
+	}
+
+	if s.reserves[s.turn][kind] < 1 {
+		return false, "You do not have that piece in your reserve"
+	}
+
+	if s.board.State[dest.Y][dest.X] != nil {
+		return false, "You can only drop onto an empty space"
+	}
+
+	// Apply the drop. We will revert this if it leaves us in check
+	piece := kind.create(s.turn)
+	s.board.State[dest.Y][dest.X] = piece
+
+	if s.kingInCheck() {
+		s.board.State[dest.Y][dest.X] = nil
+		return false, "That move results in check"
+	}
+
+	// Dropped pieces can never castle, but a pawn dropped on its starting row may still move 2 spaces
+	if kind != PAWN || dest.Y != pawnStartRow(s.turn) {
+		piece.Moved()
+	}
+
+	s.reserves[s.turn][kind]--
+	s.lastMove = []Position{dest}
+	s.moves = append(s.moves, kind.String()+"@"+dest.String())
+	s.history = append(s.history, PlayedMove{Drop: true, Piece: kind, Dest: dest})
+
+	return true, ""
+}
+
+func (s *State) hasLegalDrop() bool {
+	for kind := PAWN; kind < NUM_RESERVE_KINDS; kind++ {
+		if s.reserves[s.turn][kind] < 1 {
+			continue
+		}
+
+		// Try every space on a copy of the game so nothing changes
+		for row := 0; row < len(s.board.State); row++ {
+			for col := 0; col < len(s.board.State[row]); col++ {
+				trial := s.Clone()
+				if dropped, _ := trial.DropPiece(kind, Position{X: col, Y: row}); dropped {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+func KindOf(piece IPiece) (PieceKind, bool) {
+	switch piece.(type) {
+	case *Pawn:
+		return PAWN, true
+	case *Knight:
+		return KNIGHT, true
+	case *Bishop:
+		return BISHOP, true
+	case *Rook:
+		return ROOK, true
+	case *Queen:
+		return QUEEN, true
+	default:
+		// Kings can never be captured, so they have no reserve kind
+		return 0, false
+	}
+}
+
+func ParsePieceKind(letter string) (PieceKind, error) {
+	// Knights are shown as N on the board, H is still accepted since older boards showed them that way
+	switch strings.ToUpper(letter) {
+	case "P":
+		return PAWN, nil
+	case "N", "H":
+		return KNIGHT, nil
+	case "B":
+		return BISHOP, nil
+	case "R":
+		return ROOK, nil
+	case "Q":
+		return QUEEN, nil
+	default:
+		return 0, fmt.Errorf("unknown piece %s", letter)
+	}
+}
+
+func (k PieceKind) String() string {
+	switch k {
+	case PAWN:
+		return "P"
+	case KNIGHT:
+		return "N"
+	case BISHOP:
+		return "B"
+	case ROOK:
+		return "R"
+	case QUEEN:
+		return "Q"
+	default:
+		return "?"
+	}
+}
+
+func (k PieceKind) create(colour Colour) IPiece {
+	switch k {
+	case KNIGHT:
+		return NewKnight(colour)
+	case BISHOP:
+		return NewBishop(colour)
+	case ROOK:
+		return NewRook(colour)
+	case QUEEN:
+		return NewQueen(colour)
+	default:
+		return NewPawn(colour)
+	}
+}
+
+func pawnStartRow(colour Colour) int {
+	if colour == WHITE {
+		return 6
+	}
+	return 1
+}
@@ -3,21 +3,142 @@ package chess
 import "project-go/logging"
 
 type State struct {
-	board Board
-	turn  Colour
+	board         Board
+	turn          Colour
+	rules         Rules
+	startPosition int
+	checksGiven   [2]int
+	reserves      [2][NUM_RESERVE_KINDS]int
+	lastMove      []Position
+	moves         []string
+	history       []PlayedMove
+}
+
+// A move as it was played, with enough detail to play it again on another copy of the game
+type PlayedMove struct {
+	Drop   bool      // Whether a piece was dropped from the reserve instead of moved on the board
+	Piece  PieceKind // Which piece was dropped, only used for drops
+	Source Position  // Where the piece moved from, only used for moves
+	Dest   Position  // Where the piece ended up
 }
 
 func CreateState() State {
-	state := State{board: Generate(), turn: WHITE}
+	return CreateVariantState(STANDARD, STANDARD_START_POSITION)
+}
+
+func CreateVariantState(variant Variant, startPosition int) State {
+	rules, err := NewRules(variant)
+	if err != nil {
+		// Fall back to standard chess, callers should check the variant is supported first
+		rules = standardRules{}
+	}
+
+	// Only Chess960 has a choice of starting position, callers should check it is valid first as well
+	if rules.Variant() != CHESS960 || !ValidStartPosition(startPosition) {
+		startPosition = STANDARD_START_POSITION
+	}
+
+	state := State{
+		board:         rules.StartingBoard(startPosition),
+		turn:          WHITE,
+		rules:         rules,
+		startPosition: startPosition,
+	}
 
 	return state
 }
 
+func (s *State) Clone() State {
+	clone := *s
+
+	// Cap the history so that moves on the clone never write into our copy
+	clone.moves = s.moves[:len(s.moves):len(s.moves)]
+	clone.history = s.history[:len(s.history):len(s.history)]
+
+	// The pieces are pointers, so they need copying too or moves on the clone would mark them as moved
+	for row := range s.board.State {
+		for col := range s.board.State[row] {
+			clone.board.State[row][col] = clonePiece(s.board.State[row][col])
+		}
+	}
+
+	return clone
+}
+
+func (s *State) Variant() Variant {
+	return s.rules.Variant()
+}
+
+func (s *State) StartPosition() int {
+	return s.startPosition
+}
+
+func (s *State) Turn() Colour {
+	return s.turn
+}
+
+func (s *State) ChecksGiven(colour Colour) int {
+	return s.checksGiven[colour]
+}
+
 func (s *State) Print() {
-	s.board.Print()
+	s.board.Render(s.Highlights())
+}
+
+func (s *State) Highlights() Highlights {
+	highlights := Highlights{LastMove: s.lastMove}
+
+	// Point out the King of the player to move if they are in check
+	if s.kingInCheck() {
+		kingPos, _ := s.findKing()
+		highlights.Check = &kingPos
+	}
+
+	return highlights
+}
+
+func (s *State) InCheck() bool {
+	return s.kingInCheck()
+}
+
+func (s *State) Board() Board {
+	return s.board
+}
+
+func (s *State) LastMove() []Position {
+	return s.lastMove
+}
+
+func (s *State) Moves() []string {
+	return s.moves
+}
+
+func (s *State) History() []PlayedMove {
+	return s.history
+}
+
+// Plays a move from another copy of the game and hands the turn over, the same as if the player had just made it
+func (s *State) Replay(move PlayedMove) (bool, string) {
+	var ok bool
+	var reason string
+	if move.Drop {
+		ok, reason = s.DropPiece(move.Piece, move.Dest)
+	} else {
+		ok, reason = s.MovePiece(move.Source, move.Dest)
+	}
+
+	if ok {
+		s.SwitchTurn()
+	}
+	return ok, reason
 }
 
 func (s *State) MovePiece(source Position, dest Position) (bool, string) {
+	// Both ends are looked up on the board, so they have to be on it
+	if !source.OnBoard() || !dest.OnBoard() {
+		return false, "That position is not on the board"
+	}
+
 	piece := s.board.State[source.Y][source.X]
 
 	if piece == nil {
@@ -28,6 +149,17 @@ func (s *State) MovePiece(source Position, dest Position) (bool, string) {
 		return false, "That is not your piece"
 	}
 
+	// The variant may restrict which moves are allowed
+	allowed, reason := s.rules.CanMove(s, source, dest)
+	if !allowed {
+		return false, reason
+	}
+
+	// Castling is a special case where the King may move onto its own Rook
+	if s.isCastle(source, dest) {
+		return s.castle(source, dest)
+	}
+
 	collidingPiece := s.board.State[dest.Y][dest.X]
 	// Colliding with an enemy piece is fine
 	if collidingPiece != nil && collidingPiece.Colour() == piece.Colour() {
@@ -52,7 +184,7 @@ func (s *State) MovePiece(source Position, dest Position) (bool, string) {
 	if s.kingInCheck() {
 		// The King is in check, revert this movement
 		s.board.State[source.Y][source.X] = piece
-		s.board.State[dest.Y][dest.X] = nil
+		s.board.State[dest.Y][dest.X] = collidingPiece
 		return false, "That move results in check"
 	}
 
@@ -60,23 +192,29 @@ func (s *State) MovePiece(source Position, dest Position) (bool, string) {
 	// This is used so Pawns can move forward 2 only if they have not moved
 	piece.Moved()
 
+	// Let the variant know about any captured piece
+	if collidingPiece != nil {
+		s.rules.Captured(s, collidingPiece)
+	}
+
+	s.lastMove = []Position{source, dest}
+	s.moves = append(s.moves, source.String()+"-"+dest.String())
+	s.history = append(s.history, PlayedMove{Source: source, Dest: dest})
+
 	return true, ""
 }
 
 func (s *State) SwitchTurn() {
-	if s.turn == WHITE {
-		s.turn = BLACK
-	} else {
-		s.turn = WHITE
+	s.turn = s.turn.Opponent()
+
+	// Keep track of how often each side has put the other in check
+	if s.kingInCheck() {
+		s.checksGiven[s.turn.Opponent()]++
 	}
 }
 
 func (s *State) PrintTurn() {
-	if s.turn == WHITE {
-		logging.Log("WHITE")
-	} else {
-		logging.Log("BLACK")
-	}
+	logging.Log(s.turn.String())
 }
 
 func (s *State) kingInCheck() bool {
@@ -89,21 +227,25 @@ func (s *State) kingInCheck() bool {
 		return false
 	}
 
-	// Now, loop over every piece on the board
-	// If they are an enemy piece, see if they can move to the King
+	return s.squareAttacked(kingPos, s.turn)
+}
+
+func (s *State) squareAttacked(pos Position, defender Colour) bool {
+	// Loop over every piece on the board
+	// If they are an enemy piece, see if they can move to the given position
 	for row := 0; row < len(s.board.State); row++ {
 		for col := 0; col < len(s.board.State[row]); col++ {
 			enemyPiece := s.board.State[row][col]
 
-			// Ignore empty spaces and our own pieces
-			if enemyPiece == nil || enemyPiece.Colour() == s.turn {
+			// Ignore empty spaces and the defender's pieces
+			if enemyPiece == nil || enemyPiece.Colour() == defender {
 				continue
 			}
 
-			// We found an enemy piece, see if they can move to the King
+			// We found an enemy piece, see if they can move to the position
 			enemyMove := Movement{
 				old:       Position{X: col, Y: row},
-				new:       kingPos,
+				new:       pos,
 				wouldTake: true,
 			}
 
@@ -111,13 +253,13 @@ func (s *State) kingInCheck() bool {
 			hasCollision := enemyPiece.HasMovementCollision()
 
 			if canMove && (!hasCollision || !s.wouldCollide(enemyMove)) {
-				// The King is in check
+				// The position is under attack
 				return true
 			}
 		}
 	}
 
-	// The King is not in check
+	// The position is not under attack
 	return false
 }
 
@@ -141,6 +283,12 @@ func (s *State) findKing() (Position, bool) {
 }
 
 func (s *State) wouldCollide(movement Movement) bool {
+	// Only straight line movements have a path to check
+	// Anything else would walk off the board, and is rejected by the piece's own movement rules
+	if !movingDiagonal(movement) && !movingCardinal(movement) {
+		return false
+	}
+
 	xDiff := movement.new.X - movement.old.X
 	yDiff := movement.new.Y - movement.old.Y
 
@@ -0,0 +1,25 @@
+package chess
+
+// Source https://en.wikipedia.org/wiki/King_of_the_hill_(chess)
+
+// King of the Hill plays like standard chess, but a King reaching one of the four centre squares wins
+type kingOfTheHillRules struct {
+	standardRules
+}
+
+func (r kingOfTheHillRules) Variant() Variant {
+	return KING_OF_THE_HILL
+}
+
+func (r kingOfTheHillRules) Outcome(s *State) Outcome {
+	for row := 3; row <= 4; row++ {
+		for col := 3; col <= 4; col++ {
+			king, isKing := s.board.State[row][col].(*King)
+			if isKing {
+				return Outcome{Over: true, Winner: king.Colour(), Reason: "King reached the centre"}
+			}
+		}
+	}
+
+	return r.standardRules.Outcome(s)
+}
@@ -0,0 +1,53 @@
+package chess
+
+import "testing"
+
+func TestKingReachingTheCentreWins(t *testing.T) {
+	for _, centre := range []Position{{X: 3, Y: 3}, {X: 4, Y: 3}, {X: 3, Y: 4}, {X: 4, Y: 4}} {
+		// The King steps in from whichever side of the centre is nearest
+		start := Position{X: centre.X, Y: centre.Y + 1}
+		if centre.Y == 3 {
+			start.Y = 2
+		}
+		state := newTestState(kingOfTheHillRules{}, map[Position]IPiece{
+			start:        NewKing(WHITE),
+			{X: 0, Y: 7}: NewRook(WHITE),
+			{X: 7, Y: 0}: NewKing(BLACK),
+		})
+
+		// Any other piece in the centre is just a move
+		ok, reason := state.Replay(PlayedMove{Source: Position{X: 0, Y: 7}, Dest: Position{X: 0, Y: 5}})
+		if !ok {
+			t.Fatalf("moving the rook: %s", reason)
+		}
+		state.Replay(PlayedMove{Source: Position{X: 7, Y: 0}, Dest: Position{X: 7, Y: 1}})
+		if state.Outcome().Over {
+			t.Fatalf("game ended before a King reached the centre: %s", state.Outcome())
+		}
+
+		ok, reason = state.Replay(PlayedMove{Source: start, Dest: centre})
+		if !ok {
+			t.Fatalf("moving the King to %s: %s", centre, reason)
+		}
+		outcome := state.Outcome()
+		if !outcome.Over || outcome.Draw || outcome.Winner != WHITE || outcome.Reason != "King reached the centre" {
+			t.Errorf("King on %s gave %+v, expected a win for WHITE", centre, outcome)
+		}
+	}
+}
+
+func TestPiecesInTheCentreDoNotWin(t *testing.T) {
+	state := newTestState(kingOfTheHillRules{}, map[Position]IPiece{
+		{X: 4, Y: 7}: NewKing(WHITE),
+		{X: 3, Y: 7}: NewQueen(WHITE),
+		{X: 4, Y: 0}: NewKing(BLACK),
+	})
+
+	ok, reason := state.Replay(PlayedMove{Source: Position{X: 3, Y: 7}, Dest: Position{X: 3, Y: 4}})
+	if !ok {
+		t.Fatalf("moving the queen: %s", reason)
+	}
+	if outcome := state.Outcome(); outcome.Over {
+		t.Errorf("a queen in the centre ended the game: %+v", outcome)
+	}
+}
@@ -0,0 +1,61 @@
+package chess
+
+type Outcome struct {
+	Over   bool
+	Draw   bool
+	Winner Colour
+	Reason string
+}
+
+func (o Outcome) String() string {
+	if !o.Over {
+		return "The game is still in progress"
+	}
+
+	if o.Draw {
+		return o.Reason + ", the game is a draw"
+	}
+
+	return o.Reason + ", " + o.Winner.String() + " wins"
+}
+
+func (s *State) Outcome() Outcome {
+	return s.rules.Outcome(s)
+}
+
+func (s *State) hasLegalMove() bool {
+	// Try every move for every one of our pieces, on a copy of the game so nothing changes
+	for row := 0; row < len(s.board.State); row++ {
+		for col := 0; col < len(s.board.State[row]); col++ {
+			piece := s.board.State[row][col]
+			if piece == nil || piece.Colour() != s.turn {
+				continue
+			}
+
+			source := Position{X: col, Y: row}
+			if s.pieceHasLegalMove(source) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (s *State) pieceHasLegalMove(source Position) bool {
+	for row := 0; row < len(s.board.State); row++ {
+		for col := 0; col < len(s.board.State[row]); col++ {
+			dest := Position{X: col, Y: row}
+			if dest == source {
+				continue
+			}
+
+			trial := s.Clone()
+			if moved, _ := trial.MovePiece(source, dest); moved {
+				return true
+			}
+		}
+	}
+
+	return false
+}
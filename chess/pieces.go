@@ -1,6 +1,9 @@
 package chess
 
-import "project-go/util"
+import (
+	"project-go/util"
+	"strconv"
+)
 
 // Source https://en.wikipedia.org/wiki/Rules_of_chess
 
@@ -9,6 +12,16 @@ type Position struct {
 	Y int
 }
 
+func (p Position) String() string {
+	// Matches the letternumber format used to enter moves, eg a4
+	return string(rune('a'+p.X)) + strconv.Itoa(p.Y)
+}
+
+// Positions can come from the other side, so they are checked before being used to look at the board
+func (p Position) OnBoard() bool {
+	return p.X >= 0 && p.X < 8 && p.Y >= 0 && p.Y < 8
+}
+
 type Movement struct {
 	old       Position
 	new       Position
@@ -22,6 +35,20 @@ const (
 	BLACK
 )
 
+func (c Colour) String() string {
+	if c == WHITE {
+		return "WHITE"
+	}
+	return "BLACK"
+}
+
+func (c Colour) Opponent() Colour {
+	if c == WHITE {
+		return BLACK
+	}
+	return WHITE
+}
+
 type Piece struct {
 	MovementCollision bool
 	HasMoved          bool
@@ -73,7 +100,7 @@ func NewBishop(colour Colour) *Bishop {
 }
 
 func NewKnight(colour Colour) *Knight {
-	return &Knight{Piece{MovementCollision: false, HasMoved: false, text: "H", colour: colour}}
+	return &Knight{Piece{MovementCollision: false, HasMoved: false, text: "N", colour: colour}}
 }
 
 func NewPawn(colour Colour) *Pawn {
@@ -115,36 +142,53 @@ func (p Pawn) CanMove(movement Movement) bool {
 	// if taking, can move diagonal
 	// if not taking, cannot move diagonal
 
+	xDiff := util.Abs(movement.new.X - movement.old.X)
 	yDiff := movement.new.Y - movement.old.Y
 
-	// Pawns that have moved can only move one space
-	distance := moveDistance(movement)
-	if p.HasMoved && distance > 1 {
-		return false
+	// can only move forward - up if white, down if black
+	forward := 1
+	if p.colour == WHITE {
+		forward = -1
 	}
 
-	// If the pawn has not moved yet, it is allowed to move 2 spaces
-	if !p.HasMoved && distance > 2 {
-		return false
+	// Taking is only ever one space diagonally forward
+	if movement.wouldTake {
+		return xDiff == 1 && yDiff == forward
 	}
 
-	// If the pawn is moving 2 spaces, it cannot move diagonal
-	if distance == 2 && movingDiagonal(movement) {
+	// Not taking, so the pawn must move straight forward
+	if xDiff != 0 {
 		return false
 	}
 
-	// Moving diagonal when not taking is invalid
-	if !movement.wouldTake && movingDiagonal(movement) {
-		return false
-	}
-
-	// Not moving diagonal when taking is invalid
-	if movement.wouldTake && !movingDiagonal(movement) {
-		return false
+	// If the pawn has not moved yet, it is allowed to move 2 spaces
+	return yDiff == forward || (!p.HasMoved && yDiff == 2*forward)
+}
+
+func clonePiece(piece IPiece) IPiece {
+	// Copy the underlying piece so that the copy can be moved independently
+	switch casted := piece.(type) {
+	case *King:
+		copied := *casted
+		return &copied
+	case *Queen:
+		copied := *casted
+		return &copied
+	case *Rook:
+		copied := *casted
+		return &copied
+	case *Bishop:
+		copied := *casted
+		return &copied
+	case *Knight:
+		copied := *casted
+		return &copied
+	case *Pawn:
+		copied := *casted
+		return &copied
+	default:
+		return nil
 	}
-
-	// can only move forward - up if white, down if black
-	return (p.colour == BLACK && yDiff > 0) || (p.colour == WHITE && yDiff < 0)
 }
 
 func (p *Piece) Representation() string {
@@ -0,0 +1,176 @@
+package chess
+
+import (
+	"fmt"
+	"os"
+	"project-go/logging"
+	"project-go/util"
+)
+
+type RenderStyle int
+
+const (
+	ASCII_RENDER RenderStyle = iota
+	UNICODE_RENDER
+)
+
+// ANSI escape codes for the board colours
+const (
+	ANSI_RESET       = "\033[0m"
+	ANSI_LIGHT       = "\033[48;5;180m"
+	ANSI_DARK        = "\033[48;5;137m"
+	ANSI_LAST_MOVE   = "\033[48;5;143m"
+	ANSI_CHECK       = "\033[48;5;160m"
+	ANSI_SELECTED    = "\033[48;5;74m"
+	ANSI_CURSOR      = "\033[1;94m"
+	ANSI_WHITE_PIECE = "\033[1;97m"
+	ANSI_BLACK_PIECE = "\033[1;30m"
+)
+
+var renderStyle = ASCII_RENDER
+
+func InitRenderer() {
+	// Use the fancy board only when we are writing to a terminal that can show it
+	renderStyle = UNICODE_RENDER
+	if !util.IsTerminal(int(os.Stdout.Fd())) {
+		renderStyle = ASCII_RENDER
+	}
+
+	// Check the arguments for the --ascii flag, which forces the plain board
+	for argIndex := range os.Args {
+		if os.Args[argIndex] == "--ascii" {
+			renderStyle = ASCII_RENDER
+		}
+	}
+}
+
+func SetRenderStyle(style RenderStyle) {
+	renderStyle = style
+}
+
+// Squares worth drawing attention to when rendering the board
+type Highlights struct {
+	LastMove []Position
+	Check    *Position
+	Cursor   *Position
+	Selected *Position
+}
+
+func (b Board) Render(highlights Highlights) {
+	for _, line := range b.Lines(renderStyle, highlights) {
+		logging.Log(line)
+	}
+}
+
+func (b Board) Lines(style RenderStyle, highlights Highlights) []string {
+	if style == ASCII_RENDER {
+		return b.asciiLines()
+	}
+	return b.unicodeLines(highlights)
+}
+
+func (b Board) asciiLines() []string {
+	// Start with the column letters
+	lines := []string{"   a  b  c  d  e  f  g  h", ""}
+
+	for row := 0; row < len(b.State); row++ {
+		// Start with the row number
+		line := fmt.Sprintf("%d ", row)
+
+		for col := 0; col < len(b.State[row]); col++ {
+			piece := b.State[row][col]
+
+			if piece != nil {
+				line += piece.Representation() + " "
+			} else {
+				// Empty space is represented by a dot
+				line += " . "
+			}
+		}
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+func (b Board) unicodeLines(highlights Highlights) []string {
+	// Start with the column letters
+	lines := []string{"   a  b  c  d  e  f  g  h"}
+
+	for row := 0; row < len(b.State); row++ {
+		// Start with the row number
+		line := fmt.Sprintf("%d ", row)
+
+		for col := 0; col < len(b.State[row]); col++ {
+			pos := Position{X: col, Y: row}
+			line += squareColour(pos, highlights)
+
+			// The cursor is drawn as brackets around the square
+			left, right := " ", " "
+			if highlights.Cursor != nil && *highlights.Cursor == pos {
+				left, right = ANSI_CURSOR+"[", ANSI_CURSOR+"]"
+			}
+
+			piece := b.State[row][col]
+			if piece != nil {
+				line += left + pieceColour(piece) + Glyph(piece) + right
+			} else {
+				line += left + " " + right
+			}
+			line += ANSI_RESET
+		}
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+func squareColour(pos Position, highlights Highlights) string {
+	// A King in check takes priority over everything else
+	if highlights.Check != nil && *highlights.Check == pos {
+		return ANSI_CHECK
+	}
+
+	if highlights.Selected != nil && *highlights.Selected == pos {
+		return ANSI_SELECTED
+	}
+
+	for _, moved := range highlights.LastMove {
+		if moved == pos {
+			return ANSI_LAST_MOVE
+		}
+	}
+
+	// The top left square is light, and the colours alternate from there
+	if (pos.X+pos.Y)%2 == 0 {
+		return ANSI_LIGHT
+	}
+	return ANSI_DARK
+}
+
+func pieceColour(piece IPiece) string {
+	if piece.Colour() == WHITE {
+		return ANSI_WHITE_PIECE
+	}
+	return ANSI_BLACK_PIECE
+}
+
+func Glyph(piece IPiece) string {
+	// The solid glyphs are used for both sides, since the colour is drawn separately
+	switch piece.(type) {
+	case *King:
+		return "♚"
+	case *Queen:
+		return "♛"
+	case *Rook:
+		return "♜"
+	case *Bishop:
+		return "♝"
+	case *Knight:
+		return "♞"
+	case *Pawn:
+		return "♟"
+	default:
+		return "?"
+	}
+}
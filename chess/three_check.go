@@ -0,0 +1,25 @@
+package chess
+
+// Source https://en.wikipedia.org/wiki/Three-check_chess
+
+// The number of checks needed to win a game of Three-Check
+const CHECKS_TO_WIN = 3
+
+// Three-Check plays like standard chess, but putting the enemy King in check three times wins
+type threeCheckRules struct {
+	standardRules
+}
+
+func (r threeCheckRules) Variant() Variant {
+	return THREE_CHECK
+}
+
+func (r threeCheckRules) Outcome(s *State) Outcome {
+	for _, colour := range []Colour{WHITE, BLACK} {
+		if s.ChecksGiven(colour) >= CHECKS_TO_WIN {
+			return Outcome{Over: true, Winner: colour, Reason: "Third check"}
+		}
+	}
+
+	return r.standardRules.Outcome(s)
+}
@@ -0,0 +1,81 @@
+package chess
+
+import "testing"
+
+// A white Rook chases the black King up the board, checking it on every move
+func newChasedKing() State {
+	return newTestState(threeCheckRules{}, map[Position]IPiece{
+		{X: 4, Y: 7}: NewKing(WHITE),
+		{X: 0, Y: 6}: NewRook(WHITE),
+		{X: 7, Y: 0}: NewKing(BLACK),
+	})
+}
+
+var chase = []PlayedMove{
+	{Source: Position{X: 0, Y: 6}, Dest: Position{X: 0, Y: 0}}, // Check
+	{Source: Position{X: 7, Y: 0}, Dest: Position{X: 7, Y: 1}},
+	{Source: Position{X: 0, Y: 0}, Dest: Position{X: 0, Y: 1}}, // Check
+	{Source: Position{X: 7, Y: 1}, Dest: Position{X: 7, Y: 2}},
+	{Source: Position{X: 0, Y: 1}, Dest: Position{X: 0, Y: 2}}, // Check
+}
+
+func TestThirdCheckWins(t *testing.T) {
+	state := newChasedKing()
+
+	for i, move := range chase {
+		ok, reason := state.Replay(move)
+		if !ok {
+			t.Fatalf("move %d: %s", i, reason)
+		}
+
+		checks := (i + 2) / 2
+		if given := state.ChecksGiven(WHITE); given != checks {
+			t.Errorf("after move %d WHITE has given %d checks, expected %d", i, given, checks)
+		}
+		if state.ChecksGiven(BLACK) != 0 {
+			t.Errorf("after move %d BLACK has given %d checks", i, state.ChecksGiven(BLACK))
+		}
+
+		outcome := state.Outcome()
+		if checks < CHECKS_TO_WIN && outcome.Over {
+			t.Fatalf("game ended after %d checks: %+v", checks, outcome)
+		}
+		if checks == CHECKS_TO_WIN && (!outcome.Over || outcome.Winner != WHITE || outcome.Reason != "Third check") {
+			t.Errorf("third check gave %+v, expected a win for WHITE", outcome)
+		}
+	}
+}
+
+func TestChecksSurviveCloneAndReplay(t *testing.T) {
+	state := newChasedKing()
+	for _, move := range chase[:3] {
+		state.Replay(move)
+	}
+
+	// A clone starts with the same count, and counts on its own from there
+	clone := state.Clone()
+	if clone.ChecksGiven(WHITE) != 2 {
+		t.Errorf("clone has %d checks, expected 2", clone.ChecksGiven(WHITE))
+	}
+	for _, move := range chase[3:] {
+		clone.Replay(move)
+	}
+	if clone.ChecksGiven(WHITE) != 3 || state.ChecksGiven(WHITE) != 2 {
+		t.Errorf("after moving the clone it has %d checks and the original %d", clone.ChecksGiven(WHITE), state.ChecksGiven(WHITE))
+	}
+	if !clone.Outcome().Over || state.Outcome().Over {
+		t.Errorf("only the clone should have reached the third check")
+	}
+
+	// Replaying the history on a fresh copy of the game counts the same checks
+	replayed := newChasedKing()
+	for i, move := range clone.History() {
+		ok, reason := replayed.Replay(move)
+		if !ok {
+			t.Fatalf("replaying move %d: %s", i, reason)
+		}
+	}
+	if replayed.ChecksGiven(WHITE) != 3 || !replayed.Outcome().Over {
+		t.Errorf("replayed game has %d checks, expected 3", replayed.ChecksGiven(WHITE))
+	}
+}
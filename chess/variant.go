@@ -0,0 +1,131 @@
+package chess
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Variant int
+
+const (
+	STANDARD Variant = iota
+	CHESS960
+	KING_OF_THE_HILL
+	THREE_CHECK
+	CRAZYHOUSE
+)
+
+// Rules allow a variant to override how the game is set up, which moves are allowed, and how the game is won
+type Rules interface {
+	Variant() Variant
+	StartingBoard(startPosition int) Board
+	CanMove(s *State, source Position, dest Position) (bool, string)
+	CanDrop(s *State, kind PieceKind, dest Position) (bool, string)
+	Captured(s *State, piece IPiece)
+	Outcome(s *State) Outcome
+}
+
+func NewRules(variant Variant) (Rules, error) {
+	switch variant {
+	case STANDARD:
+		return standardRules{}, nil
+	case CHESS960:
+		return chess960Rules{}, nil
+	case KING_OF_THE_HILL:
+		return kingOfTheHillRules{}, nil
+	case THREE_CHECK:
+		return threeCheckRules{}, nil
+	case CRAZYHOUSE:
+		return crazyhouseRules{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported variant %d", variant)
+	}
+}
+
+func (v Variant) String() string {
+	switch v {
+	case STANDARD:
+		return "standard"
+	case CHESS960:
+		return "chess960"
+	case KING_OF_THE_HILL:
+		return "kingofthehill"
+	case THREE_CHECK:
+		return "threecheck"
+	case CRAZYHOUSE:
+		return "crazyhouse"
+	default:
+		return "unknown"
+	}
+}
+
+func (v Variant) Supported() bool {
+	_, err := NewRules(v)
+	return err == nil
+}
+
+func SupportedVariants() []Variant {
+	// Variants are numbered contiguously, so stop at the first one without rules
+	variants := make([]Variant, 0)
+	for variant := STANDARD; variant.Supported(); variant++ {
+		variants = append(variants, variant)
+	}
+	return variants
+}
+
+func ParseVariant(name string) (Variant, error) {
+	// Accept the variant names case insensitively, along with some common aliases
+	switch strings.ToLower(name) {
+	case "", "standard", "classic":
+		return STANDARD, nil
+	case "chess960", "960", "fischer", "fischerrandom":
+		return CHESS960, nil
+	case "kingofthehill", "koth", "hill":
+		return KING_OF_THE_HILL, nil
+	case "threecheck", "3check", "3-check":
+		return THREE_CHECK, nil
+	case "crazyhouse", "zh":
+		return CRAZYHOUSE, nil
+	default:
+		return STANDARD, fmt.Errorf("unknown variant %s", name)
+	}
+}
+
+// The standard rules of chess, which every other variant builds on
+type standardRules struct{}
+
+func (r standardRules) Variant() Variant {
+	return STANDARD
+}
+
+func (r standardRules) StartingBoard(startPosition int) Board {
+	// There is only one starting position in standard chess
+	return Generate()
+}
+
+func (r standardRules) CanMove(s *State, source Position, dest Position) (bool, string) {
+	// Standard chess has no restrictions beyond how the pieces move
+	return true, ""
+}
+
+func (r standardRules) CanDrop(s *State, kind PieceKind, dest Position) (bool, string) {
+	// Only some variants let pieces be dropped onto the board
+	return false, "Pieces cannot be dropped in this variant"
+}
+
+func (r standardRules) Captured(s *State, piece IPiece) {
+	// Captured pieces are simply removed from the game
+}
+
+func (r standardRules) Outcome(s *State) Outcome {
+	// The game is only over once the player to move is out of legal moves
+	if s.hasLegalMove() || s.hasLegalDrop() {
+		return Outcome{}
+	}
+
+	if s.kingInCheck() {
+		return Outcome{Over: true, Winner: s.turn.Opponent(), Reason: "Checkmate"}
+	}
+
+	return Outcome{Over: true, Draw: true, Reason: "Stalemate"}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"project-go/chess"
+	"time"
+)
+
+// Tracks how long each player has spent thinking during the current game
+type GameClock struct {
+	elapsed   [2]time.Duration
+	running   bool
+	turn      chess.Colour
+	turnStart time.Time
+}
+
+func (g *GameClock) Start() {
+	// White always moves first
+	g.elapsed = [2]time.Duration{}
+	g.running = true
+	g.turn = chess.WHITE
+	g.turnStart = time.Now()
+}
+
+func (g *GameClock) Switch(turn chess.Colour) {
+	if !g.running {
+		return
+	}
+
+	// Charge the time so far to whoever was moving, then start timing the next player
+	g.elapsed[g.turn] += time.Since(g.turnStart)
+	g.turn = turn
+	g.turnStart = time.Now()
+}
+
+func (g *GameClock) Stop() {
+	if !g.running {
+		return
+	}
+
+	g.elapsed[g.turn] += time.Since(g.turnStart)
+	g.running = false
+}
+
+func (g *GameClock) Elapsed(colour chess.Colour) time.Duration {
+	elapsed := g.elapsed[colour]
+
+	// Include the time spent on the move currently being made
+	if g.running && g.turn == colour {
+		elapsed += time.Since(g.turnStart)
+	}
+
+	return elapsed
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"project-go/chess"
+	"project-go/logging"
+	"project-go/networking"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A whole client on a loopback network, driven one tick at a time instead of by its input and receive threads
+type testClient struct {
+	ctx    *Context
+	link   *networking.LoopbackLink
+	output strings.Builder
+}
+
+// Several clients sharing one loopback network and one fake clock
+type testNetwork struct {
+	t       *testing.T
+	network *networking.LoopbackNetwork
+	clock   *networking.FakeClock
+	clients []*testClient
+	current *testClient
+}
+
+func newTestNetwork(t *testing.T) *testNetwork {
+	n := &testNetwork{t: t, network: networking.NewLoopbackNetwork(), clock: networking.NewFakeClock(time.Unix(1000, 0))}
+
+	// Output is kept per client, so each test can check what its player was told
+	logging.SetOutput(func(text string) {
+		if n.current != nil {
+			n.current.output.WriteString(text)
+		}
+	})
+	t.Cleanup(func() { logging.SetOutput(nil) })
+
+	return n
+}
+
+func (n *testNetwork) addClient() *testClient {
+	client := &testClient{link: n.network.Attach()}
+	client.ctx = &Context{
+		GameState:   chess.CreateState(),
+		ClientState: MENU,
+		Lobbies:     make(map[string]LobbyListing),
+	}
+	client.ctx.Host = networking.NewHost(client.link, n.clock)
+	client.ctx.Connections = networking.NewConnectionTable(client.ctx.Host)
+	client.ctx.Connection = networking.NewConnection(client.ctx.Host)
+
+	n.clients = append(n.clients, client)
+	return client
+}
+
+// Runs every client for the given number of ticks, handing each its frames before it ticks
+func (n *testNetwork) run(ticks int) {
+	for i := 0; i < ticks; i++ {
+		for _, client := range n.clients {
+			n.current = client
+			for {
+				frame, ok := client.link.TryReceiveFrame()
+				if !ok {
+					break
+				}
+				client.ctx.handleFrame(frame)
+			}
+			client.ctx.handleTick()
+		}
+		n.current = nil
+		n.clock.Advance(TICK_INTERVAL)
+	}
+}
+
+// Types a command into a client, then gives the network long enough to carry out whatever it started
+func (n *testNetwork) input(client *testClient, command string) {
+	n.current = client
+	client.ctx.handleInput(command)
+	n.current = nil
+	n.run(20)
+}
+
+func (n *testNetwork) expectState(client *testClient, state ClientState) {
+	n.t.Helper()
+	if client.ctx.ClientState != state {
+		n.t.Fatalf("client %s is in state %s, expected %s\n%s", client.link.LocalAddress(), client.ctx.ClientState, state, client.output.String())
+	}
+}
+
+func pieceAt(ctx *Context, square string) string {
+	pos, err := parsePosition(square)
+	if err != nil {
+		return "bad square " + square
+	}
+
+	piece := ctx.GameState.Board().State[pos.Y][pos.X]
+	if piece == nil {
+		return ""
+	}
+	return piece.Representation()
+}
+
+func TestLobbyJoinPlayAndForfeit(t *testing.T) {
+	n := newTestNetwork(t)
+	host, guest, latecomer := n.addClient(), n.addClient(), n.addClient()
+
+	n.input(host, ".start thegame")
+	n.expectState(host, LOBBY)
+
+	// The lobby can be found before joining it
+	n.input(guest, ".list")
+	listing, ok := guest.ctx.Lobbies["thegame"]
+	if !ok || listing.Variant != chess.STANDARD {
+		t.Fatalf("guest did not hear about the lobby: %+v", guest.ctx.Lobbies)
+	}
+
+	n.input(guest, ".join thegame")
+	n.expectState(host, LOBBY)
+	n.expectState(guest, LOBBY)
+
+	// A second player is turned away once the lobby has someone in it
+	n.input(latecomer, ".join thegame")
+	n.run(50)
+	n.expectState(latecomer, MENU)
+	if host.ctx.Connection.Peer().String() != guest.link.LocalAddress().String() {
+		t.Fatalf("host is playing %s, expected the guest", host.ctx.Connection.Peer())
+	}
+
+	n.input(host, ".start")
+	n.expectState(host, MY_TURN)
+	n.expectState(guest, THEIR_TURN)
+	if host.ctx.PlayerColour != chess.WHITE || guest.ctx.PlayerColour != chess.BLACK {
+		t.Fatalf("host is %s and guest is %s", host.ctx.PlayerColour, guest.ctx.PlayerColour)
+	}
+
+	n.input(host, ".move e6 e4")
+	n.expectState(host, THEIR_TURN)
+	n.expectState(guest, MY_TURN)
+
+	n.input(guest, ".move e1 e3")
+	n.input(host, ".move g7 f5")
+	n.expectState(guest, MY_TURN)
+
+	// A move that isn't ours to make is refused without reaching the other side
+	n.input(host, ".move d6 d4")
+	n.expectState(host, THEIR_TURN)
+
+	for _, client := range []*testClient{host, guest} {
+		expected := map[string]string{"e4": "wP", "e6": "", "e3": "bP", "e1": "", "f5": "wN", "g7": "", "d6": "wP"}
+		for square, piece := range expected {
+			if got := pieceAt(client.ctx, square); got != piece {
+				t.Errorf("client %s has %q on %s, expected %q", client.link.LocalAddress(), got, square, piece)
+			}
+		}
+	}
+	if len(host.ctx.GameState.History()) != 3 || len(guest.ctx.GameState.History()) != 3 {
+		t.Fatalf("expected 3 moves on both sides, host has %d and guest has %d", len(host.ctx.GameState.History()), len(guest.ctx.GameState.History()))
+	}
+
+	n.input(guest, ".forfeit")
+	n.run(100)
+	n.expectState(guest, MENU)
+	n.expectState(host, MENU)
+	if !strings.Contains(host.output.String(), "The other user has forfeit.") {
+		t.Errorf("host was not told about the forfeit:\n%s", host.output.String())
+	}
+
+	// Both sides have let go of the connection once the close is acknowledged
+	for _, client := range n.clients {
+		if len(client.ctx.Connections.Connections()) != 0 {
+			t.Errorf("client %s still has %d connections", client.link.LocalAddress(), len(client.ctx.Connections.Connections()))
+		}
+	}
+}
+
+func TestCheckmateEndsTheGame(t *testing.T) {
+	n := newTestNetwork(t)
+	host, guest := n.addClient(), n.addClient()
+
+	n.input(host, ".start mate")
+	n.input(guest, ".join mate")
+	n.input(host, ".start")
+
+	// The quickest checkmate there is
+	n.input(host, ".move f6 f5")
+	n.input(guest, ".move e1 e3")
+	n.input(host, ".move g6 g4")
+	n.input(guest, ".move d0 h4")
+	n.run(100)
+
+	n.expectState(host, MENU)
+	n.expectState(guest, MENU)
+	for _, client := range []*testClient{host, guest} {
+		if !strings.Contains(client.output.String(), "GAME OVER: Checkmate, BLACK wins") {
+			t.Errorf("client %s did not see the checkmate:\n%s", client.link.LocalAddress(), client.output.String())
+		}
+		if got := pieceAt(client.ctx, "h4"); got != "bQ" {
+			t.Errorf("client %s has %q on h4, expected the black queen", client.link.LocalAddress(), got)
+		}
+	}
+}
+
+func TestHostsCheckmateClosesTheLobby(t *testing.T) {
+	n := newTestNetwork(t)
+	host, guest, watcher, lister := n.addClient(), n.addClient(), n.addClient(), n.addClient()
+
+	n.input(host, ".start scholar")
+	n.input(guest, ".join scholar")
+	n.input(watcher, ".watch scholar")
+	n.input(host, ".start")
+
+	// Scholar's mate, so the host's own move is the one that ends the game
+	n.input(host, ".move e6 e4")
+	n.input(guest, ".move e1 e3")
+	n.input(host, ".move f7 c4")
+	n.input(guest, ".move b0 c2")
+	n.input(host, ".move d7 h3")
+	n.input(guest, ".move g0 f2")
+	n.input(host, ".move h3 f1")
+	n.run(100)
+
+	for _, client := range []*testClient{host, guest, watcher} {
+		n.expectState(client, MENU)
+		if !strings.Contains(client.output.String(), "GAME OVER: Checkmate, WHITE wins") {
+			t.Errorf("client %s did not see the checkmate:\n%s", client.link.LocalAddress(), client.output.String())
+		}
+	}
+
+	// The lobby is gone along with the game, nobody is left connected and nothing is advertised
+	if host.ctx.Lobby.hosting {
+		t.Errorf("host is still hosting the finished game")
+	}
+	if len(host.ctx.Connections.Connections()) != 0 || len(host.ctx.Spectators) != 0 {
+		t.Errorf("host still has %d connections and %d spectators", len(host.ctx.Connections.Connections()), len(host.ctx.Spectators))
+	}
+	// Lobbies heard about before are remembered, so only a fresh answer counts
+	lister.ctx.Lobbies = make(map[string]LobbyListing)
+	n.input(lister, ".list")
+	if _, ok := lister.ctx.Lobbies["scholar"]; ok {
+		t.Errorf("the finished game is still listed")
+	}
+}
+
+func TestGameSyncOffTheBoardIsRefused(t *testing.T) {
+	n := newTestNetwork(t)
+	host, guest, watcher := n.addClient(), n.addClient(), n.addClient()
+
+	n.input(host, ".start offboard")
+	n.input(guest, ".join offboard")
+	n.input(host, ".start")
+	n.input(host, ".move e6 e4")
+
+	// A resync claiming a move from somewhere that isn't on the board
+	sync := networking.NewGameSync(&guest.ctx.GameState)
+	sync.Moves = append(sync.Moves, chess.PlayedMove{Source: chess.Position{X: 4, Y: 9}, Dest: chess.Position{X: -1, Y: 3}})
+
+	n.current = guest
+	if state := handleGameSync(guest.ctx, sync); state != MENU {
+		t.Errorf("player is in state %s after an impossible resync, expected to leave the game", state)
+	}
+
+	// A spectator is sent the whole game the same way, and must not follow it off the board either
+	n.current = watcher
+	watcher.ctx.ClientState = SPECTATING
+	if state := handleSpectatedGame(watcher.ctx, sync); state != MENU {
+		t.Errorf("spectator is in state %s after an impossible game, expected to stop watching", state)
+	}
+	n.current = nil
+}
+
+func TestRefusedMoveIsNotPassedOn(t *testing.T) {
+	n := newTestNetwork(t)
+	host, guest, watcher := n.addClient(), n.addClient(), n.addClient()
+
+	n.input(host, ".start illegal")
+	n.input(guest, ".join illegal")
+	n.input(watcher, ".watch illegal")
+	n.input(host, ".start")
+	n.input(host, ".move e6 e4")
+
+	// The guest claims a move no rule allows, a rook jumping over its own pawn
+	n.current = guest
+	err := guest.ctx.SendPacket(networking.NewMovePiece(chess.Position{X: 0, Y: 0}, chess.Position{X: 0, Y: 4}))
+	n.current = nil
+	if err != nil {
+		t.Fatalf("sending move: %s", err.Error())
+	}
+	n.run(100)
+
+	n.expectState(host, MENU)
+	if host.ctx.GameState.Turn() != chess.BLACK || len(host.ctx.GameState.History()) != 1 {
+		t.Errorf("host played the illegal move, %s to move with %d moves made", host.ctx.GameState.Turn(), len(host.ctx.GameState.History()))
+	}
+	if got := pieceAt(watcher.ctx, "a4"); got != "" || len(watcher.ctx.GameState.History()) != 1 {
+		t.Errorf("the illegal move was passed on to the spectator, who has %q on a4", got)
+	}
+}
+
+func TestRefusedDropLeavesTheGame(t *testing.T) {
+	n := newTestNetwork(t)
+	host, guest := n.addClient(), n.addClient()
+
+	n.input(host, ".start nodrops")
+	n.input(guest, ".join nodrops")
+	n.input(host, ".start")
+
+	// Nothing has been captured, so there is nothing in reserve to drop
+	n.current = guest
+	state := handleDropPiece(guest.ctx, networking.NewDropPiece(chess.KNIGHT, chess.Position{X: 4, Y: 4}))
+	n.current = nil
+
+	if state != MENU {
+		t.Errorf("guest is in state %s after an impossible drop, expected to leave the game", state)
+	}
+	if guest.ctx.GameState.Turn() != chess.WHITE || len(guest.ctx.GameState.History()) != 0 {
+		t.Errorf("the impossible drop was played, %s to move with %d moves made", guest.ctx.GameState.Turn(), len(guest.ctx.GameState.History()))
+	}
+}
+
+func TestInvalidStartPositionLeavesTheLobby(t *testing.T) {
+	n := newTestNetwork(t)
+	host, guest := n.addClient(), n.addClient()
+
+	n.input(host, ".start random chess960")
+	n.input(guest, ".join random")
+	n.expectState(guest, LOBBY)
+
+	n.current = guest
+	state := handleLobbyStartRequest(guest.ctx, networking.NewLobbyStartRequest(chess.CHESS960, chess.NUM_START_POSITIONS))
+	n.current = nil
+	if state != MENU {
+		t.Errorf("guest is in state %s after being asked to start from position %d, expected to leave", state, chess.NUM_START_POSITIONS)
+	}
+}
+
+func TestJoinWithoutLobbyName(t *testing.T) {
+	n := newTestNetwork(t)
+	client := n.addClient()
+
+	n.input(client, ".join")
+	n.expectState(client, MENU)
+	if strings.Contains(client.output.String(), "Attempting to join") {
+		t.Errorf("tried to join without a lobby name:\n%s", client.output.String())
+	}
+}
+
+func TestSpectatorsWatchTheGame(t *testing.T) {
+	n := newTestNetwork(t)
+	host, guest, early, late, lister := n.addClient(), n.addClient(), n.addClient(), n.addClient(), n.addClient()
+
+	n.input(host, ".start watched")
+	n.input(guest, ".join watched")
+
+	// Watching before the game starts shows nothing until it does
+	n.input(early, ".watch watched")
+	n.expectState(early, SPECTATING)
+	if early.ctx.Lobby.synced {
+		t.Fatalf("early spectator was sent a game that hasn't started")
+	}
+
+	n.input(host, ".start")
+	n.input(host, ".move e6 e4")
+
+	// Joining part way through, the whole game so far is sent
+	n.input(late, ".watch watched")
+	n.expectState(late, SPECTATING)
+
+	n.input(guest, ".move e1 e3")
+	for _, watcher := range []*testClient{early, late} {
+		if !watcher.ctx.Lobby.synced {
+			t.Fatalf("spectator %s never got the game", watcher.link.LocalAddress())
+		}
+		for square, piece := range map[string]string{"e4": "wP", "e6": "", "e3": "bP", "e1": ""} {
+			if got := pieceAt(watcher.ctx, square); got != piece {
+				t.Errorf("spectator %s has %q on %s, expected %q", watcher.link.LocalAddress(), got, square, piece)
+			}
+		}
+	}
+
+	// Spectators can't move, and the players never hear about them trying
+	n.input(late, ".move d6 d4")
+	n.expectState(late, SPECTATING)
+	if got := pieceAt(host.ctx, "d6"); got != "wP" {
+		t.Errorf("host has %q on d6 after a spectator tried to move it", got)
+	}
+
+	// Anyone looking for games can see how many are watching
+	n.input(lister, ".list")
+	if listing := lister.ctx.Lobbies["watched"]; listing.Spectators != 2 {
+		t.Errorf("lobby is listed with %d spectators, expected 2", listing.Spectators)
+	}
+
+	// The game ending sends everyone watching back to the menu
+	n.input(guest, ".forfeit")
+	n.run(100)
+	for _, watcher := range []*testClient{early, late} {
+		n.expectState(watcher, MENU)
+		if !strings.Contains(watcher.output.String(), "One of the players has forfeit.") {
+			t.Errorf("spectator %s was not told about the forfeit:\n%s", watcher.link.LocalAddress(), watcher.output.String())
+		}
+	}
+	if len(host.ctx.Spectators) != 0 {
+		t.Errorf("host still has %d spectators after the game", len(host.ctx.Spectators))
+	}
+}
@@ -2,4 +2,4 @@ module project-go
 
 go 1.21
 
-require github.com/google/uuid v1.6.0 // indirect
+require github.com/google/uuid v1.6.0
@@ -6,9 +6,15 @@ import (
 	"project-go/logging"
 	"project-go/networking"
 	"strings"
+	"time"
 )
 
 func PrintPrompt(ctx *Context) {
+	// The full screen interface always shows the available commands
+	if ctx.TUI != nil {
+		return
+	}
+
 	// Call the correct prompt function based on the current state
 	switch ctx.ClientState {
 	case MENU:
@@ -23,6 +29,9 @@ func PrintPrompt(ctx *Context) {
 	case THEIR_TURN:
 		theirTurnPrompt(ctx)
 		break
+	case SPECTATING:
+		spectatingPrompt(ctx)
+		break
 	}
 }
 
@@ -40,6 +49,8 @@ func HandleInput(ctx *Context, input string) ClientState {
 		return myTurnInput(ctx, input)
 	case THEIR_TURN:
 		return theirTurnInput(ctx, input)
+	case SPECTATING:
+		return spectatingInput(ctx, input)
 	default:
 		logging.Log("WE ARE IN AN INVALID STATE")
 		return ctx.ClientState
@@ -47,9 +58,11 @@ func HandleInput(ctx *Context, input string) ClientState {
 }
 
 func mainMenuPrompt() {
-	logging.Log(".start <name> - Starts a new game")
+	logging.Log(".start <name> [variant] - Starts a new game")
+	logging.Log("    variant is one of " + variantList())
 	logging.Log(".list - Lists existing games")
 	logging.Log(".join <name> - Joins existing games")
+	logging.Log(".watch <name> - Watches a game without playing in it")
 }
 
 func mainMenuInput(ctx *Context, input string) ClientState {
@@ -63,9 +76,20 @@ func mainMenuInput(ctx *Context, input string) ClientState {
 			return MENU
 		}
 
+		// The variant is optional, defaulting to standard chess
+		variant := chess.STANDARD
+		if len(split) > 2 {
+			var err error
+			variant, err = chess.ParseVariant(split[2])
+			if err != nil {
+				logging.Log("Unknown variant. Choose one of " + variantList())
+				return MENU
+			}
+		}
+
 		// Create the lobby
-		ctx.Lobby = CreateLobby(split[1])
-		packet := networking.NewLobbyCreated(split[1])
+		ctx.Lobby = CreateLobby(split[1], variant)
+		packet := networking.NewLobbyCreated(split[1], variant)
 
 		// Broadcast that the lobby exists
 		err := ctx.BroadcastPacket(packet)
@@ -89,16 +113,38 @@ func mainMenuInput(ctx *Context, input string) ClientState {
 	case ".join":
 		if len(split) < 2 {
 			logging.Log("Please enter a name for the lobby. eg. .join thegame")
+			return MENU
 		}
 		logging.Log("Attempting to join...")
 		packet := networking.NewLobbyJoinRequest(split[1])
 
+		// Forget any game we asked to watch, the host that answers is one we play against
+		ctx.Lobby = Lobby{}
+
 		// Broadcast that we want to join the lobby with the given name
 		err := ctx.BroadcastPacket(packet)
 		if err != nil {
 			logging.Log("Error joining.")
 		}
 
+		return MENU
+	case ".watch":
+		if len(split) < 2 {
+			logging.Log("Please enter a name for the lobby. eg. .watch thegame")
+			return MENU
+		}
+		logging.Log("Asking to watch...")
+		packet := networking.NewLobbySpectateRequest(split[1])
+
+		// Remember that the host who answers is letting us watch, not asking us to play
+		ctx.Lobby = Lobby{name: split[1], spectating: true}
+
+		// Broadcast that we want to watch the lobby with the given name
+		err := ctx.BroadcastPacket(packet)
+		if err != nil {
+			logging.Log("Error asking to watch.")
+		}
+
 		return MENU
 	default:
 		logging.Log("Invalid command.")
@@ -110,6 +156,7 @@ func lobbyPrompt(ctx *Context) {
 	if ctx.Lobby.hosting {
 		logging.Log(".start - Starts the game (requires other player)")
 	}
+	logging.Log(".say <message> - Sends a chat message")
 	logging.Log(".leave - Leaves the game")
 }
 
@@ -131,7 +178,13 @@ func lobbyInput(ctx *Context, input string) ClientState {
 
 		logging.Log("Attempting to start game...")
 		ctx.Lobby.Ready = true
-		packet := networking.NewLobbyStartRequest()
+
+		// Pick the starting position now, so that both sides generate the same board
+		ctx.Lobby.startPosition = chess.STANDARD_START_POSITION
+		if ctx.Lobby.variant == chess.CHESS960 {
+			ctx.Lobby.startPosition = chess.RandomStartPosition()
+		}
+		packet := networking.NewLobbyStartRequest(ctx.Lobby.variant, ctx.Lobby.startPosition)
 
 		// Tell our peer that we want to start
 		err := ctx.SendPacket(packet)
@@ -149,6 +202,9 @@ func lobbyInput(ctx *Context, input string) ClientState {
 		// We no longer have a lobby, fully clear our state
 		ctx.Lobby = Lobby{}
 		return MENU
+	case ".say":
+		sayInput(ctx, input)
+		return LOBBY
 	default:
 		logging.Log("Invalid command.")
 		return LOBBY
@@ -157,11 +213,18 @@ func lobbyInput(ctx *Context, input string) ClientState {
 
 func myTurnPrompt(ctx *Context) {
 	ctx.GameState.Print()
+	variantStatus(ctx)
 	logging.Log("")
 	logging.Logf("IT IS YOUR TURN, YOU ARE ")
 	ctx.GameState.PrintTurn()
 	logging.Log(".move <src> <dest> - Moves a piece, eg .move A4 B3")
+	logging.Log("To castle, move your King onto the Rook you are castling with")
+	if ctx.GameState.Variant() == chess.CRAZYHOUSE {
+		logging.Log(".drop <piece> <dest> - Drops a piece from your reserve, eg .drop N e4 or N@e4")
+	}
+	logging.Log(".say <message> - Sends a chat message")
 	logging.Log(".forfeit - Forfeits the game")
+	claimPrompt(ctx)
 }
 
 func myTurnInput(ctx *Context, input string) ClientState {
@@ -189,17 +252,20 @@ func myTurnInput(ctx *Context, input string) ClientState {
 			return MY_TURN
 		}
 
-		// Moving the piece was successful, so it is no longer our turn
-		ctx.GameState.SwitchTurn()
-
 		// Tell our peer what movement was made
-		packet := networking.NewMovePiece(srcPos, destPos)
-		err = ctx.SendPacket(packet)
-		if err != nil {
-			logging.Log("Error moving the piece.")
+		return finishMyMove(ctx, networking.NewMovePiece(srcPos, destPos))
+	case ".drop":
+		if len(split) < 3 {
+			logging.Log("Please enter a drop in the correct format")
 			return MY_TURN
 		}
-		return THEIR_TURN
+
+		return dropInput(ctx, split[1], split[2])
+	case ".say":
+		sayInput(ctx, input)
+		return MY_TURN
+	case ".claim":
+		return claimInput(ctx, MY_TURN)
 	case ".forfeit":
 		// Tell our peer that we forfeit
 		packet := networking.NewForfeit()
@@ -207,20 +273,103 @@ func myTurnInput(ctx *Context, input string) ClientState {
 		if err != nil {
 			logging.Log("Error forfeiting.")
 		}
+		ctx.forwardToSpectators(packet)
 		logging.Log("You have forfeit the match.")
 		return MENU
 	default:
+		// Drops can also be written in the short form, eg N@e4
+		if dropSplit := strings.Split(split[0], "@"); len(dropSplit) == 2 {
+			return dropInput(ctx, dropSplit[0], dropSplit[1])
+		}
+
 		logging.Log("Invalid command.")
 		return MY_TURN
 	}
 }
 
+func sayInput(ctx *Context, input string) {
+	// Everything after the command is the message, spaces included
+	message := strings.TrimSpace(strings.TrimPrefix(input, ".say"))
+	if message == "" {
+		logging.Log("Please enter a message. eg. .say hello")
+		return
+	}
+	if len(message) > networking.MAX_CHAT_LENGTH {
+		logging.Logf("Messages can be at most %d bytes long.\n", networking.MAX_CHAT_LENGTH)
+		return
+	}
+
+	if !ctx.Connection.IsActive() {
+		logging.Log("There is nobody to talk to!")
+		return
+	}
+
+	err := ctx.SendPacket(networking.NewChat(message))
+	if err != nil {
+		logging.Log("Error sending the message.")
+		return
+	}
+
+	ctx.addChat(ChatLine{Mine: true, Text: message, Time: time.Now()})
+}
+
+func dropInput(ctx *Context, pieceText string, destText string) ClientState {
+	// Parse the piece and where it is being dropped
+	kind, err := chess.ParsePieceKind(pieceText)
+	if err != nil {
+		logging.Log("Please enter a piece as one of P, N, B, R, Q")
+		return MY_TURN
+	}
+
+	destPos, err := parsePosition(destText)
+	if err != nil {
+		logging.Log("Please enter a drop in the correct format")
+		return MY_TURN
+	}
+
+	// Try to drop the piece
+	dropped, failedReason := ctx.GameState.DropPiece(kind, destPos)
+	if !dropped {
+		logging.Log(failedReason)
+		return MY_TURN
+	}
+
+	// Tell our peer which piece was dropped
+	return finishMyMove(ctx, networking.NewDropPiece(kind, destPos))
+}
+
+func finishMyMove(ctx *Context, packet networking.IChessPacket) ClientState {
+	// Our move was successful, so it is no longer our turn
+	ctx.GameState.SwitchTurn()
+
+	// Tell our peer what move was made
+	err := ctx.SendPacket(packet)
+	if err != nil {
+		logging.Log("Error moving the piece.")
+		return MY_TURN
+	}
+	ctx.forwardToSpectators(packet)
+
+	// Our move may have ended the game, if so we close the connection the same way as when theirs does
+	outcome := ctx.GameState.Outcome()
+	if outcome.Over {
+		ctx.gameOver(outcome)
+		ctx.Lobby.hosting = false
+		ctx.Connection.Close()
+		return MENU
+	}
+	return THEIR_TURN
+}
+
 func theirTurnPrompt(ctx *Context) {
 	ctx.GameState.Print()
+	variantStatus(ctx)
 	logging.Log("")
 	logging.Logf("IT IS THEIR TURN, THEY ARE ")
 	ctx.GameState.PrintTurn()
+	logging.Log(".say <message> - Sends a chat message")
 	logging.Log(".forfeit - Forfeits the game")
+	claimPrompt(ctx)
 }
 
 func theirTurnInput(ctx *Context, input string) ClientState {
@@ -235,46 +384,140 @@ func theirTurnInput(ctx *Context, input string) ClientState {
 		if err != nil {
 			logging.Log("Error forfeiting.")
 		}
+		ctx.forwardToSpectators(packet)
 		logging.Log("You have forfeit the match.")
 		return MENU
+	case ".say":
+		sayInput(ctx, input)
+		return THEIR_TURN
+	case ".claim":
+		return claimInput(ctx, THEIR_TURN)
 	default:
 		logging.Log("Invalid command.")
 		return THEIR_TURN
 	}
 }
 
-func parseMovement(src string, dest string) (chess.Position, chess.Position, error) {
-	// We are looking for input in the form letternumber
-	// E.g. a4 b3
-	// Letters in range a-h
-	// Numbers in range 1-8
+func spectatingPrompt(ctx *Context) {
+	if !ctx.Lobby.synced {
+		logging.Log("Waiting for the game to start...")
+	} else {
+		ctx.GameState.Print()
+		variantStatus(ctx)
+		logging.Log("")
+		logging.Logf("YOU ARE WATCHING, IT IS THE TURN OF ")
+		ctx.GameState.PrintTurn()
+	}
+	logging.Log(".leave - Stops watching the game")
+}
 
-	var srcRow, destRow int
-	// This is a rune to allow easy letter parsing
-	var srcColRune, destColRune rune
+func spectatingInput(ctx *Context, input string) ClientState {
+	// Split on space to parse the extra arguments if necessary
+	split := strings.Split(input, " ")
+
+	switch split[0] {
+	case ".leave":
+		if ctx.Connection.IsActive() {
+			ctx.Connection.Close()
+		}
+
+		// We no longer have a game to watch, fully clear our state
+		ctx.Lobby = Lobby{}
+		return MENU
+	default:
+		logging.Log("Spectators can't play, use .leave to stop watching.")
+		return SPECTATING
+	}
+}
+
+func claimPrompt(ctx *Context) {
+	if ctx.Connection.PeerUnresponsive() {
+		logging.Log(".claim - Claims the win, since your opponent has stopped responding")
+	}
+}
+
+func claimInput(ctx *Context, state ClientState) ClientState {
+	// Only an opponent that has gone quiet can be claimed against
+	if !ctx.Connection.PeerUnresponsive() {
+		logging.Log("Your opponent is still responding.")
+		return state
+	}
+
+	ctx.gameOver(chess.Outcome{Over: true, Winner: ctx.PlayerColour, Reason: "Opponent stopped responding"})
 
+	// They are most likely gone, so there's no point waiting on a clean close
+	ctx.Lobby.hosting = false
+	ctx.Connection.Abort()
+	return MENU
+}
+
+func variantList() string {
+	names := make([]string, 0)
+	for _, variant := range chess.SupportedVariants() {
+		names = append(names, variant.String())
+	}
+	return strings.Join(names, ", ")
+}
+
+func variantStatus(ctx *Context) {
+	// Some variants have extra state worth showing alongside the board
+	switch ctx.GameState.Variant() {
+	case chess.KING_OF_THE_HILL:
+		logging.Log("Move your King to d3, e3, d4 or e4 to win")
+	case chess.THREE_CHECK:
+		logging.Logf("Checks given: WHITE %d, BLACK %d\n", ctx.GameState.ChecksGiven(chess.WHITE), ctx.GameState.ChecksGiven(chess.BLACK))
+	case chess.CRAZYHOUSE:
+		for _, colour := range []chess.Colour{chess.WHITE, chess.BLACK} {
+			logging.Logf("%s reserve:", colour.String())
+			for kind := chess.PAWN; kind < chess.NUM_RESERVE_KINDS; kind++ {
+				logging.Logf(" %s x%d", kind.String(), ctx.GameState.Reserve(colour, kind))
+			}
+			logging.Log("")
+		}
+	}
+}
+
+func parseMovement(src string, dest string) (chess.Position, chess.Position, error) {
 	// Pull the column and row out of the src string
-	_, err := fmt.Sscanf(src, "%c%1d", &srcColRune, &srcRow)
+	srcPos, err := parsePosition(src)
 	if err != nil {
 		return chess.Position{}, chess.Position{}, err
 	}
 
 	// Pull the column and row out of the dest string
-	_, err = fmt.Sscanf(dest, "%c%1d", &destColRune, &destRow)
+	destPos, err := parsePosition(dest)
 	if err != nil {
 		return chess.Position{}, chess.Position{}, err
 	}
 
-	// Parse the columns from the letters
-	srcCol := parseLetter(srcColRune)
-	destCol := parseLetter(destColRune)
+	return srcPos, destPos, nil
+}
+
+func parsePosition(pos string) (chess.Position, error) {
+	// We are looking for input in the form letternumber
+	// E.g. a4
+	// Letters in range a-h
+	// Numbers in range 0-7
+
+	var row int
+	// This is a rune to allow easy letter parsing
+	var colRune rune
+
+	// Pull the column and row out of the string, letters are accepted in either case
+	_, err := fmt.Sscanf(strings.ToLower(pos), "%c%1d", &colRune, &row)
+	if err != nil {
+		return chess.Position{}, err
+	}
+
+	// Parse the column from the letter
+	col := parseLetter(colRune)
 
-	// Ensure both positions are in bounds
-	if !inRange(srcCol, 0, 8) || !inRange(srcRow, 0, 8) || !inRange(destCol, 0, 8) || !inRange(destRow, 0, 8) {
-		return chess.Position{}, chess.Position{}, fmt.Errorf("incorrect piece position")
+	// Ensure the position is in bounds
+	if !inRange(col, 0, 8) || !inRange(row, 0, 8) {
+		return chess.Position{}, fmt.Errorf("incorrect piece position")
 	}
 
-	return chess.Position{X: srcCol, Y: srcRow}, chess.Position{X: destCol, Y: destRow}, nil
+	return chess.Position{X: col, Y: row}, nil
 }
 
 func parseLetter(letter rune) int {
@@ -1,15 +1,58 @@
 package main
 
+import (
+	"fmt"
+	"net"
+	"project-go/chess"
+	"time"
+)
+
 type Lobby struct {
-	hosting bool
-	name    string
-	Ready   bool
+	hosting       bool
+	name          string
+	variant       chess.Variant
+	startPosition int
+	Ready         bool
+	spectating    bool // We asked to watch this lobby's game rather than play in it
+	synced        bool // The host has sent us the game being watched, so there is a board to show
+}
+
+// A lobby someone else has announced, which we could join
+type LobbyListing struct {
+	Name       string
+	Variant    chess.Variant
+	Spectators int
+	Host       net.HardwareAddr
+	Seen       time.Time
 }
 
-func CreateLobby(name string) Lobby {
-	return Lobby{hosting: true, name: name, Ready: false}
+type ChatLine struct {
+	Mine bool
+	Text string
+	Time time.Time
+}
+
+func CreateLobby(name string, variant chess.Variant) Lobby {
+	return Lobby{hosting: true, name: name, variant: variant, Ready: false}
 }
 
 func (l *Lobby) Name() string {
 	return l.name
 }
+
+func (l *Lobby) Variant() chess.Variant {
+	return l.variant
+}
+
+func (l LobbyListing) Description() string {
+	if l.Spectators > 0 {
+		return fmt.Sprintf("%s (%s, %d watching)", l.Name, l.Variant.String(), l.Spectators)
+	}
+	return l.Name + " (" + l.Variant.String() + ")"
+}
+
+func (c *Context) rememberLobby(name string, variant chess.Variant, spectators int, host net.HardwareAddr) LobbyListing {
+	listing := LobbyListing{Name: name, Variant: variant, Spectators: spectators, Host: host, Seen: time.Now()}
+	c.Lobbies[name] = listing
+	return listing
+}
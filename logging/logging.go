@@ -7,6 +7,12 @@ import (
 
 var verbose = false
 
+// When set, all output is handed here instead of being printed, eg. for the full screen interface
+var output func(text string)
+
+// Everything here also receives a copy of all output, eg. for the web interface
+var tees []func(text string)
+
 func Init() {
 	// Check the arguments for the -v verbose flag
 	for argIndex := range os.Args {
@@ -16,26 +22,48 @@ func Init() {
 	}
 }
 
+func SetOutput(out func(text string)) {
+	output = out
+}
+
+func AddTee(tee func(text string)) {
+	tees = append(tees, tee)
+}
+
 func Log(message string) {
 	// Analogous to println, always prints
+	for _, tee := range tees {
+		tee(message + "\n")
+	}
+	if output != nil {
+		output(message + "\n")
+		return
+	}
 	println(message)
 }
 
 func Logf(format string, args ...any) {
 	// Analogous to printf, always prints
+	for _, tee := range tees {
+		tee(fmt.Sprintf(format, args...))
+	}
+	if output != nil {
+		output(fmt.Sprintf(format, args...))
+		return
+	}
 	fmt.Printf(format, args...)
 }
 
 func Debug(message string) {
 	// Analogous to println, only prints in verbose mode
 	if verbose {
-		println(message)
+		Log(message)
 	}
 }
 
 func Debugf(format string, args ...any) {
 	// Analogous to printf, only prints in verbose mode
 	if verbose {
-		fmt.Printf(format, args...)
+		Logf(format, args...)
 	}
 }
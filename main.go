@@ -1,12 +1,17 @@
 package main
 
 import (
+	"os"
 	"project-go/chess"
 	"project-go/logging"
 	"project-go/networking"
+	"strings"
 	"time"
 )
 
+// How often the main thread wakes up to drive the connection and redraw
+const TICK_INTERVAL = time.Millisecond * 50
+
 type ClientState int
 
 const (
@@ -14,14 +19,41 @@ const (
 	LOBBY
 	MY_TURN
 	THEIR_TURN
+	SPECTATING
 	EXITING
 )
 
+func (s ClientState) String() string {
+	switch s {
+	case MENU:
+		return "Menu"
+	case LOBBY:
+		return "Lobby"
+	case MY_TURN:
+		return "Your turn"
+	case THEIR_TURN:
+		return "Their turn"
+	case SPECTATING:
+		return "Spectating"
+	default:
+		return "Exiting"
+	}
+}
+
 type Context struct {
-	GameState   chess.State
-	ClientState ClientState
-	Lobby       Lobby
-	Connection  *networking.Connection
+	GameState    chess.State
+	ClientState  ClientState
+	Lobby        Lobby
+	Host         *networking.Host
+	Connections  *networking.ConnectionTable
+	Connection   *networking.Connection // Our opponent's connection, idle when we don't have one
+	Spectators   []*Spectator           // Everyone watching the game we are hosting
+	PlayerColour chess.Colour
+	Clock        GameClock
+	Lobbies      map[string]LobbyListing
+	Chat         []ChatLine
+	TUI          *TUI
+	Web          *WebUI
 }
 
 func main() {
@@ -29,64 +61,190 @@ func main() {
 	context := Context{
 		GameState:   chess.CreateState(),
 		ClientState: MENU,
-		Connection:  networking.NewConnection(),
+		Lobbies:     make(map[string]LobbyListing),
 	}
 
 	// Initialize the logger
 	logging.Init()
 
+	// Choose how the board is drawn
+	chess.InitRenderer()
+
+	// Take over the whole terminal if asked, before anything else starts printing
+	if hasArgument("--tui") {
+		tui, err := StartTUI(&context)
+		if err != nil {
+			logging.Log("Unable to start the full screen interface, using commands instead. " + err.Error())
+		} else {
+			context.TUI = tui
+			defer tui.Stop()
+		}
+	}
+
+	// Everything is timed by the real clock when playing for real
+	clock := networking.NewSystemClock()
+
+	// Open the link that all of our frames travel over, raw Ethernet unless UDP was asked for
+	var link networking.Link
+	var err error
+	if port, ok := argumentValue("--udp"); ok {
+		link, err = networking.NewUDPLink(port)
+	} else {
+		link, err = networking.NewEthernetLink()
+	}
+	if err != nil {
+		logging.Log("Unable to open the network, exiting. " + err.Error())
+		return
+	}
+
+	// Make the network worse on purpose if asked, to watch the transport recover
+	if spec, ok := argumentValue("--impair"); ok {
+		impairment, err := networking.ParseImpairment(spec)
+		if err != nil {
+			logging.Log("Unable to understand the impairment, exiting. " + err.Error())
+			link.Close()
+			return
+		}
+		link = networking.NewImpairedLink(link, impairment, clock)
+	}
+	defer link.Close()
+	context.Host = networking.NewHost(link, clock)
+	context.Connections = networking.NewConnectionTable(context.Host)
+
+	// Nobody to play yet, so this stays idle until we open a connection or accept one
+	context.Connection = networking.NewConnection(context.Host)
+
+	// Selective repeat is used whenever the peer supports it, unless asked to stick to go back N
+	if hasArgument("--go-back-n") {
+		context.Connections.SetSelectiveRepeat(false)
+	}
+
+	// How long the opponent can go quiet before we offer to claim the win
+	if value, ok := argumentValue("--dead-peer-timeout"); ok {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			logging.Log("Unable to understand the dead peer timeout, using the default.")
+		} else {
+			context.Connections.SetDeadPeerTimeout(timeout)
+		}
+	}
+
 	// Set up our channels for the threads we're running
-	networking.SendChan = make(chan []byte)
-	go networking.SendThread()
 
+	// The full screen interface needs every key press, otherwise we read a line at a time
 	inputChan := make(chan string)
-	go InputThread(inputChan)
+	keyChan := make(chan string)
+	if context.TUI != nil {
+		go RawInputThread(keyChan)
+	} else {
+		go InputThread(inputChan)
+	}
+
+	// Serve the game to a browser if asked, browser commands are handled like any other input
+	if port, ok := argumentValue("--web"); ok {
+		if port == "" {
+			port = DEFAULT_WEB_PORT
+		}
+
+		web, err := StartWebUI(port, inputChan)
+		if err != nil {
+			logging.Log("Unable to start the web interface. " + err.Error())
+		} else {
+			context.Web = web
+		}
+	}
 
-	receiveChan := make(chan []byte)
-	go networking.RecvThread(receiveChan)
+	receiveChan := make(chan networking.Frame)
+	go networking.RecvThread(link, receiveChan)
 
 	tickChan := make(chan byte)
 	go tickThread(&context, tickChan)
 
 	// Output the prompt for the user
 	PrintPrompt(&context)
+	context.refresh()
 
 	for context.ClientState != EXITING {
 		select {
 		case input := <-inputChan:
 			context.handleInput(input)
+			context.refresh()
+			break
+		case keys := <-keyChan:
+			context.TUI.HandleKeys(keys)
+			context.refresh()
 			break
 		case frame := <-receiveChan:
-			connectionStatusChanged, packet, err := networking.HandleFrame(frame, context.Connection)
+			context.handleFrame(frame)
+			break
+		case _ = <-tickChan:
+			context.handleTick()
+			break
+		}
+	}
+}
 
-			// If we have a valid error that isn't just that we received a frame with the wrong ethertype, log it
-			if err != nil && err.Error() != "incorrect ethertype" {
-				logging.Debug("Error receiving packet, " + err.Error())
-				continue
-			}
+func (c *Context) handleFrame(frame networking.Frame) {
+	conn, event, packets, err := networking.HandleFrame(frame, c.Host, c.Connections)
 
-			if packet != nil {
-				logging.Debugf("received packet: %x\n", packet)
-				context.handleRequest(packet)
-			}
+	// A peer that connects while we have nobody to play becomes our opponent, unless it came to watch
+	if event == networking.PEER_CONNECTED && !c.Connection.IsActive() && c.findSpectator(conn) == nil {
+		c.Connection = conn
+	}
 
-			if connectionStatusChanged {
-				context.handleConnectionChange()
-			}
-			break
-		case _ = <-tickChan:
-			// We don't do anything if we don't have an active connection
-			if !context.Connection.IsActive() {
-				continue
-			}
+	// The game only hears from our opponent and from broadcasts, anyone else is dealt with separately
+	if conn != nil && conn != c.Connection {
+		c.handleOtherConnection(conn, event)
+		return
+	}
 
-			// Update our connection as necessary for this tick
-			context.tickConnection()
-			break
+	// Data can ride along with the ack that finishes the handshake, so whoever connected is dealt with first
+	connected := event == networking.PEER_CONNECTED
+	if connected {
+		c.handleConnectionChange(event)
+	}
+
+	// Anything before an error was still received properly, so it is handled either way
+	for _, packet := range packets {
+		logging.Debugf("received packet: %x\n", packet)
+		c.handleRequest(packet)
+	}
+
+	// The link only passes up frames for our protocol, so any error is worth logging
+	if err != nil {
+		logging.Debug("Error receiving packet, " + err.Error())
+		if len(packets) > 0 {
+			c.refresh()
 		}
+		return
+	}
+
+	if event != networking.NO_EVENT && !connected {
+		c.handleConnectionChange(event)
+	}
+
+	if len(packets) > 0 || event != networking.NO_EVENT {
+		c.refresh()
 	}
 }
 
+func (c *Context) handleTick() {
+	// Keep the clocks on screen ticking over
+	if c.TUI != nil {
+		c.TUI.Tick()
+	}
+	if c.Web != nil {
+		c.Web.Tick(c)
+	}
+
+	// Update every connection as necessary for this tick, then forget the ones that have closed
+	for _, conn := range c.Connections.Connections() {
+		c.tickConnection(conn)
+	}
+	c.Connections.Prune()
+	c.pruneSpectators()
+}
+
 func (c *Context) handleInput(input string) {
 	newState := HandleInput(c, input)
 
@@ -103,14 +261,50 @@ func (c *Context) handleRequest(request networking.IChessPacket) {
 	}
 }
 
-func (c *Context) handleConnectionChange() {
-	if c.Connection.IsActive() {
+func (c *Context) handleConnectionChange(event networking.ConnectionEvent) {
+	if event == networking.PEER_CONNECTED && c.Lobby.spectating {
+		logging.Logf("Got a new connection with %x, waiting for the game to watch\n", c.Connection.Peer())
+		c.changeState(SPECTATING)
+		return
+	} else if event == networking.PEER_CONNECTED {
 		logging.Logf("Got a new connection with %x, entering the lobby\n", c.Connection.Peer())
 		// We just received a new connection, meaning we have joined the lobby
 		c.changeState(LOBBY)
 		return
+	} else if event == networking.PEER_UNRESPONSIVE {
+		// The connection is still open, the player decides whether to keep waiting
+		if c.ClientState == MY_TURN || c.ClientState == THEIR_TURN {
+			logging.Log("Your opponent has stopped responding. Keep waiting, or use .claim to claim the win.")
+		} else {
+			logging.Log("The other side has stopped responding.")
+		}
+		return
+	} else if event == networking.PEER_RECOVERED {
+		logging.Log("The other side is responding again.")
+		return
+	} else if event == networking.PEER_LOST {
+		logging.Log("Lost the connection to the other side, trying to reconnect...")
+		return
+	} else if event == networking.PEER_RESUMED {
+		logging.Log("Reconnected to the other side.")
+		// Moves may have been lost along with the connection, so both sides share everything played so far
+		if c.ClientState == MY_TURN || c.ClientState == THEIR_TURN {
+			err := c.SendPacket(networking.NewGameSync(&c.GameState))
+			if err != nil {
+				logging.Debug("error sending game sync: " + err.Error())
+			}
+		}
+		return
+	} else if event == networking.PEER_TIMED_OUT {
+		logging.Log("Connection timed out.")
+		c.changeState(MENU)
+		return
 	} else {
-		logging.Log("Other side closed the connection.")
+		if event == networking.PEER_ABORTED {
+			logging.Log("Other side dropped the connection.")
+		} else {
+			logging.Log("Other side closed the connection.")
+		}
 		// If we were in the middle of a game, reset back to the menu
 		if c.ClientState == MY_TURN || c.ClientState == THEIR_TURN || !c.Lobby.hosting {
 			c.changeState(MENU)
@@ -122,62 +316,166 @@ func (c *Context) handleConnectionChange() {
 	PrintPrompt(c)
 }
 
-func (c *Context) tickConnection() {
-	// Send packets if needed
-	closed := c.Connection.CheckLoss()
-	if closed {
-		logging.Log("Connection timed out.")
-		c.changeState(MENU)
-		return
+func (c *Context) tickConnection(conn *networking.Connection) {
+	// Send packets if needed, or let the player know the connection dropped
+	event := conn.CheckLoss()
+	if event != networking.NO_EVENT {
+		c.tickEvent(conn, event)
+		if !conn.IsActive() {
+			return
+		}
+	}
+
+	// Let the player know if the other side has gone quiet, or come back
+	event = conn.CheckIdle()
+	if event != networking.NO_EVENT {
+		c.tickEvent(conn, event)
 	}
 
 	// Get a full list of packets to send on the wire
-	packets := c.Connection.GetPackets()
-	packets = append(packets, c.Connection.GetAckPackets()...)
+	packets := conn.GetPackets()
+	packets = append(packets, conn.GetAckPackets()...)
 
 	for i := range packets {
-		// Package it up for sending on the wire
-		data, err := networking.PackageTransport(packets[i], c.Connection)
+		// Package it up and send it on the wire
+		err := networking.SendTransport(packets[i], conn)
 		if err != nil {
-			logging.Debug("error packaging transport packet: " + err.Error())
-			continue
+			logging.Debug("error sending transport packet: " + err.Error())
 		}
+	}
+}
 
-		// There was no error, send the packet on the wire
-		networking.SendChan <- data
+func (c *Context) tickEvent(conn *networking.Connection, event networking.ConnectionEvent) {
+	if conn != c.Connection {
+		c.handleOtherConnection(conn, event)
+		return
+	}
+
+	c.handleConnectionChange(event)
+	c.refresh()
+}
+
+func (c *Context) handleOtherConnection(conn *networking.Connection, event networking.ConnectionEvent) {
+	spectator := c.findSpectator(conn)
+	if spectator != nil {
+		c.handleSpectatorEvent(spectator, event)
+		return
+	}
+
+	// We only play one game at a time, so anyone else who connects is turned away
+	if event == networking.PEER_CONNECTED {
+		logging.Debugf("turning away a connection from %x, we already have an opponent\n", conn.Peer())
+		conn.Close()
 	}
 }
 
 func (c *Context) changeState(state ClientState) {
+	oldState := c.ClientState
+	c.updateClock(oldState, state)
 	c.ClientState = state
+	c.updateSpectators(oldState, state)
 	PrintPrompt(c)
 }
 
+func (c *Context) updateClock(oldState ClientState, newState ClientState) {
+	wasPlaying := oldState == MY_TURN || oldState == THEIR_TURN
+	nowPlaying := newState == MY_TURN || newState == THEIR_TURN
+
+	if nowPlaying && !wasPlaying {
+		// A new game has just begun
+		c.Clock.Start()
+	} else if nowPlaying {
+		c.Clock.Switch(c.GameState.Turn())
+	} else if wasPlaying {
+		c.Clock.Stop()
+	}
+}
+
+func (c *Context) refresh() {
+	// Bring every interface up to date with the latest state
+	if c.TUI != nil {
+		c.TUI.Draw()
+	}
+	if c.Web != nil {
+		c.Web.Publish(c)
+	}
+}
+
+func (c *Context) addChat(line ChatLine) {
+	c.Chat = append(c.Chat, line)
+
+	// The full screen interface has its own chat panel
+	if c.TUI == nil {
+		if line.Mine {
+			logging.Log("You: " + line.Text)
+		} else {
+			logging.Log("Opponent: " + line.Text)
+		}
+	}
+}
+
+func (c *Context) gameOver(outcome chess.Outcome) {
+	// Show the final board along with how the game ended, the full screen interface always shows the board
+	if c.TUI == nil {
+		c.GameState.Print()
+		logging.Log("")
+	}
+	logging.Log("GAME OVER: " + outcome.String())
+}
+
 func (c *Context) SendPacket(packet networking.IChessPacket) error {
-	connData, err := networking.PackageChess(packet, c.Connection)
+	return c.SendPacketTo(c.Connection, packet)
+}
+
+func (c *Context) SendPacketTo(conn *networking.Connection, packet networking.IChessPacket) error {
+	connData, err := networking.PackageChess(packet, conn)
 	if err != nil {
 		return err
 	}
 
 	// Queue the packet to send as soon as the connection is ready (hopefully next tick)
-	c.Connection.QueuePacket(connData)
+	conn.QueuePacket(connData)
 	return nil
 }
 
 func (c *Context) BroadcastPacket(packet networking.IChessPacket) error {
-	data, err := networking.PackageChessBroadcast(packet)
+	frame, err := networking.PackageChessBroadcast(packet, c.Host)
 	if err != nil {
 		return err
 	}
 
-	networking.SendChan <- data
-	return nil
+	return c.Host.Link().SendFrame(frame)
+}
+
+func hasArgument(name string) bool {
+	for argIndex := range os.Args {
+		if os.Args[argIndex] == name {
+			return true
+		}
+	}
+	return false
+}
+
+func argumentValue(name string) (string, bool) {
+	// Arguments can either be given alone, or with a value after an =
+	for argIndex := range os.Args {
+		if os.Args[argIndex] == name {
+			return "", true
+		}
+		if strings.HasPrefix(os.Args[argIndex], name+"=") {
+			return strings.TrimPrefix(os.Args[argIndex], name+"="), true
+		}
+	}
+	return "", false
 }
 
 func tickThread(context *Context, tickChan chan byte) {
+	// Tick to wake up the main thread 20 times a second, on the same clock the connection is timed by
+	ticker := context.Host.Clock().NewTicker(TICK_INTERVAL)
+	defer ticker.Stop()
+
 	for context.ClientState != EXITING {
-		// Tick to wake up the main thread 20 times a second
-		time.Sleep(time.Millisecond * 50)
+		<-ticker.C()
 		tickChan <- 1
 	}
 }
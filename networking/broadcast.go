@@ -5,19 +5,13 @@ import (
 	"encoding/binary"
 	"fmt"
 	"github.com/google/uuid"
-	"time"
 )
 
-// Store our client ID
-// MAC Address is not granular enough, since two clients may run on the same network interface
-var clientId = uuid.New()
-
-// Map of the last broadcast packet received from each client
-var lastTimestamp = make(map[uuid.UUID]int64)
-
 type BroadcastHeader struct {
 	clientId  uuid.UUID
 	timestamp int64
+	length    uint16 // How much data follows, Ethernet pads short frames so the frame length can't be trusted
+	checksum  uint32 // CRC32 of the header and data, with this field as zero
 }
 
 type BroadcastPacket struct {
@@ -26,11 +20,11 @@ type BroadcastPacket struct {
 	data []byte
 }
 
-func NewBroadcastPacket(data []byte) BroadcastPacket {
+func NewBroadcastPacket(host *Host, data []byte) BroadcastPacket {
 	packet := BroadcastPacket{
 		BroadcastHeader: BroadcastHeader{
-			clientId:  clientId,
-			timestamp: time.Now().UnixMilli(),
+			clientId:  host.clientId,
+			timestamp: host.nextBroadcastTimestamp(),
 		},
 		data: data,
 	}
@@ -42,21 +36,21 @@ func (p BroadcastPacket) Data() []byte {
 	return p.data
 }
 
-func (p BroadcastPacket) handle() ([]byte, error) {
-	if clientId == p.clientId {
+func (p BroadcastPacket) handle(host *Host) ([]byte, error) {
+	if host.clientId == p.clientId {
 		// Don't want to process a broadcast that we sent
 		return nil, fmt.Errorf("this is our own broadcast")
 	}
 
 	// Check if we have received from this address before
-	lastSuccessfulTime, ok := lastTimestamp[p.clientId]
+	lastSuccessfulTime, ok := host.lastTimestamp[p.clientId]
 	if ok && lastSuccessfulTime == p.timestamp {
 		// Have received this exact timestamp before, ignore it as a duplicate
 		return nil, fmt.Errorf("duplicate broadcast received")
 	}
 
 	// This is a new packet, store the timestamp
-	lastTimestamp[p.clientId] = p.timestamp
+	host.lastTimestamp[p.clientId] = p.timestamp
 	return p.Data(), nil
 }
 
@@ -75,10 +69,26 @@ func (p BroadcastPacket) serialize() ([]byte, error) {
 		return nil, err
 	}
 
+	// The next 2 bytes are the length of the data
+	err = binary.Write(&buf, binary.BigEndian, uint16(len(p.data)))
+	if err != nil {
+		return nil, err
+	}
+
+	// The next 4 bytes are the checksum, filled in once everything else is written
+	checksumOffset := buf.Len()
+	err = binary.Write(&buf, binary.BigEndian, uint32(0))
+	if err != nil {
+		return nil, err
+	}
+
 	// Write the data as is
 	buf.Write(p.data)
 
-	return buf.Bytes(), nil
+	packet := buf.Bytes()
+	putChecksum(packet, checksumOffset)
+
+	return packet, nil
 }
 
 func broadcastDeserialize(buf []byte) (BroadcastPacket, error) {
@@ -104,8 +114,33 @@ func broadcastDeserialize(buf []byte) (BroadcastPacket, error) {
 		return BroadcastPacket{}, err
 	}
 
+	// Next, read 2 bytes for the length of the data
+	err = binary.Read(reader, binary.BigEndian, &broadcastPacket.length)
+	if err != nil {
+		return BroadcastPacket{}, err
+	}
+
+	// Last, read 4 bytes for the checksum
+	checksumOffset := headerSize - reader.Len()
+	err = binary.Read(reader, binary.BigEndian, &broadcastPacket.checksum)
+	if err != nil {
+		return BroadcastPacket{}, err
+	}
+
+	// Anything past the length is padding
+	end := headerSize + int(broadcastPacket.length)
+	if len(buf) < end {
+		return BroadcastPacket{}, fmt.Errorf("error reading broadcast packet data: not long enough")
+	}
+
+	// Make sure nothing was damaged on the way
+	err = verifyChecksum(buf[:end], checksumOffset)
+	if err != nil {
+		return BroadcastPacket{}, err
+	}
+
 	// Read the rest of the data as is
-	broadcastPacket.data = buf[headerSize:]
+	broadcastPacket.data = buf[headerSize:end]
 
 	return broadcastPacket, nil
 }
@@ -0,0 +1,30 @@
+package networking
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// Returned when a packet's checksum doesn't match its contents, so the frame can be counted as corrupted
+var errBadChecksum = fmt.Errorf("checksum does not match, packet is corrupted")
+
+// Fills in the 4 byte checksum at the given offset, which must have been written as zero
+// The checksum covers every byte of the packet, headers and data, as it goes on the wire
+func putChecksum(packet []byte, offset int) {
+	binary.BigEndian.PutUint32(packet[offset:], crc32.ChecksumIEEE(packet))
+}
+
+func verifyChecksum(packet []byte, offset int) error {
+	expected := binary.BigEndian.Uint32(packet[offset:])
+
+	// The checksum was worked out while its own field was still zero
+	sum := crc32.Update(0, crc32.IEEETable, packet[:offset])
+	sum = crc32.Update(sum, crc32.IEEETable, make([]byte, 4))
+	sum = crc32.Update(sum, crc32.IEEETable, packet[offset+4:])
+
+	if sum != expected {
+		return errBadChecksum
+	}
+	return nil
+}
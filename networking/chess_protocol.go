@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"github.com/google/uuid"
 	"io"
 	"net"
 	"project-go/chess"
@@ -21,16 +22,22 @@ const (
 	LOBBY_START_ACCEPT
 	MOVE_PIECE
 	FORFEIT
+	DROP_PIECE
+	CHAT_MESSAGE
+	GAME_SYNC
+	LOBBY_SPECTATE_REQUEST
 )
 
 type IChessPacket interface {
 	Source() net.HardwareAddr
+	Client() uuid.UUID
 	Type() PacketType
 	Serialize() ([]byte, error)
 }
 
 type ChessPacket struct {
 	SourceAddress net.HardwareAddr
+	SourceClient  uuid.UUID // Several clients can share an address, this tells them apart
 	packetType    PacketType
 }
 
@@ -38,7 +45,8 @@ func GetBroadcastAddress() net.HardwareAddr {
 	return []byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
 }
 
-func ChessParse(data []byte, source net.HardwareAddr) (IChessPacket, error) {
+// The client ID is the sender's, from the broadcast or connection that carried the packet
+func ChessParse(data []byte, source net.HardwareAddr, client uuid.UUID) (IChessPacket, error) {
 	reader := bytes.NewReader(data)
 
 	// The first int32 in the packet is the packet type
@@ -54,21 +62,29 @@ func ChessParse(data []byte, source net.HardwareAddr) (IChessPacket, error) {
 	pType := PacketType(i32Type)
 	switch pType {
 	case LOBBY_CREATED:
-		return DeserializeLobbyCreatedPacket(reader, source)
+		return DeserializeLobbyCreatedPacket(reader, source, client)
 	case LOBBY_LIST_REQUEST:
-		return DeserializeLobbyListRequest(reader, source)
+		return DeserializeLobbyListRequest(reader, source, client)
 	case LOBBY_INFO:
-		return DeserializeLobbyInfoPacket(reader, source)
+		return DeserializeLobbyInfoPacket(reader, source, client)
 	case LOBBY_JOIN_REQUEST:
-		return DeserializeLobbyJoinRequest(reader, source)
+		return DeserializeLobbyJoinRequest(reader, source, client)
 	case LOBBY_START_REQUEST:
-		return DeserializeLobbyStartRequest(reader, source)
+		return DeserializeLobbyStartRequest(reader, source, client)
 	case LOBBY_START_ACCEPT:
-		return DeserializeLobbyStartAccepted(reader, source)
+		return DeserializeLobbyStartAccepted(reader, source, client)
 	case MOVE_PIECE:
-		return DeserializeMovePiecePacket(reader, source)
+		return DeserializeMovePiecePacket(reader, source, client)
 	case FORFEIT:
-		return DeserializeForfeitPacket(reader, source)
+		return DeserializeForfeitPacket(reader, source, client)
+	case DROP_PIECE:
+		return DeserializeDropPiecePacket(reader, source, client)
+	case CHAT_MESSAGE:
+		return DeserializeChatPacket(reader, source, client)
+	case GAME_SYNC:
+		return DeserializeGameSyncPacket(reader, source, client)
+	case LOBBY_SPECTATE_REQUEST:
+		return DeserializeLobbySpectateRequest(reader, source, client)
 	default:
 		return nil, fmt.Errorf("invalid packet type %d", pType)
 	}
@@ -78,22 +94,28 @@ func (c ChessPacket) Source() net.HardwareAddr {
 	return c.SourceAddress
 }
 
+func (c ChessPacket) Client() uuid.UUID {
+	return c.SourceClient
+}
+
 func (c ChessPacket) Type() PacketType {
 	return c.packetType
 }
 
 type LobbyCreatedPacket struct {
 	ChessPacket
-	Name string
+	Name    string
+	Variant chess.Variant
 }
 
-func NewLobbyCreated(name string) LobbyCreatedPacket {
+func NewLobbyCreated(name string, variant chess.Variant) LobbyCreatedPacket {
 	return LobbyCreatedPacket{
 		ChessPacket: ChessPacket{
 			SourceAddress: nil,
 			packetType:    LOBBY_CREATED,
 		},
-		Name: name,
+		Name:    name,
+		Variant: variant,
 	}
 }
 
@@ -120,13 +142,20 @@ func (p LobbyCreatedPacket) Serialize() ([]byte, error) {
 		}
 	}
 
+	// Write the variant, 4 bytes
+	err = binary.Write(&buf, binary.BigEndian, int32(p.Variant))
+	if err != nil {
+		return nil, err
+	}
+
 	return buf.Bytes(), nil
 }
 
-func DeserializeLobbyCreatedPacket(reader io.Reader, source net.HardwareAddr) (LobbyCreatedPacket, error) {
+func DeserializeLobbyCreatedPacket(reader io.Reader, source net.HardwareAddr, client uuid.UUID) (LobbyCreatedPacket, error) {
 	packet := LobbyCreatedPacket{}
 	packet.packetType = LOBBY_CREATED
 	packet.SourceAddress = source
+	packet.SourceClient = client
 
 	// The first 4 bytes remaining are the length of the following string
 	var nameLength int32
@@ -147,6 +176,14 @@ func DeserializeLobbyCreatedPacket(reader io.Reader, source net.HardwareAddr) (L
 	// Now that we've read the bytes, parse it as a string
 	packet.Name = string(nameBuf)
 
+	// The next 4 bytes are the variant being played
+	var variant int32
+	err = binary.Read(reader, binary.BigEndian, &variant)
+	if err != nil {
+		return LobbyCreatedPacket{}, err
+	}
+	packet.Variant = chess.Variant(variant)
+
 	return packet, nil
 }
 
@@ -173,10 +210,11 @@ func (p LobbyListRequest) Serialize() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-func DeserializeLobbyListRequest(reader io.Reader, source net.HardwareAddr) (LobbyListRequest, error) {
+func DeserializeLobbyListRequest(reader io.Reader, source net.HardwareAddr, client uuid.UUID) (LobbyListRequest, error) {
 	packet := LobbyListRequest{}
 	packet.packetType = LOBBY_LIST_REQUEST
 	packet.SourceAddress = source
+	packet.SourceClient = client
 
 	// There is no body in this packet, it is purely a signal
 
@@ -185,16 +223,20 @@ func DeserializeLobbyListRequest(reader io.Reader, source net.HardwareAddr) (Lob
 
 type LobbyInfoPacket struct {
 	ChessPacket
-	Name string
+	Name       string
+	Variant    chess.Variant
+	Spectators int
 }
 
-func NewLobbyInfo(name string) LobbyInfoPacket {
+func NewLobbyInfo(name string, variant chess.Variant, spectators int) LobbyInfoPacket {
 	return LobbyInfoPacket{
 		ChessPacket: ChessPacket{
 			SourceAddress: nil,
 			packetType:    LOBBY_INFO,
 		},
-		Name: name,
+		Name:       name,
+		Variant:    variant,
+		Spectators: spectators,
 	}
 }
 
@@ -221,13 +263,26 @@ func (p LobbyInfoPacket) Serialize() ([]byte, error) {
 		}
 	}
 
+	// Write the variant, 4 bytes
+	err = binary.Write(&buf, binary.BigEndian, int32(p.Variant))
+	if err != nil {
+		return nil, err
+	}
+
+	// Write the number of spectators, 4 bytes
+	err = binary.Write(&buf, binary.BigEndian, int32(p.Spectators))
+	if err != nil {
+		return nil, err
+	}
+
 	return buf.Bytes(), nil
 }
 
-func DeserializeLobbyInfoPacket(reader io.Reader, source net.HardwareAddr) (LobbyInfoPacket, error) {
+func DeserializeLobbyInfoPacket(reader io.Reader, source net.HardwareAddr, client uuid.UUID) (LobbyInfoPacket, error) {
 	packet := LobbyInfoPacket{}
 	packet.packetType = LOBBY_INFO
 	packet.SourceAddress = source
+	packet.SourceClient = client
 
 	// The first 4 bytes are the length of the following string
 	var nameLength int32
@@ -248,6 +303,25 @@ func DeserializeLobbyInfoPacket(reader io.Reader, source net.HardwareAddr) (Lobb
 	// Now we can parse the bytes as a string
 	packet.Name = string(nameBuf)
 
+	// The next 4 bytes are the variant being played
+	var variant int32
+	err = binary.Read(reader, binary.BigEndian, &variant)
+	if err != nil {
+		return LobbyInfoPacket{}, err
+	}
+	packet.Variant = chess.Variant(variant)
+
+	// The next 4 bytes are how many spectators are watching, hosts from before spectating leave it out
+	var spectators int32
+	err = binary.Read(reader, binary.BigEndian, &spectators)
+	if err == io.EOF {
+		return packet, nil
+	}
+	if err != nil {
+		return LobbyInfoPacket{}, err
+	}
+	packet.Spectators = int(spectators)
+
 	return packet, nil
 }
 
@@ -292,10 +366,11 @@ func (p LobbyJoinRequest) Serialize() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-func DeserializeLobbyJoinRequest(reader io.Reader, source net.HardwareAddr) (LobbyJoinRequest, error) {
+func DeserializeLobbyJoinRequest(reader io.Reader, source net.HardwareAddr, client uuid.UUID) (LobbyJoinRequest, error) {
 	packet := LobbyJoinRequest{}
 	packet.packetType = LOBBY_JOIN_REQUEST
 	packet.SourceAddress = source
+	packet.SourceClient = client
 
 	// The first 4 bytes are the length of the following string
 	var nameLength int32
@@ -321,13 +396,19 @@ func DeserializeLobbyJoinRequest(reader io.Reader, source net.HardwareAddr) (Lob
 
 type LobbyStartRequest struct {
 	ChessPacket
+	Variant       chess.Variant
+	StartPosition int
 }
 
-func NewLobbyStartRequest() LobbyStartRequest {
-	return LobbyStartRequest{ChessPacket{
-		SourceAddress: nil,
-		packetType:    LOBBY_START_REQUEST,
-	}}
+func NewLobbyStartRequest(variant chess.Variant, startPosition int) LobbyStartRequest {
+	return LobbyStartRequest{
+		ChessPacket: ChessPacket{
+			SourceAddress: nil,
+			packetType:    LOBBY_START_REQUEST,
+		},
+		Variant:       variant,
+		StartPosition: startPosition,
+	}
 }
 
 func (p LobbyStartRequest) Serialize() ([]byte, error) {
@@ -339,15 +420,42 @@ func (p LobbyStartRequest) Serialize() ([]byte, error) {
 		return nil, err
 	}
 
+	// Write the variant, 4 bytes
+	err = binary.Write(&buf, binary.BigEndian, int32(p.Variant))
+	if err != nil {
+		return nil, err
+	}
+
+	// Write the starting position, 4 bytes
+	err = binary.Write(&buf, binary.BigEndian, int32(p.StartPosition))
+	if err != nil {
+		return nil, err
+	}
+
 	return buf.Bytes(), nil
 }
 
-func DeserializeLobbyStartRequest(reader io.Reader, source net.HardwareAddr) (LobbyStartRequest, error) {
+func DeserializeLobbyStartRequest(reader io.Reader, source net.HardwareAddr, client uuid.UUID) (LobbyStartRequest, error) {
 	packet := LobbyStartRequest{}
 	packet.packetType = LOBBY_START_REQUEST
 	packet.SourceAddress = source
+	packet.SourceClient = client
 
-	// There is no body in this packet, it is purely a signal
+	// The first 4 bytes are the variant, so both sides play the same game
+	var variant int32
+	err := binary.Read(reader, binary.BigEndian, &variant)
+	if err != nil {
+		return LobbyStartRequest{}, err
+	}
+	packet.Variant = chess.Variant(variant)
+
+	// The next 4 bytes are the starting position, so both sides generate the same board
+	var startPosition int32
+	err = binary.Read(reader, binary.BigEndian, &startPosition)
+	if err != nil {
+		return LobbyStartRequest{}, err
+	}
+	packet.StartPosition = int(startPosition)
 
 	return packet, nil
 }
@@ -375,10 +483,11 @@ func (p LobbyStartAccepted) Serialize() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-func DeserializeLobbyStartAccepted(reader io.Reader, source net.HardwareAddr) (LobbyStartAccepted, error) {
+func DeserializeLobbyStartAccepted(reader io.Reader, source net.HardwareAddr, client uuid.UUID) (LobbyStartAccepted, error) {
 	packet := LobbyStartAccepted{}
 	packet.packetType = LOBBY_START_ACCEPT
 	packet.SourceAddress = source
+	packet.SourceClient = client
 
 	// There is no body in this packet, it is purely a signal
 
@@ -428,10 +537,11 @@ func (p MovePiecePacket) Serialize() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-func DeserializeMovePiecePacket(reader io.Reader, source net.HardwareAddr) (MovePiecePacket, error) {
+func DeserializeMovePiecePacket(reader io.Reader, source net.HardwareAddr, client uuid.UUID) (MovePiecePacket, error) {
 	packet := MovePiecePacket{}
 	packet.packetType = MOVE_PIECE
 	packet.SourceAddress = source
+	packet.SourceClient = client
 
 	// Declare variables to read into
 	var srcX int32
@@ -493,12 +603,355 @@ func (p ForfeitPacket) Serialize() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-func DeserializeForfeitPacket(reader io.Reader, source net.HardwareAddr) (ForfeitPacket, error) {
+func DeserializeForfeitPacket(reader io.Reader, source net.HardwareAddr, client uuid.UUID) (ForfeitPacket, error) {
 	packet := ForfeitPacket{}
 	packet.packetType = FORFEIT
 	packet.SourceAddress = source
+	packet.SourceClient = client
 
 	// There is no body in this packet, it is purely a signal
 
 	return packet, nil
 }
+
+type DropPiecePacket struct {
+	ChessPacket
+	Piece   chess.PieceKind
+	DestPos chess.Position
+}
+
+func NewDropPiece(piece chess.PieceKind, destPos chess.Position) DropPiecePacket {
+	return DropPiecePacket{
+		ChessPacket: ChessPacket{
+			SourceAddress: nil,
+			packetType:    DROP_PIECE,
+		},
+		Piece:   piece,
+		DestPos: destPos,
+	}
+}
+
+func (p DropPiecePacket) Serialize() ([]byte, error) {
+	buf := bytes.Buffer{}
+
+	// Write the type, 4 bytes
+	err := binary.Write(&buf, binary.BigEndian, int32(p.Type()))
+	if err != nil {
+		return nil, err
+	}
+
+	// Write the kind of piece being dropped, 4 bytes
+	err = binary.Write(&buf, binary.BigEndian, int32(p.Piece))
+	if err != nil {
+		return nil, err
+	}
+
+	// Write the destination position, 2 values at 4 bytes each
+	err = binary.Write(&buf, binary.BigEndian, int32(p.DestPos.X))
+	if err != nil {
+		return nil, err
+	}
+	err = binary.Write(&buf, binary.BigEndian, int32(p.DestPos.Y))
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func DeserializeDropPiecePacket(reader io.Reader, source net.HardwareAddr, client uuid.UUID) (DropPiecePacket, error) {
+	packet := DropPiecePacket{}
+	packet.packetType = DROP_PIECE
+	packet.SourceAddress = source
+	packet.SourceClient = client
+
+	// Declare variables to read into
+	var piece int32
+	var destX int32
+	var destY int32
+
+	// Read the kind of piece, 4 bytes
+	err := binary.Read(reader, binary.BigEndian, &piece)
+	if err != nil {
+		return DropPiecePacket{}, err
+	}
+	packet.Piece = chess.PieceKind(piece)
+
+	// Read the two destination values, 4 bytes each
+	err = binary.Read(reader, binary.BigEndian, &destX)
+	if err != nil {
+		return DropPiecePacket{}, err
+	}
+
+	err = binary.Read(reader, binary.BigEndian, &destY)
+	if err != nil {
+		return DropPiecePacket{}, err
+	}
+
+	// Make a position object and store in the packet object
+	packet.DestPos = chess.Position{X: int(destX), Y: int(destY)}
+
+	return packet, nil
+}
+
+// The longest chat message in bytes, anything claiming to be longer is not one of ours
+const MAX_CHAT_LENGTH = 1024
+
+type ChatPacket struct {
+	ChessPacket
+	Message string
+}
+
+func NewChat(message string) ChatPacket {
+	return ChatPacket{
+		ChessPacket: ChessPacket{
+			SourceAddress: nil,
+			packetType:    CHAT_MESSAGE,
+		},
+		Message: message,
+	}
+}
+
+func (p ChatPacket) Serialize() ([]byte, error) {
+	buf := bytes.Buffer{}
+
+	// Write the type, 4 bytes
+	err := binary.Write(&buf, binary.BigEndian, int32(p.Type()))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(p.Message) > MAX_CHAT_LENGTH {
+		return nil, fmt.Errorf("chat message of %d bytes is longer than %d", len(p.Message), MAX_CHAT_LENGTH)
+	}
+
+	// Write the message length, 4 bytes
+	err = binary.Write(&buf, binary.BigEndian, int32(len(p.Message)))
+	if err != nil {
+		return nil, err
+	}
+
+	// Write the message, variable length
+	buf.WriteString(p.Message)
+
+	return buf.Bytes(), nil
+}
+
+func DeserializeChatPacket(reader io.Reader, source net.HardwareAddr, client uuid.UUID) (ChatPacket, error) {
+	packet := ChatPacket{}
+	packet.packetType = CHAT_MESSAGE
+	packet.SourceAddress = source
+	packet.SourceClient = client
+
+	// The first 4 bytes are the length of the following string
+	var messageLength int32
+	err := binary.Read(reader, binary.BigEndian, &messageLength)
+	if err != nil {
+		return ChatPacket{}, err
+	}
+
+	if messageLength < 0 || messageLength > MAX_CHAT_LENGTH {
+		return ChatPacket{}, fmt.Errorf("invalid chat message length %d", messageLength)
+	}
+
+	// Read the message bytes, then parse them as a string
+	messageBuf := make([]byte, messageLength)
+	read, err := io.ReadFull(reader, messageBuf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return ChatPacket{}, fmt.Errorf("chat message length %d is longer than the %d bytes sent", messageLength, read)
+	}
+	if err != nil {
+		return ChatPacket{}, err
+	}
+	packet.Message = string(messageBuf)
+
+	return packet, nil
+}
+
+// Everything played so far, sent after a connection is resumed so both sides can catch up on moves lost with it
+type GameSyncPacket struct {
+	ChessPacket
+	Variant       chess.Variant
+	StartPosition int
+	Moves         []chess.PlayedMove
+}
+
+func NewGameSync(state *chess.State) GameSyncPacket {
+	return GameSyncPacket{
+		ChessPacket: ChessPacket{
+			SourceAddress: nil,
+			packetType:    GAME_SYNC,
+		},
+		Variant:       state.Variant(),
+		StartPosition: state.StartPosition(),
+		Moves:         state.History(),
+	}
+}
+
+func (p GameSyncPacket) Serialize() ([]byte, error) {
+	buf := bytes.Buffer{}
+
+	// Write the type, 4 bytes
+	err := binary.Write(&buf, binary.BigEndian, int32(p.Type()))
+	if err != nil {
+		return nil, err
+	}
+
+	// Write the variant, 4 bytes
+	err = binary.Write(&buf, binary.BigEndian, int32(p.Variant))
+	if err != nil {
+		return nil, err
+	}
+
+	// Write the starting position, 4 bytes
+	err = binary.Write(&buf, binary.BigEndian, int32(p.StartPosition))
+	if err != nil {
+		return nil, err
+	}
+
+	// Write the number of moves, 4 bytes
+	err = binary.Write(&buf, binary.BigEndian, int32(len(p.Moves)))
+	if err != nil {
+		return nil, err
+	}
+
+	// Write each move, 6 values at 4 bytes each
+	for _, move := range p.Moves {
+		drop := int32(0)
+		if move.Drop {
+			drop = 1
+		}
+
+		err = binary.Write(&buf, binary.BigEndian, []int32{
+			drop,
+			int32(move.Piece),
+			int32(move.Source.X),
+			int32(move.Source.Y),
+			int32(move.Dest.X),
+			int32(move.Dest.Y),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+func DeserializeGameSyncPacket(reader io.Reader, source net.HardwareAddr, client uuid.UUID) (GameSyncPacket, error) {
+	packet := GameSyncPacket{}
+	packet.packetType = GAME_SYNC
+	packet.SourceAddress = source
+	packet.SourceClient = client
+
+	// The first 4 bytes are the variant
+	var variant int32
+	err := binary.Read(reader, binary.BigEndian, &variant)
+	if err != nil {
+		return GameSyncPacket{}, err
+	}
+	packet.Variant = chess.Variant(variant)
+
+	// The next 4 bytes are the starting position
+	var startPosition int32
+	err = binary.Read(reader, binary.BigEndian, &startPosition)
+	if err != nil {
+		return GameSyncPacket{}, err
+	}
+	packet.StartPosition = int(startPosition)
+
+	// The next 4 bytes are how many moves follow
+	var count int32
+	err = binary.Read(reader, binary.BigEndian, &count)
+	if err != nil {
+		return GameSyncPacket{}, err
+	}
+
+	if count < 0 {
+		return GameSyncPacket{}, fmt.Errorf("invalid move count %d", count)
+	}
+
+	// Each move is 6 values at 4 bytes each
+	packet.Moves = make([]chess.PlayedMove, 0)
+	for i := int32(0); i < count; i++ {
+		values := make([]int32, 6)
+		err = binary.Read(reader, binary.BigEndian, values)
+		if err != nil {
+			return GameSyncPacket{}, err
+		}
+
+		packet.Moves = append(packet.Moves, chess.PlayedMove{
+			Drop:   values[0] != 0,
+			Piece:  chess.PieceKind(values[1]),
+			Source: chess.Position{X: int(values[2]), Y: int(values[3])},
+			Dest:   chess.Position{X: int(values[4]), Y: int(values[5])},
+		})
+	}
+
+	return packet, nil
+}
+
+// Asks the host of a lobby to let us watch its game, without playing in it
+type LobbySpectateRequest struct {
+	ChessPacket
+	Name string
+}
+
+func NewLobbySpectateRequest(name string) LobbySpectateRequest {
+	return LobbySpectateRequest{
+		ChessPacket: ChessPacket{
+			SourceAddress: nil,
+			packetType:    LOBBY_SPECTATE_REQUEST,
+		},
+		Name: name,
+	}
+}
+
+func (p LobbySpectateRequest) Serialize() ([]byte, error) {
+	buf := bytes.Buffer{}
+
+	// Write the type, 4 bytes
+	err := binary.Write(&buf, binary.BigEndian, int32(p.Type()))
+	if err != nil {
+		return nil, err
+	}
+
+	// Write the name length, 4 bytes
+	err = binary.Write(&buf, binary.BigEndian, int32(len(p.Name)))
+	if err != nil {
+		return nil, err
+	}
+
+	// Write the name, variable length
+	buf.WriteString(p.Name)
+
+	return buf.Bytes(), nil
+}
+
+func DeserializeLobbySpectateRequest(reader io.Reader, source net.HardwareAddr, client uuid.UUID) (LobbySpectateRequest, error) {
+	packet := LobbySpectateRequest{}
+	packet.packetType = LOBBY_SPECTATE_REQUEST
+	packet.SourceAddress = source
+	packet.SourceClient = client
+
+	// The first 4 bytes are the length of the following string
+	var nameLength int32
+	err := binary.Read(reader, binary.BigEndian, &nameLength)
+	if err != nil {
+		return LobbySpectateRequest{}, err
+	}
+
+	if nameLength < 0 {
+		return LobbySpectateRequest{}, fmt.Errorf("invalid lobby name length %d", nameLength)
+	}
+
+	// Read the name bytes, then parse them as a string
+	nameBuf := make([]byte, nameLength)
+	_, err = io.ReadFull(reader, nameBuf)
+	if err != nil {
+		return LobbySpectateRequest{}, err
+	}
+	packet.Name = string(nameBuf)
+
+	return packet, nil
+}
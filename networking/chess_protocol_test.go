@@ -0,0 +1,58 @@
+package networking
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/google/uuid"
+	"strings"
+	"testing"
+)
+
+// A chat packet claiming the given length, followed by the given message bytes
+func rawChat(length int32, message string) []byte {
+	buf := bytes.Buffer{}
+	binary.Write(&buf, binary.BigEndian, int32(CHAT_MESSAGE))
+	binary.Write(&buf, binary.BigEndian, length)
+	buf.WriteString(message)
+	return buf.Bytes()
+}
+
+func TestChatPacketRoundTrip(t *testing.T) {
+	for _, message := range []string{"", "hello", strings.Repeat("x", MAX_CHAT_LENGTH)} {
+		data, err := NewChat(message).Serialize()
+		if err != nil {
+			t.Fatalf("serializing %d byte message: %s", len(message), err.Error())
+		}
+
+		packet, err := ChessParse(data, nil, uuid.Nil)
+		if err != nil {
+			t.Fatalf("parsing %d byte message: %s", len(message), err.Error())
+		}
+		if chat, ok := packet.(ChatPacket); !ok || chat.Message != message {
+			t.Errorf("parsed %+v, expected a chat of %d bytes", packet, len(message))
+		}
+	}
+
+	if _, err := NewChat(strings.Repeat("x", MAX_CHAT_LENGTH+1)).Serialize(); err == nil {
+		t.Errorf("serialized a message longer than %d bytes", MAX_CHAT_LENGTH)
+	}
+}
+
+func TestChatPacketLengthIsChecked(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"negative", rawChat(-1, "hello")},
+		{"too long", rawChat(MAX_CHAT_LENGTH+1, strings.Repeat("x", MAX_CHAT_LENGTH+1))},
+		{"huge", rawChat(0x7FFFFFFF, "hello")},
+		{"longer than sent", rawChat(10, "hello")},
+		{"nothing sent", rawChat(5, "")},
+	}
+
+	for _, test := range tests {
+		if packet, err := ChessParse(test.data, nil, uuid.Nil); err == nil {
+			t.Errorf("%s: parsed %+v", test.name, packet)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package networking
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Where all of the transport's timing comes from, so timers can be driven by hand instead of waiting on wall time
+type Clock interface {
+	Now() time.Time
+	AfterFunc(delay time.Duration, f func()) Timer
+	NewTicker(interval time.Duration) Ticker
+}
+
+// A pending call from AfterFunc
+type Timer interface {
+	Stop() bool
+}
+
+// Delivers the time on C once every interval, dropping ticks if nobody is reading
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// The real clock, which every host uses unless told otherwise
+type SystemClock struct{}
+
+type systemTicker struct {
+	ticker *time.Ticker
+}
+
+func NewSystemClock() SystemClock {
+	return SystemClock{}
+}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (SystemClock) AfterFunc(delay time.Duration, f func()) Timer {
+	return time.AfterFunc(delay, f)
+}
+
+func (SystemClock) NewTicker(interval time.Duration) Ticker {
+	return systemTicker{ticker: time.NewTicker(interval)}
+}
+
+func (t systemTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+func (t systemTicker) Stop() {
+	t.ticker.Stop()
+}
+
+// A clock that only moves when Advance is called
+// Timers and tickers fire during Advance in the order they are due, so the same steps always give the same result
+type FakeClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+type fakeWaiter struct {
+	clock    *FakeClock
+	when     time.Time
+	interval time.Duration  // Zero for a one off timer, otherwise how often a ticker fires
+	f        func()         // Called for timers
+	c        chan time.Time // Sent to for tickers
+	stopped  bool
+}
+
+type fakeTicker struct {
+	waiter *fakeWaiter
+}
+
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+func (f *FakeClock) Now() time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return f.now
+}
+
+func (f *FakeClock) AfterFunc(delay time.Duration, fn func()) Timer {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	waiter := &fakeWaiter{clock: f, when: f.now.Add(delay), f: fn}
+	f.waiters = append(f.waiters, waiter)
+	return waiter
+}
+
+func (f *FakeClock) NewTicker(interval time.Duration) Ticker {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	// Like the real ticker, one tick is buffered and any more are dropped
+	waiter := &fakeWaiter{clock: f, when: f.now.Add(interval), interval: interval, c: make(chan time.Time, 1)}
+	f.waiters = append(f.waiters, waiter)
+	return fakeTicker{waiter: waiter}
+}
+
+// Moves the clock forward, firing everything that comes due along the way at the time it was due
+func (f *FakeClock) Advance(duration time.Duration) {
+	f.mutex.Lock()
+	target := f.now.Add(duration)
+
+	for {
+		waiter := f.nextDue(target)
+		if waiter == nil {
+			break
+		}
+
+		// Step to exactly when this waiter was due, so anything it checks sees the right time
+		f.now = waiter.when
+		if waiter.interval > 0 {
+			waiter.when = waiter.when.Add(waiter.interval)
+		} else {
+			waiter.stopped = true
+			f.remove(waiter)
+		}
+
+		// Callbacks may use the clock themselves, so never hold the lock while running them
+		f.mutex.Unlock()
+		waiter.fire()
+		f.mutex.Lock()
+	}
+
+	f.now = target
+	f.mutex.Unlock()
+}
+
+func (f *FakeClock) nextDue(target time.Time) *fakeWaiter {
+	// Fire in order of when they were due, ties going to whoever was set up first
+	sort.SliceStable(f.waiters, func(i, j int) bool {
+		return f.waiters[i].when.Before(f.waiters[j].when)
+	})
+
+	if len(f.waiters) == 0 || f.waiters[0].when.After(target) {
+		return nil
+	}
+	return f.waiters[0]
+}
+
+func (f *FakeClock) remove(waiter *fakeWaiter) {
+	for i := range f.waiters {
+		if f.waiters[i] == waiter {
+			f.waiters = append(f.waiters[:i], f.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+func (w *fakeWaiter) fire() {
+	if w.f != nil {
+		w.f()
+		return
+	}
+
+	select {
+	case w.c <- w.when:
+	default:
+	}
+}
+
+func (w *fakeWaiter) Stop() bool {
+	w.clock.mutex.Lock()
+	defer w.clock.mutex.Unlock()
+
+	// Reports whether this stopped the waiter, rather than it having already fired or been stopped
+	if w.stopped {
+		return false
+	}
+	w.stopped = true
+	w.clock.remove(w)
+	return true
+}
+
+func (t fakeTicker) C() <-chan time.Time {
+	return t.waiter.c
+}
+
+func (t fakeTicker) Stop() {
+	t.waiter.Stop()
+}
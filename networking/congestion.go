@@ -0,0 +1,61 @@
+package networking
+
+import (
+	"math"
+)
+
+// How many packets we send before hearing anything back, the same as the window we assume the peer has
+const INITIAL_CONGESTION_WINDOW = 4
+
+// Never let the slow start threshold drop below this, or recovering from a loss takes forever
+const MIN_SLOW_START_THRESHOLD = 2
+
+// Grows and shrinks how many packets we allow in flight based on how the network is coping (AIMD)
+// Doubles every round trip until the threshold, then grows by one packet per round trip, and falls back to one packet on a loss
+type CongestionControl struct {
+	window    float64
+	threshold float64
+}
+
+func NewCongestionControl() CongestionControl {
+	return CongestionControl{
+		window:    INITIAL_CONGESTION_WINDOW,
+		threshold: MAX_WINDOW_SIZE,
+	}
+}
+
+// Grows the window for every packet newly acknowledged
+func (c *CongestionControl) Acked(count int) {
+	for i := 0; i < count; i++ {
+		if c.window < c.threshold {
+			// Slow start, one more packet for each ack doubles the window every round trip
+			c.window++
+		} else {
+			// Congestion avoidance, a whole window of acks adds one packet
+			c.window += 1 / c.window
+		}
+	}
+
+	c.window = math.Min(c.window, MAX_WINDOW_SIZE)
+}
+
+// Backs right off after a timeout, since something between us and the peer is full
+func (c *CongestionControl) Lost(inFlight int) {
+	c.threshold = math.Max(float64(inFlight)/2, MIN_SLOW_START_THRESHOLD)
+	c.window = 1
+}
+
+func (c *CongestionControl) Window() int {
+	return int(c.window)
+}
+
+func (c *Connection) packetsInFlight() int {
+	// Sent, but not yet acknowledged
+	inFlight := 0
+	for i := range c.sendWindow {
+		if c.sendWindow[i].sendCount > 0 && !c.sendWindow[i].acked {
+			inFlight++
+		}
+	}
+	return inFlight
+}
@@ -0,0 +1,119 @@
+package networking
+
+import (
+	"project-go/util"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSlowStartDoublesEveryRoundTrip(t *testing.T) {
+	congestion := NewCongestionControl()
+	if congestion.Window() != INITIAL_CONGESTION_WINDOW {
+		t.Fatalf("window starts at %d, expected %d", congestion.Window(), INITIAL_CONGESTION_WINDOW)
+	}
+
+	// A whole window acked is a round trip, and each one doubles the window
+	for _, expected := range []int{8, 16, 32, MAX_WINDOW_SIZE, MAX_WINDOW_SIZE} {
+		congestion.Acked(congestion.Window())
+		if congestion.Window() != expected {
+			t.Errorf("window is %d, expected %d", congestion.Window(), expected)
+		}
+	}
+}
+
+func TestLossHalvesThresholdAndRestartsWindow(t *testing.T) {
+	congestion := NewCongestionControl()
+	congestion.Acked(12)
+
+	congestion.Lost(16)
+	if congestion.Window() != 1 || congestion.threshold != 8 {
+		t.Errorf("after losing 16 in flight window is %d and threshold %.1f, expected 1 and 8", congestion.Window(), congestion.threshold)
+	}
+
+	// The threshold never drops so low that recovering takes forever
+	congestion.Lost(1)
+	if congestion.Window() != 1 || congestion.threshold != MIN_SLOW_START_THRESHOLD {
+		t.Errorf("after losing 1 in flight window is %d and threshold %.1f, expected 1 and %d", congestion.Window(), congestion.threshold, MIN_SLOW_START_THRESHOLD)
+	}
+}
+
+func TestAdditiveIncreaseAboveThreshold(t *testing.T) {
+	congestion := NewCongestionControl()
+	congestion.Lost(16)
+
+	// Slow start, one packet per ack, up to the threshold
+	congestion.Acked(7)
+	if congestion.Window() != 8 {
+		t.Fatalf("window is %d after slow start, expected 8", congestion.Window())
+	}
+
+	// From there a whole window of acks only adds about one packet, each ack adding a little less than the last
+	for round, expected := range []int{9, 10, 11} {
+		congestion.Acked(congestion.Window() + 1)
+		if congestion.Window() != expected {
+			t.Errorf("round %d: window is %d (%.2f), expected %d", round, congestion.Window(), congestion.window, expected)
+		}
+	}
+}
+
+func TestSendingKeepsWithinCongestionAndPeerWindow(t *testing.T) {
+	for _, features := range []ConnectionFeatures{0, DEFAULT_FEATURES} {
+		pair := newTestPair(t, features, Impairment{Loss: 0.1, Seed: 1})
+		pair.connect()
+		opener, responder := pair.peers[0], pair.peers[1]
+		conn := opener.conn
+
+		messages := chatMessages("message", 200)
+		pair.sendChat(opener, messages...)
+
+		// Ticks the opener by hand, so every packet it sends can be checked against the windows it had at the time
+		smallest, largest := MAX_WINDOW_SIZE, 0
+		deadline := pair.clock.Now().Add(time.Minute * 5)
+		for len(responder.received) < len(messages) {
+			if pair.clock.Now().After(deadline) {
+				t.Fatalf("features %d: only %d messages arrived", features, len(responder.received))
+			}
+
+			pair.receive(opener)
+			pair.receive(responder)
+
+			opener.record(conn.CheckLoss())
+			limit := util.Min(conn.congestion.Window(), conn.PeerWindow())
+			smallest, largest = util.Min(smallest, conn.congestion.Window()), util.Max(largest, conn.congestion.Window())
+
+			window := make([]uint32, len(conn.sendWindow))
+			for i := range conn.sendWindow {
+				window[i] = conn.sendWindow[i].sequence
+			}
+
+			packets := conn.GetPackets()
+			for _, packet := range packets {
+				for i, sequence := range window {
+					if sequence == packet.sequence && i >= limit {
+						t.Fatalf("features %d: sent packet %d of the window, with a congestion window of %d and a peer window of %d", features, i+1, conn.congestion.Window(), conn.PeerWindow())
+					}
+				}
+			}
+
+			packets = append(packets, conn.GetAckPackets()...)
+			for _, packet := range packets {
+				if err := SendTransport(packet, conn); err != nil {
+					t.Fatalf("sending packet: %s", err.Error())
+				}
+			}
+
+			pair.tick(responder)
+			pair.clock.Advance(TEST_TICK)
+		}
+
+		if !reflect.DeepEqual(responder.received, messages) {
+			t.Errorf("features %d: received %q", features, responder.received)
+		}
+
+		// The window has to have both grown past where it started and been cut back by a loss for this to mean anything
+		if smallest != 1 || largest <= INITIAL_CONGESTION_WINDOW {
+			t.Errorf("features %d: congestion window only went between %d and %d", features, smallest, largest)
+		}
+	}
+}
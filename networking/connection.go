@@ -11,12 +11,9 @@ import (
 	"time"
 )
 
-// Hardcoded window size of 4, the volume of packets is low enough that this will never get hit anyways
+// The window we assume the peer has until it tells us otherwise
 const WINDOW_SIZE int = 4
 
-// Add some time on top of the round trip time for processing
-const GRACE_PERIOD time.Duration = time.Millisecond * 50
-
 // We allow 5 losses before considering the connection dead
 const MAX_LOSSES = 5
 
@@ -27,12 +24,25 @@ type ConnectionHeader struct {
 	sequence      uint32
 
 	packetType ConnectionPacketType
+	window     uint16 // How many packets the sender can currently take from us, starting at the next one it expects
+
+	fragmentId     uint32        // Which message this packet carries a piece of, every fragment of a message shares it
+	fragmentOffset uint32        // Where in the message this packet's data starts
+	fragmentFlags  FragmentFlags // Whether more of the message follows in later packets
+
+	checksum uint32 // CRC32 of the header and data, with this field as zero
 }
 
 type ConnectionPacket struct {
 	ConnectionHeader
 
 	data []byte
+
+	// Only tracked locally while the packet is in our send window, never on the wire
+	sentAt    time.Time // When we last sent this packet
+	sendCount int       // How many times we've sent it, only packets sent once can be timed
+	deadline  time.Time // When selective repeat gives up on this packet and resends it, zero if it is due to be sent
+	acked     bool      // Whether selective repeat has seen an ack for this packet while it waits on earlier ones
 }
 
 type ConnectionPacketType int32
@@ -43,8 +53,17 @@ const (
 	CONNECTION_DATA
 	CONNECTION_ACK
 	CONNECTION_CLOSE
+	CONNECTION_DATA_ACK
+	CONNECTION_ABORT
+	CONNECTION_KEEPALIVE
+	CONNECTION_RESUME
 )
 
+// Acks, aborts and keepalives are never resent, so they don't take up a sequence number
+func (t ConnectionPacketType) sequenced() bool {
+	return t != CONNECTION_ACK && t != CONNECTION_ABORT && t != CONNECTION_KEEPALIVE
+}
+
 type ConnectionState int
 
 const (
@@ -52,26 +71,75 @@ const (
 	REQUESTED
 	RESPONDED
 	ESTABLISHED
+	CLOSING
+)
+
+// What a packet did to the connection, so the layers above know when to react
+type ConnectionEvent int
+
+const (
+	NO_EVENT ConnectionEvent = iota
+	// The handshake finished and the connection is ready
+	PEER_CONNECTED
+	// The peer closed the connection, and everything it sent before closing has arrived
+	PEER_CLOSED
+	// The peer dropped the connection without waiting, anything it had in flight may be lost
+	PEER_ABORTED
+	// Nothing has been heard from the peer for longer than the dead peer timeout, the connection is still open
+	PEER_UNRESPONSIVE
+	// The peer was unresponsive, but we have heard from it again
+	PEER_RECOVERED
+	// The peer stopped acknowledging what we sent, we keep trying to resume the connection until the grace window runs out
+	PEER_LOST
+	// The peer stopped responding and the connection is gone for good
+	PEER_TIMED_OUT
+	// A dropped connection was picked back up, anything that was in flight when it dropped may be lost
+	PEER_RESUMED
 )
 
 // Collection of everything we need to track for an open connection
 type Connection struct {
-	peer            net.HardwareAddr   // MAC address of peer
-	destId          uuid.UUID          // The peer's machine ID, in case there are multiple clients
-	state           ConnectionState    // Which state the connection is in, which changes how we parse
-	sentSeq         uint32             // The last sequence number we sent
-	expectedRecvSeq uint32             // The next sequence number we expect to receive
-	ackSent         time.Time          // When we sent the initial packet
-	ackRecv         time.Time          // When we received a response to our initial packet
-	sendWindow      []ConnectionPacket // The window for which packets to send
-	ackQueue        []ConnectionPacket // Acknowledgements are not subject to resending
-	windowPos       int                // Where in the send window we currently are
-	lossDeadline    time.Time          // Which time we can declare a packet lost and resend the window
-	numLosses       int                // How many losses we've sustained, resets upon receiving an ack
+	host               *Host                       // The host this connection belongs to, and the link we send our frames on
+	peer               net.HardwareAddr            // MAC address of peer
+	destId             uuid.UUID                   // The peer's machine ID, in case there are multiple clients
+	state              ConnectionState             // Which state the connection is in, which changes how we parse
+	sentSeq            uint32                      // The last sequence number we sent
+	expectedRecvSeq    uint32                      // The next sequence number we expect to receive
+	rtt                RTTEstimator                // Round trip time measurements, which decide how long we wait before resending
+	congestion         CongestionControl           // How many packets the network between us seems able to take in flight
+	sendWindow         []ConnectionPacket          // The window for which packets to send
+	ackQueue           []ConnectionPacket          // Acknowledgements are not subject to resending
+	windowPos          int                         // Where in the send window we currently are
+	lossDeadline       time.Time                   // Which time we can declare a packet lost and resend the window
+	numLosses          int                         // How many losses we've sustained, resets upon receiving an ack
+	offered            ConnectionFeatures          // Which features we ask for when opening a connection, or accept when asked
+	features           ConnectionFeatures          // Which features both sides agreed on for this connection
+	receiveBuffer      map[uint32]ConnectionPacket // Packets received out of order, waiting for the ones before them
+	ackPending         bool                        // Whether we owe the peer a cumulative ack, sent once per tick
+	receiveWindow      int                         // How many packets we're willing to take in flight, advertised to the peer
+	peerWindow         int                         // How many packets the peer last said it can take in flight
+	probeDeadline      time.Time                   // When we next probe a peer whose window is closed
+	probeInterval      time.Duration               // How long we waited between the last two probes
+	nextFragmentId     uint32                      // The ID given to the next message we send
+	reassembly         []byte                      // The message we are piecing back together from its fragments
+	reassemblyId       uint32                      // Which message we are piecing together
+	reassemblyDeadline time.Time                   // When we give up on the rest of the message arriving
+	closeDeadline      time.Time                   // When we stop waiting for the peer to acknowledge our close
+	lastHeard          time.Time                   // When we last received anything from the peer
+	nextKeepalive      time.Time                   // The earliest we may send the peer another keepalive
+	deadPeerTimeout    time.Duration               // How long the peer can stay silent before we call it unresponsive
+	unresponsive       bool                        // Whether the peer has been silent for longer than the dead peer timeout
+	sessionId          uuid.UUID                   // Handed out by the responder during the handshake, so the connection can be resumed if it drops
+	opened             bool                        // Whether we sent the request that opened the connection, which decides who goes ahead if both sides resume at once
+	resuming           bool                        // Whether the handshake in progress picks up a dropped connection rather than opening a new one
+	resumeDeadline     time.Time                   // When we stop trying to resume a dropped connection
+	resumeAnswered     bool                        // Whether we took the peer back after it resumed the connection
+	answeredResume     uint32                      // The sequence number of the resume we answered, so a late copy isn't taken for a new one
+	handshakeDeadline  time.Time                   // When we give up on a handshake that never finished
 }
 
-func NewConnection() *Connection {
-	conn := Connection{}
+func NewConnection(host *Host) *Connection {
+	conn := Connection{host: host, offered: DEFAULT_FEATURES, receiveWindow: DEFAULT_RECEIVE_WINDOW, deadPeerTimeout: DEFAULT_DEAD_PEER_TIMEOUT}
 	// Set all of the default values for an empty connection
 	conn.reset()
 	return &conn
@@ -92,12 +160,13 @@ func (c *Connection) SetPeer(addr net.HardwareAddr) {
 func (c *Connection) NewConnectionRequest() ConnectionPacket {
 	packet := ConnectionPacket{
 		ConnectionHeader: ConnectionHeader{
-			sourceMachine: clientId,
+			sourceMachine: c.host.clientId,
 			destMachine:   uuid.UUID{},
 			sequence:      c.sentSeq,
 			packetType:    CONNECTION_REQUEST,
+			window:        c.advertisedWindow(),
 		},
-		data: nil,
+		data: c.offered.serialize(),
 	}
 
 	return packet
@@ -106,10 +175,11 @@ func (c *Connection) NewConnectionRequest() ConnectionPacket {
 func (c *Connection) NewConnectionAck(sequence uint32) ConnectionPacket {
 	packet := ConnectionPacket{
 		ConnectionHeader: ConnectionHeader{
-			sourceMachine: clientId,
+			sourceMachine: c.host.clientId,
 			destMachine:   c.destId,
 			sequence:      sequence,
 			packetType:    CONNECTION_ACK,
+			window:        c.advertisedWindow(),
 		},
 		data: nil,
 	}
@@ -117,15 +187,20 @@ func (c *Connection) NewConnectionAck(sequence uint32) ConnectionPacket {
 	return packet
 }
 
-func (c *Connection) NewConnectionResponse() ConnectionPacket {
+func (c *Connection) NewConnectionResponse(request uint32) ConnectionPacket {
+	// The features we agreed to, the session, then which request this answers, so a stale response can't be mistaken for it
+	data := append(c.features.serialize(), c.sessionId[:]...)
+	data = binary.BigEndian.AppendUint32(data, request)
+
 	packet := ConnectionPacket{
 		ConnectionHeader: ConnectionHeader{
-			sourceMachine: clientId,
+			sourceMachine: c.host.clientId,
 			destMachine:   c.destId,
 			sequence:      c.sentSeq,
 			packetType:    CONNECTION_RESPONSE,
+			window:        c.advertisedWindow(),
 		},
-		data: nil,
+		data: data,
 	}
 
 	return packet
@@ -134,10 +209,11 @@ func (c *Connection) NewConnectionResponse() ConnectionPacket {
 func (c *Connection) NewConnectionData(data []byte) ConnectionPacket {
 	connection := ConnectionPacket{
 		ConnectionHeader: ConnectionHeader{
-			sourceMachine: clientId,
+			sourceMachine: c.host.clientId,
 			destMachine:   c.destId,
 			sequence:      c.sentSeq,
 			packetType:    CONNECTION_DATA,
+			window:        c.advertisedWindow(),
 		},
 		data: data,
 	}
@@ -145,75 +221,108 @@ func (c *Connection) NewConnectionData(data []byte) ConnectionPacket {
 	return connection
 }
 
-func (c *Connection) NewConnectionClose() ConnectionPacket {
-	connection := ConnectionPacket{
-		ConnectionHeader: ConnectionHeader{
-			sourceMachine: clientId,
-			destMachine:   c.destId,
-			sequence:      c.sentSeq,
-			packetType:    CONNECTION_CLOSE,
-		},
-		data: nil,
-	}
-
-	return connection
-}
-
-func (c *Connection) Handle(packet ConnectionPacket, source net.HardwareAddr) (bool, []byte, error) {
-	var extraData []byte = nil
+func (c *Connection) Handle(packet ConnectionPacket, source net.HardwareAddr) (ConnectionEvent, [][]byte, error) {
+	var received [][]byte = nil
 	var response *ConnectionPacket = nil
 	var err error = nil
 
 	logging.Debugf("received connection packet, type %d sequence %d\n", packet.packetType, packet.sequence)
 
-	if c.state != IDLE && c.state != REQUESTED && clientId != packet.destMachine {
-		logging.Debugf("not addressed to us, addressed to %x, we are %x\n", packet.destMachine, clientId)
-		return false, nil, fmt.Errorf("packet not addressed to us")
+	// The peer may still be waiting to hear that its close arrived, even though we're long gone
+	if c.state == IDLE && packet.packetType == CONNECTION_CLOSE {
+		return NO_EVENT, nil, c.ackStrayClose(packet, source)
+	}
+
+	// A resume starts the connection over, so it is checked against the session rather than the connection as it stands
+	if packet.packetType == CONNECTION_RESUME {
+		return NO_EVENT, nil, c.handleResume(packet, source)
+	}
+
+	// A request isn't addressed to anyone yet, so it can't be checked against the connection either
+	if packet.packetType == CONNECTION_REQUEST {
+		return NO_EVENT, nil, c.handleRequest(packet, source)
+	}
+
+	if c.state != IDLE && c.state != REQUESTED && c.host.clientId != packet.destMachine {
+		logging.Debugf("not addressed to us, addressed to %x, we are %x\n", packet.destMachine, c.host.clientId)
+		return NO_EVENT, nil, fmt.Errorf("packet not addressed to us")
 	}
 
 	if c.state != IDLE && c.state != REQUESTED && c.destId != packet.sourceMachine {
-		return false, nil, fmt.Errorf("packet not received from connection peer")
+		return NO_EVENT, nil, fmt.Errorf("packet not received from connection peer")
 	}
 
-	if packet.packetType != CONNECTION_ACK && packet.sequence > c.expectedRecvSeq {
-		logging.Debugf("ignoring out of order packet, got %d expected max of %d\n", packet.sequence, c.expectedRecvSeq+1)
-		return false, nil, fmt.Errorf("packet received out of order")
+	// Every packet from our peer tells us how much room it has, and that it is still there
+	if c.state != IDLE && c.state != REQUESTED {
+		c.updatePeerWindow(packet.window)
+		c.lastHeard = c.host.clock.Now()
+	}
+
+	if packet.packetType.sequenced() && c.knowsPeerSequence() && !c.inReceiveWindow(packet.sequence) {
+		logging.Debugf("ignoring out of order packet, got %d expected %d\n", packet.sequence, c.expectedRecvSeq)
+
+		// This may be probing a window we closed, so answer with where we are and how much room we have
+		if c.state == ESTABLISHED && (packet.packetType == CONNECTION_DATA || packet.packetType == CONNECTION_DATA_ACK) {
+			c.QueuePacket(c.NewConnectionAck(c.expectedRecvSeq - 1))
+		}
+		return NO_EVENT, nil, fmt.Errorf("packet received out of order")
 	}
 
 	// Handle each packet type
-	connectionStatusChanged := false
+	event := NO_EVENT
 	switch packet.packetType {
-	case CONNECTION_REQUEST:
-		response, err = c.handleRequest(packet, source)
-		break
 	case CONNECTION_RESPONSE:
-		response, err = c.handleResponse(packet)
-		connectionStatusChanged = true
+		event, response, err = c.handleResponse(packet)
 		break
 	case CONNECTION_ACK:
-		connectionStatusChanged, err = c.handleAck(packet)
+		event, err = c.handleAck(packet)
 		break
 	case CONNECTION_DATA:
-		response, extraData, err = c.handleData(packet)
+		response, received, err = c.handleData(packet)
+		if err != nil {
+			return NO_EVENT, nil, err
+		}
+		break
+	case CONNECTION_DATA_ACK:
+		// Deal with the ack on the front, then the rest is just data
+		event, packet, err = c.handleDataAck(packet)
 		if err != nil {
-			return false, nil, err
+			return NO_EVENT, nil, err
+		}
+		response, received, err = c.handleData(packet)
+		if err != nil {
+			return NO_EVENT, nil, err
 		}
 		break
 	case CONNECTION_CLOSE:
-		if c.IsActive() {
+		// Nothing is left of the connection afterwards, so there is nothing more to update
+		event, err = c.handleClose(packet)
+		return event, nil, err
+	case CONNECTION_KEEPALIVE:
+		// The peer hasn't heard from us in a while, any ack shows we're still here
+		if c.state == ESTABLISHED {
+			ack := c.NewConnectionAck(c.expectedRecvSeq - 1)
+			response = &ack
+		}
+		break
+	case CONNECTION_ABORT:
+		// A peer we are trying to resume with may have given up on us already
+		refused := c.state == REQUESTED && c.resuming && packet.sourceMachine == c.destId && packet.destMachine == c.host.clientId
+		if refused || (c.state != IDLE && c.state != REQUESTED) {
+			logging.Debugf("peer aborted the connection\n")
 			c.reset()
-			connectionStatusChanged = true
+			event = PEER_ABORTED
 		}
 		break
 	}
 
 	// Exit early if there was an error handling the packet
 	if err != nil {
-		return false, nil, err
+		return NO_EVENT, nil, err
 	}
 
-	// ACKs don't increment the sequence number
-	if packet.packetType != CONNECTION_ACK && packet.sequence == c.expectedRecvSeq {
+	// Only sequenced packets move the sequence number along, and selective repeat data has already moved it
+	if packet.packetType.sequenced() && packet.sequence == c.expectedRecvSeq {
 		c.expectedRecvSeq++
 	}
 
@@ -222,13 +331,19 @@ func (c *Connection) Handle(packet ConnectionPacket, source net.HardwareAddr) (b
 		c.QueuePacket(*response)
 	}
 
-	return connectionStatusChanged, extraData, nil
+	return event, received, nil
 }
 
 func (c *Connection) QueuePacket(packet ConnectionPacket) {
-	if packet.packetType == CONNECTION_ACK {
+	if packet.packetType == CONNECTION_ACK && c.CumulativeAcks() {
+		// One ack covers everything, so we just note that one is owed and build it when it's time to send
+		c.ackPending = true
+	} else if packet.packetType == CONNECTION_ACK {
 		// Acks are in a separate queue because they don't get resent
 		c.ackQueue = append(c.ackQueue, packet)
+	} else if packet.packetType == CONNECTION_DATA {
+		// Data may be too big for one frame, so it is split up with each piece getting its own sequence number
+		c.queueFragments(packet)
 	} else {
 		// This is a new packet, so we need to increment the sequence number
 		c.sendWindow = append(c.sendWindow, packet)
@@ -237,20 +352,46 @@ func (c *Connection) QueuePacket(packet ConnectionPacket) {
 }
 
 func (c *Connection) GetPackets() []ConnectionPacket {
+	var packets []ConnectionPacket
+	if c.peerWindow == 0 {
+		packets = c.getWindowProbe()
+	} else if c.SelectiveRepeat() {
+		packets = c.getSelectivePackets()
+	} else {
+		packets = c.getGoBackNPackets()
+	}
+
+	// Packets may have been queued a while ago, so they carry how much room we have now
+	for i := range packets {
+		packets[i].window = c.advertisedWindow()
+	}
+
+	return c.piggybackAcks(packets)
+}
+
+func (c *Connection) getGoBackNPackets() []ConnectionPacket {
 	// From is the minimum of: window position, window size, num packets in send queue
-	from := util.Min(c.windowPos, WINDOW_SIZE)
+	from := util.Min(c.windowPos, c.sendWindowSize())
 	from = util.Min(from, len(c.sendWindow))
 
 	// To is the minimum of window size, num packets in send queue
-	to := util.Min(WINDOW_SIZE, len(c.sendWindow))
-
-	// Get our slice of packets to send
-	slice := c.sendWindow[from:to]
+	to := util.Min(c.sendWindowSize(), len(c.sendWindow))
 
-	if len(slice) == 0 {
+	if from >= to {
 		return nil
 	}
 
+	// Copy our slice of packets to send, so appending to it can never overwrite the rest of the send window
+	slice := make([]ConnectionPacket, to-from)
+	copy(slice, c.sendWindow[from:to])
+
+	// Remember when each packet went out, so its ack can be timed
+	now := c.host.clock.Now()
+	for i := from; i < to; i++ {
+		c.sendWindow[i].sentAt = now
+		c.sendWindow[i].sendCount++
+	}
+
 	// We're sending these packets, so this is our new window position
 	c.windowPos = to
 
@@ -262,72 +403,95 @@ func (c *Connection) GetPackets() []ConnectionPacket {
 }
 
 func (c *Connection) GetAckPackets() []ConnectionPacket {
-	// We just want to send all of the ack packets, no window size
-	slice := c.ackQueue[:]
+	var packets []ConnectionPacket
 
-	if len(slice) == 0 {
-		return nil
+	// Everything received since the last tick is covered by a single ack
+	if c.ackPending {
+		c.ackPending = false
+		packets = append(packets, c.NewSelectiveAck())
+	}
+
+	// Copy the rest out, since the queue's storage is reused for the next acks
+	for _, ack := range c.ackQueue {
+		ack.window = c.advertisedWindow()
+		packets = append(packets, ack)
 	}
 
 	// Empty the queue
 	c.ackQueue = c.ackQueue[:0]
 
-	logging.Debugf("sending acks %d to %d\n", slice[0].sequence, slice[len(slice)-1].sequence)
+	// Check the peer is still there if it has gone quiet, keepalives are never resent so they go out with the acks
+	if c.keepaliveDue() {
+		packets = append(packets, c.NewConnectionKeepalive())
+	}
+
+	if len(packets) > 0 {
+		logging.Debugf("sending acks %d to %d\n", packets[0].sequence, packets[len(packets)-1].sequence)
+	}
 
-	return slice
+	return packets
 }
 
-func (c *Connection) CheckLoss() bool {
-	if !c.lossDeadline.IsZero() && time.Now().After(c.lossDeadline) {
-		// We had a frame loss, reset the window
+func (c *Connection) CheckLoss() ConnectionEvent {
+	// A message that stopped arriving part way through is thrown away
+	c.expireReassembly()
+
+	// A peer that never acknowledges our close is dropped
+	if c.checkCloseTimeout() {
+		return NO_EVENT
+	}
+
+	// So is a dropped connection the peer never came back to, or one that never finished opening
+	if c.checkResumeTimeout() || c.checkHandshakeTimeout() {
+		return PEER_TIMED_OUT
+	}
+
+	// A peer with a closed window isn't losing our packets, it's just not taking them, so we probe instead
+	if c.peerWindow == 0 {
+		return NO_EVENT
+	}
+
+	if c.SelectiveRepeat() {
+		return c.checkSelectiveLoss()
+	}
+
+	if !c.lossDeadline.IsZero() && c.host.clock.Now().After(c.lossDeadline) {
+		// We had a frame loss, reset the window, wait longer next time and send less at once
+		c.congestion.Lost(c.packetsInFlight())
 		c.windowPos = 0
 		c.numLosses++
+		c.rtt.Backoff()
 
-		logging.Debug("we had a loss")
+		logging.Debugf("we had a loss, timeout is now %s\n", c.rtt.Timeout())
 
-		// Close the connection if we continue to get losses
+		// Drop the connection if we continue to get losses
 		if c.numLosses > MAX_LOSSES {
-			c.Close()
-			return true
+			return c.giveUp()
 		}
 	}
 
-	return false
+	return NO_EVENT
 }
 
 func (c *Connection) Open(peer net.HardwareAddr) error {
-	// We can only have one active connection at a time
+	// A connection only ever talks to one peer, the connection table opens a new one for each
 	if c.IsActive() {
 		return fmt.Errorf("there is already an active connection")
 	}
 
 	// We need to know who to send packets to
 	c.SetPeer(peer)
+	c.opened = true
 
 	// Queue the connection request
 	transportPacket := c.NewConnectionRequest()
 	c.QueuePacket(transportPacket)
 	c.state = REQUESTED
-
-	// Time how long we take to get a response
-	c.ackSent = time.Now()
+	c.handshakeDeadline = c.host.clock.Now().Add(HANDSHAKE_TIMEOUT)
 
 	return nil
 }
 
-func (c *Connection) Close() {
-	// Forcefully send a connection closed packet, since we are about to remove all of our state tracking
-	// If this frame is lost, the other party will eventually figure out that we're gone
-	transportData, err := PackageTransport(c.NewConnectionClose(), c)
-	if err != nil {
-		logging.Debugf("error sending connection close: " + err.Error())
-	}
-	SendChan <- transportData
-
-	// Remove our connection tracking
-	c.reset()
-}
-
 func (c ConnectionPacket) Data() []byte {
 	return c.data
 }
@@ -335,59 +499,118 @@ func (c ConnectionPacket) Data() []byte {
 func (c *Connection) reset() {
 	// Get rid of all state from the connection
 	c.state = IDLE
-	c.sentSeq = 0
+	c.sentSeq = initialSequence()
 	c.expectedRecvSeq = 0
 	c.sendWindow = make([]ConnectionPacket, 0)
 	c.ackQueue = make([]ConnectionPacket, 0)
 	c.windowPos = 0
 	c.peer = nil
 	c.numLosses = 0
-	c.ackSent = time.Time{}
-	c.ackRecv = time.Time{}
+	c.rtt = NewRTTEstimator()
+	c.congestion = NewCongestionControl()
+	c.features = 0
+	c.receiveBuffer = make(map[uint32]ConnectionPacket)
+	c.ackPending = false
+	c.peerWindow = WINDOW_SIZE
+	c.probeDeadline = time.Time{}
+	c.probeInterval = 0
+	c.nextFragmentId = 0
+	c.dropReassembly()
+	c.closeDeadline = time.Time{}
+	c.lastHeard = time.Time{}
+	c.nextKeepalive = time.Time{}
+	c.unresponsive = false
+	c.sessionId = uuid.Nil
+	c.opened = false
+	c.resuming = false
+	c.resumeDeadline = time.Time{}
+	c.resumeAnswered = false
+	c.answeredResume = 0
+	c.handshakeDeadline = time.Time{}
 }
 
 func (c *Connection) setDeadline() {
 	// Update our loss deadline so that we can resend if necessary
-	c.lossDeadline = time.Now().Add(GRACE_PERIOD)
-	if c.state == ESTABLISHED {
-		// Only do round trip time if we know what the round trip time is
-		c.lossDeadline = c.lossDeadline.Add(c.ackRecv.Sub(c.ackSent))
-	}
+	c.lossDeadline = c.host.clock.Now().Add(c.rtt.Timeout())
 }
 
-func (c *Connection) handleRequest(packet ConnectionPacket, source net.HardwareAddr) (*ConnectionPacket, error) {
-	// If we already have a connection, we ignore this
-	if c.state != IDLE {
-		return nil, fmt.Errorf("we already have a connection")
+func (c *Connection) handleRequest(packet ConnectionPacket, source net.HardwareAddr) error {
+	// A request that clashes with a handshake we already started may be one we still need to answer
+	handled, err := c.handleRepeatRequest(packet, source)
+	if handled {
+		return err
 	}
 
-	if packet.sequence > c.expectedRecvSeq {
-		return nil, fmt.Errorf("packet received out of order")
+	// If we already have a connection, we ignore this
+	if c.state != IDLE {
+		return fmt.Errorf("we already have a connection")
 	}
 
-	// We now know the client ID
+	// We now know the client ID, and where its sequence numbers start
 	c.destId = packet.sourceMachine
+	c.expectedRecvSeq = packet.sequence + 1
 	c.state = RESPONDED
 	c.peer = source
+	c.handshakeDeadline = c.host.clock.Now().Add(HANDSHAKE_TIMEOUT)
 
-	// Send response
-	response := c.NewConnectionResponse()
+	// The peer needs this to resume the connection if it drops
+	c.sessionId = uuid.New()
+
+	// We can only use the features we both know about, and our response tells the peer which those are
+	c.features = c.offered & featuresDeserialize(packet.data)
+	c.updatePeerWindow(packet.window)
 
-	// Time how long we take to get a response
-	c.ackSent = time.Now()
+	// Send response
+	c.QueuePacket(c.NewConnectionResponse(packet.sequence))
 
-	return &response, nil
+	return nil
 }
 
-func (c *Connection) handleResponse(packet ConnectionPacket) (*ConnectionPacket, error) {
+func (c *Connection) handleResponse(packet ConnectionPacket) (ConnectionEvent, *ConnectionPacket, error) {
+	// Our ack of the response was lost, so the peer is still waiting to hear that the handshake finished
+	if c.state == ESTABLISHED && packet.sourceMachine == c.destId && seqLess(packet.sequence, c.expectedRecvSeq) {
+		logging.Debugf("peer sent its response again, acknowledging it again\n")
+		ack := c.NewConnectionAck(packet.sequence)
+		return NO_EVENT, &ack, nil
+	}
+
 	// Ignore if it doesn't match our request
 	if c.state != REQUESTED {
-		return nil, fmt.Errorf("we did not request a connection")
+		return NO_EVENT, nil, fmt.Errorf("we did not request a connection")
+	}
+
+	// A response to a request left over from an earlier connection leaves the peer half open, so we tell it to let go
+	request, ok := responseRequestDeserialize(packet.data)
+	if ok && request != c.sendWindow[0].sequence {
+		err := c.replyStray(packet, c.peer, CONNECTION_ABORT)
+		if err != nil {
+			logging.Debugf("error aborting stale response: " + err.Error())
+		}
+		return NO_EVENT, nil, fmt.Errorf("response is for a different request")
+	}
+
+	// When resuming, only the peer we had the session with can answer
+	event := PEER_CONNECTED
+	session := sessionDeserialize(packet.data)
+	if c.resuming {
+		if packet.sourceMachine != c.destId || session != c.sessionId {
+			return NO_EVENT, nil, fmt.Errorf("response is not for the session we are resuming")
+		}
+		event = PEER_RESUMED
+		c.resuming = false
 	}
 
-	// We now know the client ID
+	// We now know the client ID, where its sequence numbers start, and that the peer is there
 	c.destId = packet.sourceMachine
+	c.expectedRecvSeq = packet.sequence
+	c.sessionId = session
 	c.state = ESTABLISHED
+	c.lastHeard = c.host.clock.Now()
+	c.handshakeDeadline = time.Time{}
+
+	// The peer has told us which of our features it agreed to
+	c.features = c.offered & featuresDeserialize(packet.data)
+	c.updatePeerWindow(packet.window)
 
 	// Ack it
 	response := c.NewConnectionAck(packet.sequence)
@@ -395,65 +618,108 @@ func (c *Connection) handleResponse(packet ConnectionPacket) (*ConnectionPacket,
 	// This is treated as an ack
 	c.goBackNAck()
 
-	// We got a response, we need to wait at least this long before considering a packet lost
-	c.ackRecv = time.Now()
-
-	return &response, nil
+	return event, &response, nil
 }
 
-func (c *Connection) handleAck(packet ConnectionPacket) (bool, error) {
-	connectionStatusChanged := false
+func (c *Connection) handleAck(packet ConnectionPacket) (ConnectionEvent, error) {
+	event := NO_EVENT
 
 	// If this does not match our state, we ignore
 	if c.state == IDLE || c.state == REQUESTED {
-		return false, fmt.Errorf("we do not have an active connection")
+		return NO_EVENT, fmt.Errorf("we do not have an active connection")
 	}
 
-	if len(c.sendWindow) < 1 || packet.sequence != c.sendWindow[0].sequence {
+	// A peer can't have received what we haven't sent, so this must be left over from an earlier connection
+	if seqLess(c.sentSeq-1, packet.sequence) {
+		return NO_EVENT, fmt.Errorf("ack for a packet we never sent")
+	}
+
+	if c.SelectiveRepeat() {
+		err := c.handleSelectiveAck(packet)
+		if err != nil {
+			return NO_EVENT, err
+		}
+	} else if c.CumulativeAcks() {
+		err := c.goBackNCumulativeAck(packet.sequence)
+		if err != nil {
+			return NO_EVENT, err
+		}
+	} else if len(c.sendWindow) < 1 || packet.sequence != c.sendWindow[0].sequence {
 		logging.Debugf("ack rejected out of order acked %d", packet.sequence)
 		if len(c.sendWindow) < 1 {
 			logging.Debugf("we have no sent\n")
 		} else {
 			logging.Debugf(" expected %d\n", c.sendWindow[0].sequence)
 		}
-		return false, fmt.Errorf("received out of order ack")
+		return NO_EVENT, fmt.Errorf("received out of order ack")
+	} else {
+		c.goBackNAck()
 	}
 
 	if c.state == RESPONDED {
-		// We got a response, we need to wait at least this long before considering a packet lost
-		c.ackRecv = time.Now()
 		c.state = ESTABLISHED
-		// Since the connection is now established, this is a new connection
-		connectionStatusChanged = true
+		c.handshakeDeadline = time.Time{}
+		// Since the connection is now established, this is a new connection, unless the peer was picking up an old one
+		event = PEER_CONNECTED
+		if c.resuming {
+			event = PEER_RESUMED
+			c.resuming = false
+		}
 	}
 
-	c.goBackNAck()
+	// Once our close has been acknowledged, the peer has everything we sent and the connection is done
+	if c.state == CLOSING && len(c.sendWindow) == 0 {
+		logging.Debugf("peer acknowledged our close\n")
+		c.reset()
+	}
 
-	return connectionStatusChanged, nil
+	return event, nil
 }
 
-func (c *Connection) handleData(packet ConnectionPacket) (*ConnectionPacket, []byte, error) {
+func (c *Connection) handleData(packet ConnectionPacket) (*ConnectionPacket, [][]byte, error) {
 	if c.state == IDLE || c.state == REQUESTED {
 		return nil, nil, fmt.Errorf("we do not have an active connection")
 	}
 
+	// The peer only sends data once it has our response, and acknowledging that finishes the handshake
+	// Data before then is left over from an earlier connection, or will be resent once our response gets through
+	if c.state == RESPONDED {
+		return nil, nil, fmt.Errorf("handshake has not finished")
+	}
+
+	if c.SelectiveRepeat() {
+		response, received := c.handleSelectiveData(packet)
+		return response, received, nil
+	}
+
 	// Send an ack
 	response := c.NewConnectionAck(packet.sequence)
 
 	// Return data if this is a new packet
-	var extraData []byte = nil
+	var received [][]byte = nil
 	if packet.sequence == c.expectedRecvSeq {
-		extraData = packet.data
+		received = c.reassemble(received, packet)
 	}
 	logging.Debugf("received data")
 
-	return &response, extraData, nil
+	return &response, received, nil
 }
 
 func (c *Connection) goBackNAck() {
+	c.goBackNAckThrough(1)
+}
+
+func (c *Connection) goBackNAckThrough(count int) {
+	// Only the last packet acked is timed, the ones before it had their own acks lost and would look slow
+	c.timeAck(c.sendWindow[count-1], c.sendWindow[:count])
+
+	// Every ack means the network coped, so we can send a little more
+	c.congestion.Acked(count)
+
 	// Update go back n
-	c.sendWindow = c.sendWindow[1:]
-	c.windowPos--
+	c.sendWindow = c.sendWindow[count:]
+	// An ack can arrive after a loss has already rewound the window, which must not go below the start
+	c.windowPos = util.Max(c.windowPos-count, 0)
 	c.numLosses = 0
 	if len(c.sendWindow) > 0 {
 		// Reset the deadline grace period since we have more packets
@@ -464,6 +730,23 @@ func (c *Connection) goBackNAck() {
 	}
 }
 
+func (c *Connection) timeAck(acked ConnectionPacket, covered []ConnectionPacket) {
+	// An ack covering a resent packet may have been sent in reply to the resend, so we can't tell what it's timing
+	resent := false
+	for i := range covered {
+		if covered[i].sendCount > 1 {
+			resent = true
+		}
+	}
+
+	// Time the round trip, unless the packet was resent and we can't tell which send this ack is for
+	// The backoff stays until then too, only a measurement tells us what the timeout should go back to (Karn's algorithm)
+	if !resent {
+		c.rtt.Sample(c.host.clock.Now().Sub(acked.sentAt))
+		logging.Debugf("round trip %s, smoothed %s variance %s timeout %s\n", c.host.clock.Now().Sub(acked.sentAt), c.rtt.smoothed, c.rtt.variance, c.rtt.Timeout())
+	}
+}
+
 func (c ConnectionPacket) serialize() ([]byte, error) {
 	logging.Debugf("serializing connection packet of type %d sequence %d\n", c.packetType, c.sequence)
 	buf := bytes.Buffer{}
@@ -492,10 +775,44 @@ func (c ConnectionPacket) serialize() ([]byte, error) {
 		return nil, err
 	}
 
+	// Write the receive window, 2 bytes
+	err = binary.Write(&buf, binary.BigEndian, c.window)
+	if err != nil {
+		return nil, err
+	}
+
+	// Write the fragment ID, 4 bytes
+	err = binary.Write(&buf, binary.BigEndian, c.fragmentId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Write the fragment offset, 4 bytes
+	err = binary.Write(&buf, binary.BigEndian, c.fragmentOffset)
+	if err != nil {
+		return nil, err
+	}
+
+	// Write the fragment flags, 1 byte
+	err = binary.Write(&buf, binary.BigEndian, c.fragmentFlags)
+	if err != nil {
+		return nil, err
+	}
+
+	// Write the checksum, 4 bytes, filled in once everything else is written
+	checksumOffset := buf.Len()
+	err = binary.Write(&buf, binary.BigEndian, uint32(0))
+	if err != nil {
+		return nil, err
+	}
+
 	// Write the data as is
 	buf.Write(c.data)
 
-	return buf.Bytes(), nil
+	packet := buf.Bytes()
+	putChecksum(packet, checksumOffset)
+
+	return packet, nil
 }
 
 func connectionDeserialize(buf []byte) (ConnectionPacket, error) {
@@ -533,6 +850,44 @@ func connectionDeserialize(buf []byte) (ConnectionPacket, error) {
 		return ConnectionPacket{}, err
 	}
 
+	// Read the receive window, 2 bytes
+	err = binary.Read(reader, binary.BigEndian, &connectionPacket.window)
+	if err != nil {
+		return ConnectionPacket{}, err
+	}
+
+	// Read the fragment ID, 4 bytes
+	err = binary.Read(reader, binary.BigEndian, &connectionPacket.fragmentId)
+	if err != nil {
+		return ConnectionPacket{}, err
+	}
+
+	// Read the fragment offset, 4 bytes
+	err = binary.Read(reader, binary.BigEndian, &connectionPacket.fragmentOffset)
+	if err != nil {
+		return ConnectionPacket{}, err
+	}
+
+	// Read the fragment flags, 1 byte
+	err = binary.Read(reader, binary.BigEndian, &connectionPacket.fragmentFlags)
+	if err != nil {
+		return ConnectionPacket{}, err
+	}
+
+	// Read the checksum, 4 bytes
+	checksumOffset := headerSize - reader.Len()
+	err = binary.Read(reader, binary.BigEndian, &connectionPacket.checksum)
+	if err != nil {
+		return ConnectionPacket{}, err
+	}
+
+	// Make sure nothing was damaged on the way
+	// Our header alone is longer than Ethernet's minimum frame, so unlike broadcasts there is never any padding to leave out
+	err = verifyChecksum(buf, checksumOffset)
+	if err != nil {
+		return ConnectionPacket{}, err
+	}
+
 	// Read the rest of the data as is
 	connectionPacket.data = buf[headerSize:]
 
@@ -0,0 +1,147 @@
+package networking
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/google/uuid"
+	"net"
+	"time"
+)
+
+// Every connection a host has open, one for each peer, so each packet can be handed to the connection it belongs to
+type ConnectionTable struct {
+	host            *Host
+	connections     []*Connection
+	offered         ConnectionFeatures // Which features new connections ask for, or accept when asked
+	deadPeerTimeout time.Duration      // How long the peer of a new connection can stay silent before we call it unresponsive
+}
+
+func NewConnectionTable(host *Host) *ConnectionTable {
+	return &ConnectionTable{host: host, offered: DEFAULT_FEATURES, deadPeerTimeout: DEFAULT_DEAD_PEER_TIMEOUT}
+}
+
+func (t *ConnectionTable) SetSelectiveRepeat(enabled bool) {
+	if enabled {
+		t.offered |= FEATURE_SELECTIVE_REPEAT
+	} else {
+		t.offered &^= FEATURE_SELECTIVE_REPEAT
+	}
+
+	for _, conn := range t.connections {
+		conn.SetSelectiveRepeat(enabled)
+	}
+}
+
+func (t *ConnectionTable) SetDeadPeerTimeout(timeout time.Duration) {
+	t.deadPeerTimeout = timeout
+
+	for _, conn := range t.connections {
+		conn.SetDeadPeerTimeout(timeout)
+	}
+}
+
+// Every connection in the table, safe to hold on to while connections are opened and removed
+func (t *ConnectionTable) Connections() []*Connection {
+	connections := make([]*Connection, len(t.connections))
+	copy(connections, t.connections)
+	return connections
+}
+
+// The active connection with this client at this address, or nil if we don't have one
+// A client ID of uuid.Nil, or a connection that hasn't learned its peer's yet, could be any client at the address
+func (t *ConnectionTable) Find(peer net.HardwareAddr, client uuid.UUID) *Connection {
+	for _, conn := range t.connections {
+		if !conn.IsActive() || !bytes.Equal(conn.peer, peer) {
+			continue
+		}
+		if client == uuid.Nil || conn.destId == uuid.Nil || conn.destId == client {
+			return conn
+		}
+	}
+	return nil
+}
+
+func (t *ConnectionTable) Open(peer net.HardwareAddr, client uuid.UUID) (*Connection, error) {
+	// One connection per peer, anything more would only compete with itself
+	// Other clients sharing the address are peers of their own though
+	if t.Find(peer, client) != nil {
+		return nil, fmt.Errorf("there is already a connection with %x (%s)", peer, client)
+	}
+
+	conn := t.newConnection()
+	err := conn.Open(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	// Knowing who we asked lets the answer be told apart from another client's at the same address
+	conn.destId = client
+
+	t.connections = append(t.connections, conn)
+	return conn, nil
+}
+
+// Removes every connection that has closed, they are never used again
+func (t *ConnectionTable) Prune() {
+	active := t.connections[:0]
+	for _, conn := range t.connections {
+		if conn.IsActive() {
+			active = append(active, conn)
+		}
+	}
+
+	// Clear the tail so the closed connections can be collected
+	for i := len(active); i < len(t.connections); i++ {
+		t.connections[i] = nil
+	}
+	t.connections = active
+}
+
+func (t *ConnectionTable) newConnection() *Connection {
+	conn := NewConnection(t.host)
+	conn.offered = t.offered
+	conn.deadPeerTimeout = t.deadPeerTimeout
+	return conn
+}
+
+func (t *ConnectionTable) Handle(packet ConnectionPacket, source net.HardwareAddr) (*Connection, ConnectionEvent, [][]byte, error) {
+	conn := t.lookup(packet, source)
+	if conn != nil {
+		event, received, err := conn.Handle(packet, source)
+		return conn, event, received, err
+	}
+
+	// A new peer asking to connect gets a connection of its own, which is only kept if the request was accepted
+	if packet.packetType == CONNECTION_REQUEST {
+		conn = t.newConnection()
+		event, received, err := conn.Handle(packet, source)
+		if conn.IsActive() {
+			t.connections = append(t.connections, conn)
+		}
+		return conn, event, received, err
+	}
+
+	// Anything else belongs to a connection we no longer have, but may still need an answer
+	// A connection that was never opened answers it the same way one that has since closed would
+	stray := t.newConnection()
+	event, received, err := stray.Handle(packet, source)
+	return nil, event, received, err
+}
+
+func (t *ConnectionTable) lookup(packet ConnectionPacket, source net.HardwareAddr) *Connection {
+	// Once we know a peer's client ID, it tells us which connection is theirs even if two peers share an address
+	for _, conn := range t.connections {
+		if conn.IsActive() && conn.destId != uuid.Nil && conn.destId == packet.sourceMachine {
+			return conn
+		}
+	}
+
+	// Unless we were told who we were asking, until the handshake finishes we only know the address we sent our request to
+	for _, conn := range t.connections {
+		if conn.state == REQUESTED && conn.destId == uuid.Nil && bytes.Equal(conn.peer, source) {
+			return conn
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,201 @@
+package networking
+
+import (
+	"github.com/google/uuid"
+	"net"
+	"testing"
+	"time"
+)
+
+// A table, and two remote clients that share one address, as if both ran on the same machine
+type tableTest struct {
+	t       *testing.T
+	table   *ConnectionTable
+	local   net.HardwareAddr
+	shared  net.HardwareAddr
+	remotes [2]*Connection
+}
+
+func newTableTest(t *testing.T) *tableTest {
+	network := NewLoopbackNetwork()
+	clock := NewFakeClock(time.Unix(1000, 0))
+
+	link := network.Attach()
+	test := &tableTest{t: t, table: NewConnectionTable(NewHost(link, clock)), local: link.LocalAddress()}
+
+	// Anything the table sends the remotes ends up queued on this link, where nobody reads it
+	test.shared = network.Attach().LocalAddress()
+	for i := range test.remotes {
+		test.remotes[i] = NewConnection(NewHost(network.Attach(), clock))
+	}
+
+	return test
+}
+
+func (test *tableTest) clientId(remote int) uuid.UUID {
+	return test.remotes[remote].host.clientId
+}
+
+// The only packet the connection has to send, which must be of the given type
+func (test *tableTest) sendOne(conn *Connection, packetType ConnectionPacketType) ConnectionPacket {
+	test.t.Helper()
+
+	packets := conn.GetPackets()
+	if len(packets) != 1 || packets[0].packetType != packetType {
+		test.t.Fatalf("expected a single packet of type %d, got %v", packetType, packets)
+	}
+	return packets[0]
+}
+
+// Has a remote send a message, as the packet that carries it
+func (test *tableTest) data(remote int, message string) ConnectionPacket {
+	test.t.Helper()
+
+	conn := test.remotes[remote]
+	conn.QueuePacket(conn.NewConnectionData([]byte(message)))
+
+	// Anything still owed to the table is acked along with the data
+	packets := conn.GetPackets()
+	if len(packets) != 1 || (packets[0].packetType != CONNECTION_DATA && packets[0].packetType != CONNECTION_DATA_ACK) {
+		test.t.Fatalf("expected a single data packet, got %v", packets)
+	}
+	return packets[0]
+}
+
+// Has a remote answer the table's request, and hands the response to the table
+func (test *tableTest) answer(remote int, conn *Connection) *Connection {
+	test.t.Helper()
+
+	_, _, err := test.remotes[remote].Handle(test.sendOne(conn, CONNECTION_REQUEST), test.local)
+	if err != nil {
+		test.t.Fatalf("remote handling request: %s", err.Error())
+	}
+
+	found, _, _, err := test.table.Handle(test.sendOne(test.remotes[remote], CONNECTION_RESPONSE), test.shared)
+	if err != nil {
+		test.t.Fatalf("table handling response: %s", err.Error())
+	}
+	return found
+}
+
+func TestOpenRefusesDuplicateConnections(t *testing.T) {
+	test := newTableTest(t)
+
+	first, err := test.table.Open(test.shared, test.clientId(0))
+	if err != nil {
+		t.Fatalf("opening first connection: %s", err.Error())
+	}
+
+	// The same client again, or a client that could be the same one, would only compete with the first
+	for _, client := range []uuid.UUID{test.clientId(0), uuid.Nil} {
+		if _, err := test.table.Open(test.shared, client); err == nil {
+			t.Errorf("opened a second connection to client %s", client)
+		}
+	}
+
+	// Another client behind the same address is a peer of its own
+	second, err := test.table.Open(test.shared, test.clientId(1))
+	if err != nil {
+		t.Fatalf("opening connection to the second client: %s", err.Error())
+	}
+
+	if test.table.Find(test.shared, test.clientId(0)) != first || test.table.Find(test.shared, test.clientId(1)) != second {
+		t.Errorf("each client did not find its own connection")
+	}
+	if test.table.Find(test.local, test.clientId(0)) != nil {
+		t.Errorf("found a connection at an address we never opened one to")
+	}
+}
+
+func TestOpenWithoutClientIdRefusesTheAddress(t *testing.T) {
+	test := newTableTest(t)
+
+	// Without a client ID, nothing tells the clients at this address apart until the handshake finishes
+	conn, err := test.table.Open(test.shared, uuid.Nil)
+	if err != nil {
+		t.Fatalf("opening connection: %s", err.Error())
+	}
+	if _, err := test.table.Open(test.shared, test.clientId(1)); err == nil {
+		t.Errorf("opened a second connection before the first knew who it was talking to")
+	}
+
+	// Whoever answers is who the connection is with, after which other clients are free
+	if found := test.answer(0, conn); found != conn || conn.state != ESTABLISHED {
+		t.Fatalf("the response was not routed to the open request")
+	}
+	if conn.destId != test.clientId(0) {
+		t.Errorf("the connection did not learn the client it was answered by")
+	}
+	if _, err := test.table.Open(test.shared, test.clientId(1)); err != nil {
+		t.Errorf("opening connection to the other client: %s", err.Error())
+	}
+}
+
+func TestLookupRoutesByClient(t *testing.T) {
+	test := newTableTest(t)
+
+	var conns [2]*Connection
+	for i := range conns {
+		var err error
+		conns[i], err = test.table.Open(test.shared, test.clientId(i))
+		if err != nil {
+			t.Fatalf("opening connection %d: %s", i, err.Error())
+		}
+	}
+
+	// Answered the other way round, each response still reaches the connection that asked that client
+	for _, i := range []int{1, 0} {
+		if found := test.answer(i, conns[i]); found != conns[i] {
+			t.Fatalf("response from client %d was handed to the wrong connection", i)
+		}
+		if conns[i].state != ESTABLISHED {
+			t.Fatalf("connection %d did not finish its handshake", i)
+		}
+	}
+
+	// Data from both clients arrives from the same address, and goes to each client's own connection
+	for _, i := range []int{0, 1, 0} {
+		found, _, received, err := test.table.Handle(test.data(i, string(rune('a'+i))), test.shared)
+		if err != nil {
+			t.Fatalf("handling data from client %d: %s", i, err.Error())
+		}
+		if found != conns[i] || len(received) != 1 || string(received[0]) != string(rune('a'+i)) {
+			t.Errorf("data from client %d was handed to the wrong connection", i)
+		}
+	}
+}
+
+func TestClosedConnectionsAreRemoved(t *testing.T) {
+	test := newTableTest(t)
+
+	conn, err := test.table.Open(test.shared, test.clientId(0))
+	if err != nil {
+		t.Fatalf("opening connection: %s", err.Error())
+	}
+	test.answer(0, conn)
+
+	// Once the connection is gone, a new one may be opened with the same client
+	conn.Abort()
+	if test.table.Find(test.shared, test.clientId(0)) != nil {
+		t.Errorf("found a connection that has closed")
+	}
+
+	test.table.Prune()
+	if len(test.table.Connections()) != 0 {
+		t.Errorf("%d connections are left after pruning", len(test.table.Connections()))
+	}
+
+	again, err := test.table.Open(test.shared, test.clientId(0))
+	if err != nil {
+		t.Fatalf("opening connection again: %s", err.Error())
+	}
+	if connections := test.table.Connections(); len(connections) != 1 || connections[0] != again {
+		t.Errorf("the table does not hold just the new connection")
+	}
+
+	// Data left over from the old connection is not mistaken for part of the new one
+	_, _, received, _ := test.table.Handle(test.data(0, "old"), test.shared)
+	if len(received) != 0 || again.state != REQUESTED {
+		t.Errorf("data from the closed connection was passed to the new one")
+	}
+}
@@ -0,0 +1,145 @@
+package networking
+
+import (
+	"testing"
+	"time"
+)
+
+// Loses everything one side sends, as if it had been unplugged
+func dropFrom(peer *testPeer) func(*testPeer, ConnectionPacket) bool {
+	return func(from *testPeer, _ ConnectionPacket) bool {
+		return from == peer
+	}
+}
+
+func TestRetransmitTimeoutBacksOff(t *testing.T) {
+	for _, features := range []ConnectionFeatures{0, FEATURE_SELECTIVE_REPEAT} {
+		pair := newTestPair(t, features, Impairment{})
+		pair.connect()
+		opener, responder := pair.peers[0], pair.peers[1]
+		timeout := opener.conn.rtt.Timeout()
+
+		// The first two sends of the message are lost
+		lost := 0
+		pair.drop = func(from *testPeer, packet ConnectionPacket) bool {
+			if from == opener && packet.packetType == CONNECTION_DATA && lost < 2 {
+				lost++
+				return true
+			}
+			return false
+		}
+
+		pair.sendChat(opener, "hello")
+		pair.runUntil(time.Second*10, "the message to arrive", func() bool {
+			return len(responder.received) == 1
+		})
+
+		sends := opener.sentOfType(CONNECTION_DATA)
+		if len(sends) != 3 {
+			t.Fatalf("features %d: message was sent %d times, expected 3", features, len(sends))
+		}
+
+		// Each resend waits until the timeout has passed, and the timeout doubles after every loss
+		for i, wait := range []time.Duration{timeout, timeout * 2} {
+			gap := sends[i+1].at.Sub(sends[i].at)
+			if gap <= wait || gap > wait+TEST_TICK {
+				t.Errorf("features %d: resend %d came %s after the last send, expected just over %s", features, i+1, gap, wait)
+			}
+		}
+
+		// The ack can't be timed, so the backoff lasts until something sent once is acked
+		pair.run(TEST_TICK * 5)
+		if backoff := opener.conn.rtt.backoff; backoff != 2 {
+			t.Errorf("features %d: backed off %d times once the resent message was acked, expected 2", features, backoff)
+		}
+		pair.sendChat(opener, "again")
+		pair.run(TEST_TICK * 5)
+		if backoff := opener.conn.rtt.backoff; backoff != 0 {
+			t.Errorf("features %d: still backed off %d times once a message sent once was acked", features, backoff)
+		}
+	}
+}
+
+func TestUnansweredRequestTimesOut(t *testing.T) {
+	pair := newTestPair(t, DEFAULT_FEATURES, Impairment{})
+	opener, responder := pair.peers[0], pair.peers[1]
+	pair.drop = dropFrom(responder)
+
+	start := pair.clock.Now()
+	conn, err := opener.table.Open(responder.link.LocalAddress(), responder.host.clientId)
+	if err != nil {
+		t.Fatalf("opening connection: %s", err.Error())
+	}
+
+	pair.runUntil(HANDSHAKE_TIMEOUT+time.Second, "the handshake to time out", func() bool {
+		return opener.heard(PEER_TIMED_OUT)
+	})
+
+	if elapsed := pair.clock.Now().Sub(start); elapsed < HANDSHAKE_TIMEOUT {
+		t.Errorf("gave up on the handshake after %s, expected %s", elapsed, HANDSHAKE_TIMEOUT)
+	}
+	if requests := len(opener.sentOfType(CONNECTION_REQUEST)); requests < 2 {
+		t.Errorf("request was only sent %d times", requests)
+	}
+	if conn.IsActive() || len(opener.table.Connections()) != 0 {
+		t.Errorf("connection is still open after timing out")
+	}
+}
+
+func TestSilentPeerIsLostThenTimesOut(t *testing.T) {
+	pair := newTestPair(t, 0, Impairment{})
+	pair.connect()
+	opener, responder := pair.peers[0], pair.peers[1]
+	pair.drop = dropFrom(responder)
+
+	pair.sendChat(opener, "hello")
+	pair.runUntil(time.Minute, "the peer to be lost", func() bool {
+		return opener.heard(PEER_LOST)
+	})
+
+	// The first send and every resend up to the limit go unacknowledged before we give up
+	if sends := len(opener.sentOfType(CONNECTION_DATA)); sends != MAX_LOSSES+1 {
+		t.Errorf("message was sent %d times before giving up, expected %d", sends, MAX_LOSSES+1)
+	}
+	if opener.heard(PEER_TIMED_OUT) {
+		t.Fatalf("connection timed out without trying to resume it")
+	}
+
+	// The peer never comes back, so the resume gives up once the grace window is over
+	lost := pair.clock.Now()
+	pair.runUntil(RESUME_GRACE+time.Second, "the resume to time out", func() bool {
+		return opener.heard(PEER_TIMED_OUT)
+	})
+
+	if elapsed := pair.clock.Now().Sub(lost); elapsed < RESUME_GRACE {
+		t.Errorf("gave up resuming after %s, expected %s", elapsed, RESUME_GRACE)
+	}
+	if len(opener.sentOfType(CONNECTION_RESUME)) == 0 {
+		t.Errorf("never tried to resume the connection")
+	}
+	if opener.conn.IsActive() {
+		t.Errorf("connection is still open after timing out")
+	}
+}
+
+func TestLostHandshakeAckIsSentAgain(t *testing.T) {
+	for _, features := range []ConnectionFeatures{0, FEATURE_SELECTIVE_REPEAT, DEFAULT_FEATURES} {
+		pair := newTestPair(t, features, Impairment{})
+		opener := pair.peers[0]
+
+		// The ack that finishes the handshake is lost, so the responder has to send its response again
+		lost := false
+		pair.drop = func(from *testPeer, packet ConnectionPacket) bool {
+			if from == opener && packet.packetType == CONNECTION_ACK && !lost {
+				lost = true
+				return true
+			}
+			return false
+		}
+
+		pair.connect()
+		if !lost {
+			t.Errorf("features %d: the handshake finished without an ack", features)
+		}
+	}
+}
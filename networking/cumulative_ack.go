@@ -0,0 +1,84 @@
+package networking
+
+import (
+	"encoding/binary"
+	"fmt"
+	"project-go/logging"
+)
+
+func (c *Connection) CumulativeAcks() bool {
+	return c.features&FEATURE_CUMULATIVE_ACKS != 0
+}
+
+func (c *Connection) NewConnectionDataAck(data ConnectionPacket, ack uint32) ConnectionPacket {
+	// The ack goes in front of the data, 4 bytes
+	payload := make([]byte, 4+len(data.data))
+	binary.BigEndian.PutUint32(payload, ack)
+	copy(payload[4:], data.data)
+
+	packet := data
+	packet.packetType = CONNECTION_DATA_ACK
+	packet.data = payload
+
+	return packet
+}
+
+func (c *Connection) piggybackAcks(packets []ConnectionPacket) []ConnectionPacket {
+	// Only a peer that agreed to cumulative acks knows to look for one on its data
+	if !c.CumulativeAcks() || !c.knowsPeerSequence() {
+		return packets
+	}
+
+	// Every data packet carries how far we've received, so the peer hears it even if a standalone ack is lost
+	piggybacked := false
+	for i := range packets {
+		if packets[i].packetType == CONNECTION_DATA {
+			packets[i] = c.NewConnectionDataAck(packets[i], c.expectedRecvSeq-1)
+			piggybacked = true
+		}
+	}
+
+	// A piggybacked ack can only say what arrived in order, so a separate ack is still needed to describe any gaps
+	if piggybacked && len(c.receiveBuffer) == 0 {
+		c.ackPending = false
+	}
+
+	return packets
+}
+
+func (c *Connection) handleDataAck(packet ConnectionPacket) (ConnectionEvent, ConnectionPacket, error) {
+	if len(packet.data) < 4 {
+		return NO_EVENT, ConnectionPacket{}, fmt.Errorf("data ack too short")
+	}
+
+	// Split off the ack riding along at the front
+	ack := c.NewConnectionAck(binary.BigEndian.Uint32(packet.data))
+
+	data := packet
+	data.packetType = CONNECTION_DATA
+	data.data = packet.data[4:]
+
+	// The ack is often one we've already seen, which is not a problem with the data it came with
+	event, err := c.handleAck(ack)
+	if err != nil {
+		logging.Debugf("ignoring piggybacked ack: %s\n", err.Error())
+	}
+
+	return event, data, nil
+}
+
+func (c *Connection) goBackNCumulativeAck(sequence uint32) error {
+	// Everything up to and including the acked sequence number has arrived
+	acked := 0
+	for acked < len(c.sendWindow) && c.sendWindow[acked].sendCount > 0 && seqLessEqual(c.sendWindow[acked].sequence, sequence) {
+		acked++
+	}
+
+	if acked == 0 {
+		logging.Debugf("ack %d acknowledged nothing new\n", sequence)
+		return fmt.Errorf("received duplicate ack")
+	}
+
+	c.goBackNAckThrough(acked)
+	return nil
+}
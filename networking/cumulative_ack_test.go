@@ -0,0 +1,118 @@
+package networking
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// Every packet of this type the peer has sent since the given point in its log
+func sentSince(peer *testPeer, from int, packetType ConnectionPacketType) []sentPacket {
+	var packets []sentPacket
+	for _, sent := range peer.sent[from:] {
+		if sent.packet.packetType == packetType {
+			packets = append(packets, sent)
+		}
+	}
+	return packets
+}
+
+func TestAcksAreCoalescedPerTick(t *testing.T) {
+	for features, expected := range map[ConnectionFeatures]int{0: 4, FEATURE_CUMULATIVE_ACKS: 1} {
+		pair := newTestPair(t, features, Impairment{})
+		pair.connect()
+		opener, responder := pair.peers[0], pair.peers[1]
+		from := len(responder.sent)
+
+		// All four arrive in the same tick
+		pair.sendChat(opener, chatMessages("message", 4)...)
+		last := opener.conn.sendWindow[3].sequence
+		pair.run(TEST_TICK * 5)
+
+		acks := sentSince(responder, from, CONNECTION_ACK)
+		if len(acks) != expected {
+			t.Fatalf("features %d: sent %d acks, expected %d", features, len(acks), expected)
+		}
+		if acks[len(acks)-1].packet.sequence != last {
+			t.Errorf("features %d: last ack is for %d, expected %d", features, acks[len(acks)-1].packet.sequence, last)
+		}
+		if len(opener.conn.sendWindow) != 0 {
+			t.Errorf("features %d: %d packets are still unacknowledged", features, len(opener.conn.sendWindow))
+		}
+	}
+}
+
+func TestCumulativeAckCoversLostAcks(t *testing.T) {
+	pair := newTestPair(t, FEATURE_CUMULATIVE_ACKS, Impairment{})
+	pair.connect()
+	opener, responder := pair.peers[0], pair.peers[1]
+
+	// The ack of the first two messages is lost
+	lost := false
+	pair.drop = func(from *testPeer, packet ConnectionPacket) bool {
+		if from == responder && packet.packetType == CONNECTION_ACK && !lost {
+			lost = true
+			return true
+		}
+		return false
+	}
+
+	pair.sendChat(opener, "first", "second")
+	pair.run(TEST_TICK * 2)
+	if !lost {
+		t.Fatalf("the first messages were never acked")
+	}
+
+	// The ack of the next two covers the first two as well, well before anything is resent
+	pair.sendChat(opener, "third", "fourth")
+	pair.run(TEST_TICK * 3)
+
+	if len(opener.conn.sendWindow) != 0 {
+		t.Errorf("%d packets are still unacknowledged", len(opener.conn.sendWindow))
+	}
+
+	// Data goes out with an ack on the front once cumulative acks are agreed
+	if sends := len(opener.sentOfType(CONNECTION_DATA_ACK)); sends != 4 {
+		t.Errorf("data was sent %d times, expected no resends", sends)
+	}
+	if !reflect.DeepEqual(responder.received, []string{"first", "second", "third", "fourth"}) {
+		t.Errorf("received %q", responder.received)
+	}
+}
+
+func TestAcksRideOnData(t *testing.T) {
+	pair := newTestPair(t, DEFAULT_FEATURES, Impairment{})
+	pair.connect()
+	opener, responder := pair.peers[0], pair.peers[1]
+	from := len(responder.sent)
+
+	pair.sendChat(opener, "hello")
+	acked := opener.conn.sendWindow[0].sequence
+	pair.step()
+
+	// The responder has a reply ready in the same tick it owes an ack, so the ack goes out on the reply
+	pair.sendChat(responder, "hello yourself")
+	pair.step()
+
+	if acks := sentSince(responder, from, CONNECTION_ACK); len(acks) != 0 {
+		t.Errorf("sent %d standalone acks alongside the reply", len(acks))
+	}
+	replies := sentSince(responder, from, CONNECTION_DATA_ACK)
+	if len(replies) != 1 {
+		t.Fatalf("sent %d replies carrying an ack, expected 1", len(replies))
+	}
+	if ack := binary.BigEndian.Uint32(replies[0].packet.data); ack != acked {
+		t.Errorf("reply carries an ack for %d, expected %d", ack, acked)
+	}
+
+	pair.run(TEST_TICK * 3)
+	if len(opener.conn.sendWindow) != 0 {
+		t.Errorf("the piggybacked ack was not taken")
+	}
+	if !reflect.DeepEqual(opener.received, []string{"hello yourself"}) {
+		t.Errorf("opener received %q", opener.received)
+	}
+	if sends := len(opener.sentOfType(CONNECTION_DATA)) + len(opener.sentOfType(CONNECTION_DATA_ACK)); sends != 1 {
+		t.Errorf("hello was sent %d times, expected once", sends)
+	}
+}
@@ -36,20 +36,20 @@ func (f EthernetFrame) DestinationAddress() net.HardwareAddr {
 	return f.destinationAddress[:]
 }
 
-func PackageFrame(data []byte, dest net.HardwareAddr) ([]byte, error) {
-	frame := EthernetFrame{
+func PackageFrame(frame Frame) ([]byte, error) {
+	ethernetFrame := EthernetFrame{
 		EthernetFrameHeader: EthernetFrameHeader{
 			etherType: ETHER_TYPE,
 		},
-		data: data,
+		data: frame.Payload,
 	}
 
 	// Fill in the source and destination addresses
 	// Need to copy for compatibility with older Go versions
-	copy(frame.sourceAddress[:], mac)
-	copy(frame.destinationAddress[:], dest)
+	copy(ethernetFrame.sourceAddress[:], frame.Source)
+	copy(ethernetFrame.destinationAddress[:], frame.Destination)
 
-	return frame.serialize()
+	return ethernetFrame.serialize()
 }
 
 func (f EthernetFrame) serialize() ([]byte, error) {
@@ -0,0 +1,121 @@
+package networking
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"project-go/logging"
+	"syscall"
+)
+
+// Sends and receives frames directly on an Ethernet interface using raw sockets
+type EthernetLink struct {
+	iface    net.Interface
+	sendFd   int
+	recvFd   int
+	sockaddr syscall.SockaddrLinklayer
+	buf      []byte
+}
+
+func NewEthernetLink() (*EthernetLink, error) {
+	// Get the network interface we will be sending and receiving on
+	iface, err := GetIface()
+	if err != nil {
+		return nil, fmt.Errorf("error getting default interface: %s", err.Error())
+	}
+
+	// Room for the largest frame the interface can carry, anything bigger than the MTU never reaches us anyway
+	link := &EthernetLink{iface: iface, buf: make([]byte, binary.Size(EthernetFrameHeader{})+iface.MTU)}
+
+	// Create a raw socket that can send Ethernet frames raw
+	link.sendFd, err = syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, syscall.ETH_P_ALL)
+	if err != nil {
+		return nil, fmt.Errorf("error creating send socket: %s", err.Error())
+	}
+
+	// Build a sockaddr containing our interface
+	// Linux uses this sockaddr to determine which interface to send on
+	link.sockaddr.Ifindex = iface.Index
+
+	// Create a socket that will receive every raw ethernet frame
+	link.recvFd, err = syscall.Socket(syscall.AF_PACKET, syscall.SOCK_RAW, htons(syscall.ETH_P_ALL))
+	if err != nil {
+		syscall.Close(link.sendFd)
+		return nil, fmt.Errorf("error creating receive socket: %s", err.Error())
+	}
+
+	// Bind to the network interface so we can start receiving frames
+	logging.Log("Binding to interface " + iface.Name)
+	err = syscall.BindToDevice(link.recvFd, iface.Name)
+	if err != nil {
+		link.Close()
+		return nil, fmt.Errorf("error binding to interface: %s", err.Error())
+	}
+
+	return link, nil
+}
+
+func (l *EthernetLink) Close() {
+	syscall.Close(l.sendFd)
+	syscall.Close(l.recvFd)
+}
+
+func (l *EthernetLink) LocalAddress() net.HardwareAddr {
+	return l.iface.HardwareAddr
+}
+
+func (l *EthernetLink) BroadcastAddress() net.HardwareAddr {
+	return GetBroadcastAddress()
+}
+
+func (l *EthernetLink) MTU() int {
+	// Ethernet's MTU already leaves out its own header
+	return l.iface.MTU
+}
+
+func (l *EthernetLink) SendFrame(frame Frame) error {
+	data, err := PackageFrame(frame)
+	if err != nil {
+		return err
+	}
+
+	// Send the data as is, it is already a properly structured Ethernet frame
+	logging.Debugf("sending %x\n", data)
+	return syscall.Sendto(l.sendFd, data, 0, &l.sockaddr)
+}
+
+func (l *EthernetLink) ReceiveFrame() (Frame, error) {
+	// Keep reading until we get a frame meant for our protocol
+	for true {
+		// Receive a frame into buf
+		dataLen, _, err := syscall.Recvfrom(l.recvFd, l.buf, 0)
+		if err != nil {
+			return Frame{}, err
+		}
+
+		// We only have valid data in the first dataLen bytes
+		frame, err := frameDeserialize(l.buf[:dataLen])
+		if err != nil {
+			// If it was malformed at the Ethernet level, it is unsalvageable
+			logging.Debug("malformed ethernet frame: " + err.Error())
+			continue
+		}
+
+		if frame.EtherType() != ETHER_TYPE {
+			// This frame definitely wasn't intended for us
+			continue
+		}
+
+		// Copy the data out, since the buffer is reused for the next frame
+		payload := make([]byte, len(frame.data))
+		copy(payload, frame.data)
+
+		return Frame{
+			Source:      append(net.HardwareAddr{}, frame.SourceAddress()...),
+			Destination: append(net.HardwareAddr{}, frame.DestinationAddress()...),
+			Payload:     payload,
+		}, nil
+	}
+
+	return Frame{}, nil
+}
@@ -0,0 +1,33 @@
+package networking
+
+import (
+	"encoding/binary"
+)
+
+// Optional behaviour that both sides agree on during the handshake
+// Sent as a bit set in the request and response, a peer that sends nothing gets none of them
+type ConnectionFeatures uint32
+
+const (
+	// Out of order packets are buffered instead of dropped, acks describe everything received, and only lost packets are resent
+	FEATURE_SELECTIVE_REPEAT ConnectionFeatures = 1 << iota
+	// An ack covers everything up to its sequence number, acks are sent at most once a tick, and ride along on data when they can
+	FEATURE_CUMULATIVE_ACKS
+)
+
+// What every connection asks for unless told otherwise
+const DEFAULT_FEATURES = FEATURE_SELECTIVE_REPEAT | FEATURE_CUMULATIVE_ACKS
+
+func (f ConnectionFeatures) serialize() []byte {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, uint32(f))
+	return data
+}
+
+func featuresDeserialize(data []byte) ConnectionFeatures {
+	// Peers from before features existed send no data, so they get none
+	if len(data) < 4 {
+		return 0
+	}
+	return ConnectionFeatures(binary.BigEndian.Uint32(data))
+}
@@ -0,0 +1,105 @@
+package networking
+
+import (
+	"project-go/logging"
+	"project-go/util"
+	"time"
+)
+
+// How many packets we let the peer have in flight to us, unless told otherwise
+const DEFAULT_RECEIVE_WINDOW = 16
+
+// The most packets a peer may ever have in flight, no matter what it advertises
+const MAX_WINDOW_SIZE = 64
+
+func (c *Connection) SetReceiveWindow(size int) {
+	size = util.Max(util.Min(size, MAX_WINDOW_SIZE), 0)
+	reopened := c.receiveWindow == 0 && size > 0
+	c.receiveWindow = size
+
+	// A peer stuck behind a closed window only probes now and then, so tell it straight away that it's open
+	if reopened && c.state == ESTABLISHED {
+		c.QueuePacket(c.NewConnectionAck(c.expectedRecvSeq - 1))
+	}
+}
+
+func (c *Connection) ReceiveWindow() int {
+	return c.receiveWindow
+}
+
+// How many packets the peer last told us it can take
+func (c *Connection) PeerWindow() int {
+	return c.peerWindow
+}
+
+func (c *Connection) advertisedWindow() uint16 {
+	// Packets held back behind a gap take up room until the gap fills and they are passed up
+	free := util.Max(c.receiveWindow-len(c.receiveBuffer), 0)
+
+	// Go back N throws away anything out of order, so a bigger window would only mean more to resend after a loss
+	if !c.SelectiveRepeat() {
+		return uint16(util.Min(free, WINDOW_SIZE))
+	}
+	return uint16(free)
+}
+
+func (c *Connection) sendWindowSize() int {
+	// We send no more than the peer can take, or than the network between us seems to cope with
+	size := util.Min(c.peerWindow, c.congestion.Window())
+	return util.Min(size, MAX_WINDOW_SIZE)
+}
+
+func (c *Connection) updatePeerWindow(window uint16) {
+	if int(window) == c.peerWindow {
+		return
+	}
+
+	logging.Debugf("peer window is now %d\n", window)
+	if c.peerWindow == 0 {
+		// The window is open again, so probing is over
+		c.probeDeadline = time.Time{}
+		c.probeInterval = 0
+
+		// Anything sent while it was closed was thrown away, so send it all again without counting it as lost
+		c.windowPos = 0
+		c.lossDeadline = time.Time{}
+		for i := range c.sendWindow {
+			if !c.sendWindow[i].acked {
+				c.sendWindow[i].deadline = time.Time{}
+			}
+		}
+	}
+	c.peerWindow = int(window)
+}
+
+func (c *Connection) getWindowProbe() []ConnectionPacket {
+	// Nothing to send means nothing to probe with
+	if len(c.sendWindow) == 0 {
+		return nil
+	}
+
+	now := c.host.clock.Now()
+	if !c.probeDeadline.IsZero() && now.Before(c.probeDeadline) {
+		return nil
+	}
+
+	// Probe less and less often while the window stays closed, like a retransmission backing off
+	if c.probeInterval == 0 {
+		c.probeInterval = c.rtt.Timeout()
+	} else {
+		c.probeInterval *= 2
+	}
+	if c.probeInterval > MAX_RTO {
+		c.probeInterval = MAX_RTO
+	}
+	c.probeDeadline = now.Add(c.probeInterval)
+
+	// The first packet goes out on its own, the peer acks it with its current window even if it can't take it
+	probe := &c.sendWindow[0]
+	probe.sentAt = now
+	probe.sendCount++
+
+	logging.Debugf("peer window is closed, probing with %d\n", probe.sequence)
+
+	return []ConnectionPacket{*probe}
+}
@@ -0,0 +1,140 @@
+package networking
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAdvertisedWindowLeavesRoomForBufferedPackets(t *testing.T) {
+	conn := NewConnection(NewHost(NewLoopbackNetwork().Attach(), NewFakeClock(time.Unix(1000, 0))))
+	conn.features = FEATURE_SELECTIVE_REPEAT
+	for sequence := uint32(2); sequence < 5; sequence++ {
+		conn.receiveBuffer[sequence] = ConnectionPacket{ConnectionHeader: ConnectionHeader{sequence: sequence}}
+	}
+
+	if window := conn.advertisedWindow(); window != DEFAULT_RECEIVE_WINDOW-3 {
+		t.Errorf("advertised %d with 3 packets buffered, expected %d", window, DEFAULT_RECEIVE_WINDOW-3)
+	}
+
+	// Shrinking the window below what is already buffered closes it, rather than going negative
+	conn.SetReceiveWindow(2)
+	if window := conn.advertisedWindow(); window != 0 {
+		t.Errorf("advertised %d with more buffered than the window, expected 0", window)
+	}
+
+	// Go back N never advertises more than it is worth sending it
+	conn.features = 0
+	conn.receiveBuffer = make(map[uint32]ConnectionPacket)
+	conn.SetReceiveWindow(DEFAULT_RECEIVE_WINDOW)
+	if window := conn.advertisedWindow(); window != uint16(WINDOW_SIZE) {
+		t.Errorf("go back n advertised %d, expected %d", window, WINDOW_SIZE)
+	}
+}
+
+// Shrinks the responder's window and waits for the opener to hear about it, which it does with the next packet
+func setResponderWindow(pair *testPair, size int) {
+	pair.t.Helper()
+	opener, responder := pair.peers[0], pair.peers[1]
+
+	responder.conn.SetReceiveWindow(size)
+	pair.sendChat(responder, "window")
+	pair.runUntil(time.Second, "the opener to hear about the window", func() bool {
+		return opener.conn.PeerWindow() == size
+	})
+}
+
+// Every data packet in the log, whether or not it carried an ack
+func sentData(log []sentPacket) []sentPacket {
+	var packets []sentPacket
+	for _, sent := range log {
+		if sent.packet.packetType == CONNECTION_DATA || sent.packet.packetType == CONNECTION_DATA_ACK {
+			packets = append(packets, sent)
+		}
+	}
+	return packets
+}
+
+func TestSenderKeepsWithinPeerWindow(t *testing.T) {
+	for _, features := range []ConnectionFeatures{0, DEFAULT_FEATURES} {
+		pair := newTestPair(t, features, Impairment{})
+		pair.connect()
+		opener, responder := pair.peers[0], pair.peers[1]
+		setResponderWindow(pair, 2)
+
+		messages := chatMessages("message", 10)
+		pair.sendChat(opener, messages...)
+		deadline := pair.clock.Now().Add(time.Minute)
+		for len(responder.received) < len(messages) {
+			if pair.clock.Now().After(deadline) {
+				t.Fatalf("features %d: only %d messages arrived", features, len(responder.received))
+			}
+
+			pair.step()
+			if inFlight := opener.conn.packetsInFlight(); inFlight > 2 {
+				t.Fatalf("features %d: %d packets in flight to a window of 2", features, inFlight)
+			}
+		}
+
+		if !reflect.DeepEqual(responder.received, messages) {
+			t.Errorf("features %d: received %q", features, responder.received)
+		}
+		if sends := len(sentData(opener.sent)); sends != len(messages) {
+			t.Errorf("features %d: data was sent %d times, a window that is kept to loses nothing", features, sends)
+		}
+	}
+}
+
+func TestClosedWindowIsProbed(t *testing.T) {
+	for _, features := range []ConnectionFeatures{0, DEFAULT_FEATURES} {
+		pair := newTestPair(t, features, Impairment{})
+		pair.connect()
+		opener, responder := pair.peers[0], pair.peers[1]
+		setResponderWindow(pair, 0)
+
+		messages := chatMessages("message", 3)
+		from := len(opener.sent)
+		pair.sendChat(opener, messages...)
+		pair.run(time.Second * 30)
+
+		if len(responder.received) != 0 {
+			t.Fatalf("features %d: received %q through a closed window", features, responder.received)
+		}
+
+		// Only the first packet goes out, on its own, less and less often
+		var probes []sentPacket
+		for _, sent := range sentData(opener.sent[from:]) {
+			if sent.packet.sequence != opener.conn.sendWindow[0].sequence {
+				t.Fatalf("features %d: sent %d into a closed window, not the first packet", features, sent.packet.sequence)
+			}
+			probes = append(probes, sent)
+		}
+		if len(probes) < 4 {
+			t.Fatalf("features %d: only probed %d times", features, len(probes))
+		}
+		for i := 2; i < len(probes); i++ {
+			last, gap := probes[i-1].at.Sub(probes[i-2].at), probes[i].at.Sub(probes[i-1].at)
+			expected := last * 2
+			if expected > MAX_RTO {
+				expected = MAX_RTO
+			}
+			if gap < expected || gap > expected+TEST_TICK {
+				t.Errorf("features %d: probe %d came %s after the last, expected %s", features, i, gap, expected)
+			}
+		}
+
+		// A closed window is not a dead peer
+		if opener.heard(PEER_LOST) || opener.conn.state != ESTABLISHED {
+			t.Fatalf("features %d: gave up on a peer that was answering its probes", features)
+		}
+
+		// Reopening is announced straight away, so the rest follows without waiting for another probe
+		responder.conn.SetReceiveWindow(DEFAULT_RECEIVE_WINDOW)
+		pair.runUntil(TEST_TICK*10, "the messages to arrive", func() bool {
+			return len(responder.received) == len(messages)
+		})
+		if !reflect.DeepEqual(responder.received, messages) {
+			t.Errorf("features %d: received %q", features, responder.received)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package networking
+
+import (
+	"encoding/binary"
+	"project-go/logging"
+	"project-go/util"
+	"time"
+)
+
+// Flags carried by every data packet, saying how it fits into the message it is part of
+type FragmentFlags uint8
+
+const (
+	// More of the message follows in the next packet
+	FRAGMENT_MORE FragmentFlags = 1 << iota
+)
+
+// A sender gives up after MAX_LOSSES timeouts of at most MAX_RTO each, so a gap longer than that means the rest is never coming
+const REASSEMBLY_TIMEOUT = MAX_RTO * (MAX_LOSSES + 1)
+
+// The largest message we will piece back together, anything bigger is thrown away rather than filling our memory
+const MAX_MESSAGE_SIZE = 1 << 20
+
+func (c *Connection) maxFragmentSize() int {
+	// Each frame carries our header, and may have an ack piggybacked on the front of its data, 4 bytes
+	size := c.host.link.MTU() - binary.Size(ConnectionHeader{}) - 4
+	return util.Max(size, 1)
+}
+
+func (c *Connection) queueFragments(packet ConnectionPacket) {
+	id := c.nextFragmentId
+	c.nextFragmentId++
+
+	// Split the data into pieces that each fit in a frame, a small message is just one piece
+	maxSize := c.maxFragmentSize()
+	offset := 0
+	for {
+		size := util.Min(len(packet.data)-offset, maxSize)
+
+		fragment := packet
+		fragment.sequence = c.sentSeq
+		fragment.fragmentId = id
+		fragment.fragmentOffset = uint32(offset)
+		fragment.data = packet.data[offset : offset+size]
+		if offset+size < len(packet.data) {
+			fragment.fragmentFlags |= FRAGMENT_MORE
+		}
+
+		// Every fragment is sent and acked like any other packet
+		c.sendWindow = append(c.sendWindow, fragment)
+		c.sentSeq++
+
+		offset += size
+		if offset >= len(packet.data) {
+			break
+		}
+	}
+
+	if offset > maxSize {
+		logging.Debugf("split message %d of %d bytes into fragments of %d\n", id, len(packet.data), maxSize)
+	}
+}
+
+func (c *Connection) reassemble(received [][]byte, packet ConnectionPacket) [][]byte {
+	more := packet.fragmentFlags&FRAGMENT_MORE != 0
+
+	if packet.fragmentOffset == 0 {
+		// The start of a new message, fragments arrive in order so any message we were building will never finish
+		if c.reassembly != nil {
+			logging.Debugf("abandoning incomplete message %d\n", c.reassemblyId)
+			c.dropReassembly()
+		}
+
+		// A message that fits in one packet is passed straight up
+		if !more {
+			return append(received, packet.data)
+		}
+
+		c.reassembly = append([]byte{}, packet.data...)
+		c.reassemblyId = packet.fragmentId
+	} else if c.reassembly == nil || packet.fragmentId != c.reassemblyId || int(packet.fragmentOffset) != len(c.reassembly) {
+		// We gave up on this message, or never saw its start
+		logging.Debugf("dropping fragment of message %d at offset %d\n", packet.fragmentId, packet.fragmentOffset)
+		return received
+	} else if len(c.reassembly)+len(packet.data) > MAX_MESSAGE_SIZE {
+		logging.Debugf("message %d is too large, dropping it\n", c.reassemblyId)
+		c.dropReassembly()
+		return received
+	} else {
+		c.reassembly = append(c.reassembly, packet.data...)
+	}
+
+	if more {
+		// Wait for the rest, but not forever
+		c.reassemblyDeadline = c.host.clock.Now().Add(REASSEMBLY_TIMEOUT)
+		return received
+	}
+
+	// That was the last piece, so the whole message can go up
+	message := c.reassembly
+	c.dropReassembly()
+	return append(received, message)
+}
+
+func (c *Connection) expireReassembly() {
+	if c.reassembly != nil && c.host.clock.Now().After(c.reassemblyDeadline) {
+		logging.Debugf("timed out waiting for the rest of message %d\n", c.reassemblyId)
+		c.dropReassembly()
+	}
+}
+
+func (c *Connection) dropReassembly() {
+	c.reassembly = nil
+	c.reassemblyDeadline = time.Time{}
+}
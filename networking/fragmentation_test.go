@@ -0,0 +1,187 @@
+package networking
+
+import (
+	"bytes"
+	"fmt"
+	"project-go/chess"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// A game sync with the given number of moves, each one 24 bytes on the wire
+func longGameSync(moves int) GameSyncPacket {
+	state := chess.CreateState()
+	packet := NewGameSync(&state)
+	packet.Moves = make([]chess.PlayedMove, moves)
+	for i := range packet.Moves {
+		packet.Moves[i] = chess.PlayedMove{Source: chess.Position{X: i % 8, Y: i / 8 % 8}, Dest: chess.Position{X: i / 64 % 8, Y: i % 7}}
+	}
+	return packet
+}
+
+func TestLongMessageArrivesWhole(t *testing.T) {
+	for _, features := range []ConnectionFeatures{0, DEFAULT_FEATURES} {
+		for seed := int64(1); seed <= 3; seed++ {
+			t.Run(fmt.Sprintf("features=%d/seed=%d", features, seed), func(t *testing.T) {
+				pair := newTestPair(t, features, Impairment{Loss: 0.1, Reorder: 0.2, Seed: seed})
+				pair.connect()
+				opener, responder := pair.peers[0], pair.peers[1]
+
+				sync := longGameSync(300)
+				data, err := sync.Serialize()
+				if err != nil {
+					t.Fatalf("serializing game sync: %s", err.Error())
+				}
+				if len(data) < LOOPBACK_MTU*4 {
+					t.Fatalf("message is only %d bytes", len(data))
+				}
+
+				// Small messages either side, so the long one has to come out in its place
+				pair.sendChat(opener, "before")
+				id := opener.conn.nextFragmentId
+				packet, err := PackageChess(sync, opener.conn)
+				if err != nil {
+					t.Fatalf("packaging game sync: %s", err.Error())
+				}
+				opener.conn.QueuePacket(packet)
+				pair.sendChat(opener, "after")
+
+				pair.runUntil(time.Minute, "every message to arrive", func() bool {
+					return len(responder.packets) >= 3
+				})
+				pair.run(time.Second * 2)
+
+				if len(responder.packets) != 3 || !reflect.DeepEqual(responder.received, []string{"before", "after"}) {
+					t.Fatalf("received %d packets, with chat %q", len(responder.packets), responder.received)
+				}
+				arrived, err := responder.packets[1].Serialize()
+				if err != nil {
+					t.Fatalf("serializing what arrived: %s", err.Error())
+				}
+				if !bytes.Equal(arrived, data) {
+					t.Errorf("the message that arrived is not the one that was sent")
+				}
+
+				// Split into as few pieces as fit in a frame, each sent as its own packet
+				size := opener.conn.maxFragmentSize()
+				offsets := make(map[uint32]bool)
+				for _, sent := range sentData(opener.sent) {
+					if sent.packet.fragmentId == id {
+						offsets[sent.packet.fragmentOffset] = true
+					}
+				}
+				if expected := (len(data) + size - 1) / size; len(offsets) != expected {
+					t.Errorf("sent %d fragments, expected %d", len(offsets), expected)
+				}
+			})
+		}
+	}
+}
+
+func TestOversizedMessageIsRefused(t *testing.T) {
+	pair := newTestPair(t, DEFAULT_FEATURES, Impairment{})
+	pair.connect()
+	opener, responder := pair.peers[0], pair.peers[1]
+
+	huge := longGameSync(MAX_MESSAGE_SIZE/24 + 1)
+	if _, err := PackageChess(huge, opener.conn); err == nil {
+		t.Errorf("packaged a message larger than %d bytes", MAX_MESSAGE_SIZE)
+	}
+
+	// A peer that sends one anyway has it thrown away as it arrives, and what follows still gets through
+	data, err := huge.Serialize()
+	if err != nil {
+		t.Fatalf("serializing game sync: %s", err.Error())
+	}
+	opener.conn.QueuePacket(opener.conn.NewConnectionData(data))
+	pair.sendChat(opener, "after")
+
+	pair.runUntil(time.Minute, "the next message to arrive", func() bool {
+		return len(responder.received) == 1
+	})
+	if len(responder.packets) != 1 {
+		t.Errorf("received %d packets, expected only the chat", len(responder.packets))
+	}
+	if responder.conn.reassembly != nil {
+		t.Errorf("still holding %d bytes of the oversized message", len(responder.conn.reassembly))
+	}
+}
+
+// One piece of a message, as it comes out of the receive window
+func fragment(id uint32, offset uint32, data string, more bool) ConnectionPacket {
+	packet := ConnectionPacket{ConnectionHeader: ConnectionHeader{packetType: CONNECTION_DATA, fragmentId: id, fragmentOffset: offset}, data: []byte(data)}
+	if more {
+		packet.fragmentFlags = FRAGMENT_MORE
+	}
+	return packet
+}
+
+func TestReassemblyOnlyFinishesWholeMessages(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	conn := NewConnection(NewHost(NewLoopbackNetwork().Attach(), clock))
+
+	steps := []struct {
+		name     string
+		packet   ConnectionPacket
+		expected [][]byte
+	}{
+		{"whole message", fragment(1, 0, "whole", false), [][]byte{[]byte("whole")}},
+		{"first piece", fragment(2, 0, "abc", true), nil},
+		{"piece of another message", fragment(3, 3, "xyz", false), nil},
+		{"piece from the wrong place", fragment(2, 4, "xyz", false), nil},
+		{"last piece", fragment(2, 3, "def", false), [][]byte{[]byte("abcdef")}},
+		{"piece with no start", fragment(4, 3, "def", false), nil},
+		{"unfinished message", fragment(5, 0, "abc", true), nil},
+		{"next message abandons it", fragment(6, 0, "new", false), [][]byte{[]byte("new")}},
+		{"rest of the abandoned message", fragment(5, 3, "def", false), nil},
+	}
+
+	for _, step := range steps {
+		if received := conn.reassemble(nil, step.packet); !reflect.DeepEqual(received, step.expected) {
+			t.Errorf("%s: passed up %q, expected %q", step.name, received, step.expected)
+		}
+	}
+}
+
+func TestReassemblyTimesOut(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	conn := NewConnection(NewHost(NewLoopbackNetwork().Attach(), clock))
+
+	conn.reassemble(nil, fragment(1, 0, "abc", true))
+	clock.Advance(REASSEMBLY_TIMEOUT)
+	conn.expireReassembly()
+	if conn.reassembly == nil {
+		t.Fatalf("gave up on the message before the timeout")
+	}
+
+	// A piece arriving in time waits the whole timeout again for the next
+	conn.reassemble(nil, fragment(1, 3, "def", true))
+	clock.Advance(REASSEMBLY_TIMEOUT)
+	conn.expireReassembly()
+	if conn.reassembly == nil {
+		t.Fatalf("gave up on the message before the timeout")
+	}
+
+	clock.Advance(time.Millisecond)
+	conn.expireReassembly()
+	if conn.reassembly != nil {
+		t.Fatalf("still waiting for the message after the timeout")
+	}
+	if received := conn.reassemble(nil, fragment(1, 6, "ghi", false)); received != nil {
+		t.Errorf("passed up %q after giving up on the message", received)
+	}
+}
+
+func TestReassemblyRefusesOversizedMessages(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	conn := NewConnection(NewHost(NewLoopbackNetwork().Attach(), clock))
+
+	conn.reassemble(nil, fragment(1, 0, string(make([]byte, MAX_MESSAGE_SIZE)), true))
+	if received := conn.reassemble(nil, fragment(1, MAX_MESSAGE_SIZE, "x", false)); received != nil {
+		t.Errorf("passed up a message of %d bytes", len(received[0]))
+	}
+	if conn.reassembly != nil {
+		t.Errorf("still holding %d bytes of the oversized message", len(conn.reassembly))
+	}
+}
@@ -0,0 +1,66 @@
+package networking
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"project-go/logging"
+	"time"
+)
+
+// How long a connection can take to open before we give up on it, however many times the request or response is resent
+const HANDSHAKE_TIMEOUT = time.Second * 20
+
+func (c *Connection) handleRepeatRequest(packet ConnectionPacket, source net.HardwareAddr) (bool, error) {
+	if c.state == RESPONDED && packet.sourceMachine == c.destId && packet.sequence == c.expectedRecvSeq-1 {
+		// The peer asked again, so our response must have been lost
+		return true, c.resendResponse()
+	}
+
+	if c.state == RESPONDED && packet.sourceMachine == c.destId {
+		// The peer gave up on the handshake we were part way through, and started a new one
+		logging.Debugf("peer started a new handshake, dropping the old one\n")
+		c.reset()
+		return false, nil
+	}
+
+	if c.state == REQUESTED && !c.resuming && bytes.Equal(source, c.peer) {
+		// Both sides opened at once, whoever has the lower client ID keeps its request and the other answers it
+		if bytes.Compare(c.host.clientId[:], packet.sourceMachine[:]) < 0 {
+			return true, fmt.Errorf("peer opened at the same time as us, our request goes ahead")
+		}
+
+		logging.Debugf("peer opened at the same time as us, answering its request instead\n")
+		c.reset()
+		return false, nil
+	}
+
+	return false, nil
+}
+
+func (c *Connection) resendResponse() error {
+	// The response is always first in the window until it is acknowledged
+	if len(c.sendWindow) == 0 || c.sendWindow[0].packetType != CONNECTION_RESPONSE {
+		return nil
+	}
+
+	// Sent straight away rather than waiting for its timer, and no longer timed since we can't tell which send gets acked
+	response := &c.sendWindow[0]
+	response.sentAt = c.host.clock.Now()
+	response.sendCount++
+	response.window = c.advertisedWindow()
+
+	logging.Debugf("resending our response %d\n", response.sequence)
+	return SendTransport(*response, c)
+}
+
+func (c *Connection) checkHandshakeTimeout() bool {
+	// Resuming has its own, longer, grace window
+	if c.handshakeDeadline.IsZero() || c.resuming || !c.host.clock.Now().After(c.handshakeDeadline) {
+		return false
+	}
+
+	logging.Debugf("handshake never finished, giving up\n")
+	c.Abort()
+	return true
+}
@@ -0,0 +1,51 @@
+package networking
+
+import (
+	"github.com/google/uuid"
+)
+
+// A host is one machine on the network, everything its connections share lives here
+type Host struct {
+	link          Link                // The link all of our frames travel over
+	clock         Clock               // Where our connections get the time from, so they can be tested without waiting
+	clientId      uuid.UUID           // MAC Address is not granular enough, since two clients may run on the same network interface
+	lastTimestamp map[uuid.UUID]int64 // Map of the last broadcast packet received from each client
+	lastBroadcast int64               // Timestamp of our own last broadcast, so two sent in the same millisecond still differ
+	corruptFrames int                 // How many frames we have dropped because their checksum didn't match
+}
+
+func NewHost(link Link, clock Clock) *Host {
+	return &Host{
+		link:          link,
+		clock:         clock,
+		clientId:      uuid.New(),
+		lastTimestamp: make(map[uuid.UUID]int64),
+	}
+}
+
+func (h *Host) Link() Link {
+	return h.link
+}
+
+func (h *Host) ClientId() uuid.UUID {
+	return h.clientId
+}
+
+func (h *Host) Clock() Clock {
+	return h.clock
+}
+
+// Receivers drop a broadcast with the same timestamp as the last one from us, so every broadcast needs a new one
+func (h *Host) nextBroadcastTimestamp() int64 {
+	timestamp := h.clock.Now().UnixMilli()
+	if timestamp <= h.lastBroadcast {
+		timestamp = h.lastBroadcast + 1
+	}
+	h.lastBroadcast = timestamp
+	return timestamp
+}
+
+// How many frames arrived damaged and were dropped, for diagnostics
+func (h *Host) CorruptFrames() int {
+	return h.corruptFrames
+}
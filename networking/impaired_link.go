@@ -0,0 +1,213 @@
+package networking
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Everything that can go wrong with a frame on its way out, all probabilities are from 0 to 1
+type Impairment struct {
+	Loss        float64       // Chance any single frame is dropped
+	BurstStart  float64       // Chance of a burst of losses starting, where every frame is dropped until it ends
+	BurstEnd    float64       // Chance of a burst ending on each frame, so bursts last 1/BurstEnd frames on average
+	Duplicate   float64       // Chance a frame is sent twice
+	Corrupt     float64       // Chance a single bit of a frame is flipped on the way
+	Reorder     float64       // Chance a frame is held back so that later frames overtake it
+	ReorderHold time.Duration // How long a reordered frame is held back for
+	Delay       time.Duration // Fixed delay added to every frame
+	Jitter      time.Duration // Random extra delay of up to this much, which also reorders frames
+	Seed        int64         // Seed for every random decision, the same seed gives the same decisions
+}
+
+// Wraps any other link, making its outgoing frames suffer the given impairment
+// Used to see how the reliable transport copes with a bad network, without needing a bad network
+type ImpairedLink struct {
+	link       Link
+	impairment Impairment
+	clock      Clock
+	mutex      sync.Mutex
+	random     *rand.Rand
+	inBurst    bool
+}
+
+// How long a reordered frame is held back for if no hold was given
+const DEFAULT_REORDER_HOLD = time.Millisecond * 20
+
+func NewImpairedLink(link Link, impairment Impairment, clock Clock) *ImpairedLink {
+	if impairment.ReorderHold == 0 {
+		impairment.ReorderHold = DEFAULT_REORDER_HOLD
+	}
+
+	return &ImpairedLink{
+		link:       link,
+		impairment: impairment,
+		clock:      clock,
+		random:     rand.New(rand.NewSource(impairment.Seed)),
+	}
+}
+
+// Reads an impairment from a list like "loss=0.1,dup=0.05,delay=20ms", for the command line
+func ParseImpairment(spec string) (Impairment, error) {
+	impairment := Impairment{Seed: time.Now().UnixNano()}
+
+	for _, option := range strings.Split(spec, ",") {
+		if option == "" {
+			continue
+		}
+
+		name, value, found := strings.Cut(option, "=")
+		if !found {
+			return Impairment{}, fmt.Errorf("impairment option %s has no value", option)
+		}
+
+		var err error
+		switch name {
+		case "loss":
+			impairment.Loss, err = parseProbability(value)
+		case "burst":
+			impairment.BurstStart, err = parseProbability(value)
+		case "burstend":
+			impairment.BurstEnd, err = parseProbability(value)
+		case "dup":
+			impairment.Duplicate, err = parseProbability(value)
+		case "corrupt":
+			impairment.Corrupt, err = parseProbability(value)
+		case "reorder":
+			impairment.Reorder, err = parseProbability(value)
+		case "hold":
+			impairment.ReorderHold, err = time.ParseDuration(value)
+		case "delay":
+			impairment.Delay, err = time.ParseDuration(value)
+		case "jitter":
+			impairment.Jitter, err = time.ParseDuration(value)
+		case "seed":
+			impairment.Seed, err = strconv.ParseInt(value, 10, 64)
+		default:
+			return Impairment{}, fmt.Errorf("unknown impairment option %s", name)
+		}
+
+		if err != nil {
+			return Impairment{}, fmt.Errorf("invalid value for impairment option %s: %s", name, err.Error())
+		}
+	}
+
+	// A burst that never ends would drop everything forever, so default to bursts of about 4 frames
+	if impairment.BurstStart > 0 && impairment.BurstEnd == 0 {
+		impairment.BurstEnd = 0.25
+	}
+
+	return impairment, nil
+}
+
+func (l *ImpairedLink) Close() {
+	// Anything still in flight is lost, just like pulling out the cable
+	l.link.Close()
+}
+
+func (l *ImpairedLink) LocalAddress() net.HardwareAddr {
+	return l.link.LocalAddress()
+}
+
+func (l *ImpairedLink) BroadcastAddress() net.HardwareAddr {
+	return l.link.BroadcastAddress()
+}
+
+func (l *ImpairedLink) MTU() int {
+	return l.link.MTU()
+}
+
+func (l *ImpairedLink) SendFrame(frame Frame) error {
+	// Every decision is made up front under the lock, so the same seed always gives the same fate per frame
+	delays, corruptBit := l.decide(len(frame.Payload))
+
+	for _, delay := range delays {
+		// The caller may reuse the payload once we return, so each copy in flight gets its own
+		payload := make([]byte, len(frame.Payload))
+		copy(payload, frame.Payload)
+		if corruptBit >= 0 {
+			payload[corruptBit/8] ^= 1 << (corruptBit % 8)
+		}
+		copied := Frame{Source: frame.Source, Destination: frame.Destination, Payload: payload}
+
+		if delay == 0 {
+			err := l.link.SendFrame(copied)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		l.clock.AfterFunc(delay, func() {
+			// Nobody is waiting on a delayed frame, so a failure just means it was lost
+			_ = l.link.SendFrame(copied)
+		})
+	}
+
+	return nil
+}
+
+func (l *ImpairedLink) ReceiveFrame() (Frame, error) {
+	return l.link.ReceiveFrame()
+}
+
+// Returns how long each copy of the frame is delayed, none if it is lost, and which bit to flip or -1 to leave it alone
+func (l *ImpairedLink) decide(size int) ([]time.Duration, int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// Move between the good and bad states of the burst model
+	if l.inBurst {
+		if l.random.Float64() < l.impairment.BurstEnd {
+			l.inBurst = false
+		}
+	} else if l.random.Float64() < l.impairment.BurstStart {
+		l.inBurst = true
+	}
+
+	// Always draw the loss roll so that turning on bursts does not change every later decision
+	lost := l.random.Float64() < l.impairment.Loss
+	if lost || l.inBurst {
+		return nil, -1
+	}
+
+	// Only one bit is flipped, which is the hardest damage for a checksum to notice
+	// Nothing is drawn unless corruption is on, so seeds picked before it existed still make the same decisions
+	corruptBit := -1
+	if l.impairment.Corrupt > 0 && size > 0 && l.random.Float64() < l.impairment.Corrupt {
+		corruptBit = l.random.Intn(size * 8)
+	}
+
+	copies := 1
+	if l.random.Float64() < l.impairment.Duplicate {
+		copies = 2
+	}
+
+	delays := make([]time.Duration, copies)
+	for i := range delays {
+		delays[i] = l.impairment.Delay
+		if l.impairment.Jitter > 0 {
+			delays[i] += time.Duration(l.random.Int63n(int64(l.impairment.Jitter)))
+		}
+		if l.random.Float64() < l.impairment.Reorder {
+			delays[i] += l.impairment.ReorderHold
+		}
+	}
+
+	return delays, corruptBit
+}
+
+func parseProbability(value string) (float64, error) {
+	probability, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	if probability < 0 || probability > 1 {
+		return 0, fmt.Errorf("%s is not between 0 and 1", value)
+	}
+	return probability, nil
+}
@@ -0,0 +1,122 @@
+package networking
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func chatMessages(prefix string, count int) []string {
+	messages := make([]string, count)
+	for i := range messages {
+		messages[i] = fmt.Sprintf("%s %d", prefix, i)
+	}
+	return messages
+}
+
+func TestImpairedLinkDeliversEveryMessageOnceInOrder(t *testing.T) {
+	impairments := []struct {
+		name       string
+		impairment Impairment
+	}{
+		{"loss", Impairment{Loss: 0.1}},
+		// Bursts are kept short, one long enough to drop the connection resumes it and may lose what was in flight
+		{"burst", Impairment{BurstStart: 0.05, BurstEnd: 0.75}},
+		{"duplicate", Impairment{Duplicate: 0.3}},
+		{"reorder", Impairment{Reorder: 0.3}},
+		{"jitter", Impairment{Jitter: time.Millisecond * 30}},
+		{"everything", Impairment{Loss: 0.05, Duplicate: 0.1, Reorder: 0.1, Jitter: time.Millisecond * 10}},
+	}
+	features := []ConnectionFeatures{0, FEATURE_CUMULATIVE_ACKS, FEATURE_SELECTIVE_REPEAT, DEFAULT_FEATURES}
+
+	for _, impaired := range impairments {
+		for _, feature := range features {
+			for seed := int64(1); seed <= 3; seed++ {
+				impairment := impaired.impairment
+				impairment.Seed = seed
+
+				t.Run(fmt.Sprintf("%s/features=%d/seed=%d", impaired.name, feature, seed), func(t *testing.T) {
+					pair := newTestPair(t, feature, impairment)
+					pair.connect()
+					opener, responder := pair.peers[0], pair.peers[1]
+
+					// Both directions at once, so data and acks share the impaired link
+					toResponder := chatMessages("to responder", 40)
+					toOpener := chatMessages("to opener", 10)
+					pair.sendChat(opener, toResponder...)
+					pair.sendChat(responder, toOpener...)
+
+					pair.runUntil(time.Minute, "every message to arrive", func() bool {
+						return len(responder.received) >= len(toResponder) && len(opener.received) >= len(toOpener)
+					})
+
+					// Late duplicates and resends still in flight must not be passed up again
+					pair.run(time.Second * 2)
+
+					if !reflect.DeepEqual(responder.received, toResponder) {
+						t.Errorf("responder received %q", responder.received)
+					}
+					if !reflect.DeepEqual(opener.received, toOpener) {
+						t.Errorf("opener received %q", opener.received)
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestImpairmentSeedIsRepeatable(t *testing.T) {
+	// Which frames make it through, in which order, for a given seed
+	arrivals := func(seed int64) []byte {
+		clock := NewFakeClock(time.Unix(1000, 0))
+		network := NewLoopbackNetwork()
+		sender, receiver := network.Attach(), network.Attach()
+		link := NewImpairedLink(sender, Impairment{Loss: 0.2, Duplicate: 0.2, Reorder: 0.2, Seed: seed}, clock)
+
+		var arrived []byte
+		for i := 0; i < 100; i++ {
+			err := link.SendFrame(Frame{Source: sender.LocalAddress(), Destination: receiver.LocalAddress(), Payload: []byte{byte(i)}})
+			if err != nil {
+				t.Fatalf("sending frame: %s", err.Error())
+			}
+			clock.Advance(time.Millisecond * 5)
+
+			for frame, ok := receiver.TryReceiveFrame(); ok; frame, ok = receiver.TryReceiveFrame() {
+				arrived = append(arrived, frame.Payload[0])
+			}
+		}
+		return arrived
+	}
+
+	first := arrivals(7)
+	if !reflect.DeepEqual(first, arrivals(7)) {
+		t.Errorf("the same seed gave different frames")
+	}
+	if reflect.DeepEqual(first, arrivals(8)) {
+		t.Errorf("a different seed gave the same frames")
+	}
+}
+
+func TestCorruptFramesAreDroppedAndResent(t *testing.T) {
+	for _, features := range []ConnectionFeatures{0, DEFAULT_FEATURES} {
+		pair := newTestPair(t, features, Impairment{Corrupt: 0.2, Seed: 1})
+		pair.connect()
+		opener, responder := pair.peers[0], pair.peers[1]
+
+		messages := chatMessages("message", 40)
+		pair.sendChat(opener, messages...)
+		pair.runUntil(time.Minute, "every message to arrive", func() bool {
+			return len(responder.received) >= len(messages)
+		})
+		pair.run(time.Second)
+
+		// Every flipped bit is caught by the checksum, so nothing damaged is ever passed up
+		if !reflect.DeepEqual(responder.received, messages) {
+			t.Errorf("features %d: received %q", features, responder.received)
+		}
+		if opener.host.CorruptFrames()+responder.host.CorruptFrames() == 0 {
+			t.Errorf("features %d: no corrupted frames were caught", features)
+		}
+	}
+}
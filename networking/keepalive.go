@@ -0,0 +1,79 @@
+package networking
+
+import (
+	"project-go/logging"
+	"time"
+)
+
+// How long the peer can be silent before we check it is still there
+const KEEPALIVE_INTERVAL = time.Second * 2
+
+// How long the peer can be silent before we tell the player it has stopped responding, unless told otherwise
+const DEFAULT_DEAD_PEER_TIMEOUT = time.Second * 15
+
+func (c *Connection) NewConnectionKeepalive() ConnectionPacket {
+	packet := ConnectionPacket{
+		ConnectionHeader: ConnectionHeader{
+			sourceMachine: c.host.clientId,
+			destMachine:   c.destId,
+			sequence:      c.sentSeq,
+			packetType:    CONNECTION_KEEPALIVE,
+			window:        c.advertisedWindow(),
+		},
+		data: nil,
+	}
+
+	return packet
+}
+
+func (c *Connection) SetDeadPeerTimeout(timeout time.Duration) {
+	c.deadPeerTimeout = timeout
+}
+
+func (c *Connection) DeadPeerTimeout() time.Duration {
+	return c.deadPeerTimeout
+}
+
+// Whether the peer has been silent for longer than the dead peer timeout
+func (c *Connection) PeerUnresponsive() bool {
+	return c.unresponsive
+}
+
+func (c *Connection) keepaliveDue() bool {
+	if c.state != ESTABLISHED {
+		return false
+	}
+
+	// Anything the peer sends, including its answer to a keepalive, shows it is alive
+	now := c.host.clock.Now()
+	if now.Sub(c.lastHeard) < KEEPALIVE_INTERVAL || now.Before(c.nextKeepalive) {
+		return false
+	}
+
+	c.nextKeepalive = now.Add(KEEPALIVE_INTERVAL)
+	logging.Debugf("peer has been quiet for %s, sending a keepalive\n", now.Sub(c.lastHeard))
+	return true
+}
+
+// Checks whether the peer has gone quiet for too long, or come back after doing so
+// Nothing is closed either way, it's up to the player whether to keep waiting
+func (c *Connection) CheckIdle() ConnectionEvent {
+	if c.state != ESTABLISHED {
+		return NO_EVENT
+	}
+
+	silent := c.host.clock.Now().Sub(c.lastHeard)
+	if !c.unresponsive && silent > c.deadPeerTimeout {
+		logging.Debugf("peer has been silent for %s\n", silent)
+		c.unresponsive = true
+		return PEER_UNRESPONSIVE
+	}
+
+	if c.unresponsive && silent <= c.deadPeerTimeout {
+		logging.Debugf("peer is responding again\n")
+		c.unresponsive = false
+		return PEER_RECOVERED
+	}
+
+	return NO_EVENT
+}
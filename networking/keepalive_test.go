@@ -0,0 +1,83 @@
+package networking
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeepaliveWhileQuiet(t *testing.T) {
+	pair := newTestPair(t, DEFAULT_FEATURES, Impairment{})
+	pair.connect()
+	opener, responder := pair.peers[0], pair.peers[1]
+	connected := opener.conn.lastHeard
+
+	// Nothing but keepalives and their answers go back and forth, and they keep both sides happy
+	pair.run(DEFAULT_DEAD_PEER_TIMEOUT * 2)
+
+	for _, peer := range pair.peers {
+		if peer.heard(PEER_UNRESPONSIVE) {
+			t.Errorf("peer %s was called unresponsive while answering keepalives", peer.link.LocalAddress())
+		}
+	}
+	if len(opener.sentOfType(CONNECTION_KEEPALIVE))+len(responder.sentOfType(CONNECTION_KEEPALIVE)) == 0 {
+		t.Fatalf("no keepalives were sent")
+	}
+
+	// Once the peer stops answering, we keep checking on it but no more often than the interval
+	pair.drop = dropFrom(responder)
+	silenced := pair.clock.Now()
+	pair.run(KEEPALIVE_INTERVAL * 4)
+
+	var keepalives []sentPacket
+	for _, sent := range opener.sentOfType(CONNECTION_KEEPALIVE) {
+		if !sent.at.Before(silenced) {
+			keepalives = append(keepalives, sent)
+		}
+	}
+	if len(keepalives) < 3 {
+		t.Fatalf("only %d keepalives were sent to a silent peer", len(keepalives))
+	}
+	for i := 1; i < len(keepalives); i++ {
+		gap := keepalives[i].at.Sub(keepalives[i-1].at)
+		if gap < KEEPALIVE_INTERVAL || gap > KEEPALIVE_INTERVAL+TEST_TICK {
+			t.Errorf("keepalive %d came %s after the last one, expected %s", i, gap, KEEPALIVE_INTERVAL)
+		}
+	}
+
+	for _, sent := range opener.sentOfType(CONNECTION_KEEPALIVE) {
+		if sent.at.Sub(connected) < KEEPALIVE_INTERVAL {
+			t.Errorf("keepalive sent %s after the handshake finished, before the peer had been quiet for long", sent.at.Sub(connected))
+		}
+	}
+}
+
+func TestSilentPeerIsUnresponsiveUntilHeardFrom(t *testing.T) {
+	pair := newTestPair(t, DEFAULT_FEATURES, Impairment{})
+	pair.connect()
+	opener, responder := pair.peers[0], pair.peers[1]
+
+	pair.drop = dropFrom(responder)
+	silenced := pair.clock.Now()
+	pair.runUntil(DEFAULT_DEAD_PEER_TIMEOUT+time.Second, "the peer to be called unresponsive", func() bool {
+		return opener.heard(PEER_UNRESPONSIVE)
+	})
+
+	if elapsed := pair.clock.Now().Sub(silenced); elapsed < DEFAULT_DEAD_PEER_TIMEOUT {
+		t.Errorf("peer was called unresponsive after %s, expected %s", elapsed, DEFAULT_DEAD_PEER_TIMEOUT)
+	}
+
+	// Nothing was in flight, so the connection stays open for the player to decide what to do
+	if opener.conn.state != ESTABLISHED || !opener.conn.PeerUnresponsive() {
+		t.Fatalf("connection is in state %d, unresponsive %v", opener.conn.state, opener.conn.PeerUnresponsive())
+	}
+
+	// The next keepalive is answered once the peer is back
+	pair.drop = nil
+	pair.runUntil(KEEPALIVE_INTERVAL+time.Second, "the peer to recover", func() bool {
+		return opener.heard(PEER_RECOVERED)
+	})
+
+	if opener.conn.PeerUnresponsive() {
+		t.Errorf("peer is still unresponsive after recovering")
+	}
+}
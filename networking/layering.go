@@ -2,51 +2,58 @@ package networking
 
 import (
 	"fmt"
+	"github.com/google/uuid"
 )
 
-func HandleFrame(data []byte, connection *Connection) (bool, IChessPacket, error) {
-	frame, err := frameDeserialize(data)
-	if err != nil {
-		// Ignore the packet. If it was malformed at the Ethernet level, it is unsalvageable
-		return false, nil, fmt.Errorf("malformed ethernet frame: " + err.Error())
-	}
-
-	if frame.EtherType() != ETHER_TYPE {
-		// This packet definitely wasn't intended for us
-		return false, nil, fmt.Errorf("incorrect ethertype")
+// Also returns which connection the frame belonged to, or nil for broadcasts and frames that matched no connection
+func HandleFrame(frame Frame, host *Host, connections *ConnectionTable) (*Connection, ConnectionEvent, []IChessPacket, error) {
+	// Anything malformed at the link level has already been dropped by the link
+	transport, err := ParseTransport(frame, host.link)
+	if err == errBadChecksum {
+		// Damaged on the way, the sender will resend it if it matters
+		host.corruptFrames++
+		return nil, NO_EVENT, nil, fmt.Errorf("dropped corrupted frame, %d so far", host.corruptFrames)
 	}
-
-	transport, err := ParseTransport(frame.data, frame.DestinationAddress())
 	if err != nil {
 		// Ignore the packet, was malformed
-		return false, nil, fmt.Errorf("malformed transport: " + err.Error())
+		return nil, NO_EVENT, nil, fmt.Errorf("malformed transport: " + err.Error())
 	}
 
-	// Check how to handle this packet
-	var remainingData []byte
-	newConnection := false
+	// Check how to handle this packet, one frame can free up several packets that arrived early
+	var connection *Connection = nil
+	var remainingData [][]byte
+	var client uuid.UUID
+	event := NO_EVENT
 	switch casted := transport.(type) {
 	case ConnectionPacket:
-		newConnection, remainingData, err = connection.Handle(casted, frame.SourceAddress())
+		client = casted.sourceMachine
+		connection, event, remainingData, err = connections.Handle(casted, frame.Source)
 		break
 	case BroadcastPacket:
-		remainingData, err = casted.handle()
+		client = casted.clientId
+		var data []byte
+		data, err = casted.handle(host)
+		if data != nil {
+			remainingData = append(remainingData, data)
+		}
 		break
 	}
 
 	if err != nil {
-		return newConnection, nil, err
+		return connection, event, nil, err
 	}
 
-	// If there is no additional data to process, we cannot process it
-	if remainingData == nil {
-		return newConnection, nil, nil
+	// Process all of the additional data as chess packets, in the order they were sent
+	var chessPackets []IChessPacket
+	for _, data := range remainingData {
+		chessPacket, err := ChessParse(data, frame.Source, client)
+		if err != nil {
+			return connection, event, chessPackets, err
+		}
+		chessPackets = append(chessPackets, chessPacket)
 	}
 
-	// Process the additional data as a chess packet
-	chessPacket, err := ChessParse(remainingData, frame.SourceAddress())
-
-	return newConnection, chessPacket, err
+	return connection, event, chessPackets, nil
 }
 
 func PackageChess(packet IChessPacket, connection *Connection) (ConnectionPacket, error) {
@@ -56,36 +63,60 @@ func PackageChess(packet IChessPacket, connection *Connection) (ConnectionPacket
 		return ConnectionPacket{}, err
 	}
 
+	// The peer would only throw it away once it had all arrived
+	if len(chessData) > MAX_MESSAGE_SIZE {
+		return ConnectionPacket{}, fmt.Errorf("message of %d bytes is larger than %d", len(chessData), MAX_MESSAGE_SIZE)
+	}
+
+	// Once we've asked to close, nothing more may follow the close
+	if connection.state == CLOSING {
+		return ConnectionPacket{}, fmt.Errorf("connection is closing")
+	}
+
 	// Get a Connection packet containing our chess data
 	transportPacket := connection.NewConnectionData(chessData)
 	return transportPacket, nil
 }
 
-func PackageChessBroadcast(packet IChessPacket) ([]byte, error) {
+func PackageChessBroadcast(packet IChessPacket, host *Host) (Frame, error) {
 	// Serialize the chess packet data
 	chessData, err := packet.Serialize()
 	if err != nil {
-		return nil, err
+		return Frame{}, err
 	}
 
 	// Make and serialize a new broadcast packet containing our chess data
-	broadcastPacket := NewBroadcastPacket(chessData)
+	broadcastPacket := NewBroadcastPacket(host, chessData)
 	broadcastData, err := broadcastPacket.serialize()
 	if err != nil {
-		return nil, err
+		return Frame{}, err
+	}
+
+	// Broadcasts are never split up, since nothing makes sure every piece arrives
+	if len(broadcastData) > host.link.MTU() {
+		return Frame{}, fmt.Errorf("broadcast of %d bytes is too large to send", len(broadcastData))
 	}
 
-	// Make and serialize an Ethernet frame with the broadcast address as the destination
-	return PackageFrame(broadcastData, GetBroadcastAddress())
+	// Make a frame with the broadcast address as the destination
+	return Frame{Source: host.link.LocalAddress(), Destination: host.link.BroadcastAddress(), Payload: broadcastData}, nil
 }
 
-func PackageTransport(packet ITransportPacket, connection *Connection) ([]byte, error) {
+func PackageTransport(packet ITransportPacket, connection *Connection) (Frame, error) {
 	// Serialize the transport packet data
 	transportData, err := packet.serialize()
 	if err != nil {
-		return nil, err
+		return Frame{}, err
+	}
+
+	// Make a frame with the destination being our connection peer
+	return Frame{Source: connection.host.link.LocalAddress(), Destination: connection.peer, Payload: transportData}, nil
+}
+
+func SendTransport(packet ITransportPacket, connection *Connection) error {
+	frame, err := PackageTransport(packet, connection)
+	if err != nil {
+		return err
 	}
 
-	// Make and serialize an Ethernet frame with the destination being our connection peer
-	return PackageFrame(transportData, connection.peer)
+	return connection.host.link.SendFrame(frame)
 }
@@ -0,0 +1,38 @@
+package networking
+
+import (
+	"net"
+	"project-go/logging"
+)
+
+// A link moves frames between machines, eg. raw Ethernet
+// Everything above the link only deals in frames and addresses, so the link can be swapped out
+type Link interface {
+	SendFrame(frame Frame) error
+	ReceiveFrame() (Frame, error)
+	LocalAddress() net.HardwareAddr
+	BroadcastAddress() net.HardwareAddr
+	MTU() int // The largest payload a single frame can carry
+	Close()
+}
+
+// A frame as seen by the layers above the link, without any link specific headers
+type Frame struct {
+	Source      net.HardwareAddr
+	Destination net.HardwareAddr
+	Payload     []byte
+}
+
+func RecvThread(link Link, result chan<- Frame) {
+	// Read all frames in a loop, forever until the program is terminated
+	for true {
+		frame, err := link.ReceiveFrame()
+		if err != nil {
+			logging.Log("Error receiving frames, exiting: " + err.Error())
+			return
+		}
+
+		// We have received a frame, pass it up
+		result <- frame
+	}
+}
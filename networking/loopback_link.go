@@ -0,0 +1,147 @@
+package networking
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// How many frames each simulated host can have waiting before new ones are dropped, like a full NIC queue
+const LOOPBACK_QUEUE_SIZE = 256
+
+// Loopback frames could be any size, but matching Ethernet means the transport is exercised the same way
+const LOOPBACK_MTU = DEFAULT_MTU
+
+// A virtual Ethernet segment that lives entirely in memory
+// Every link attached to it shares one broadcast domain, so whole clients can be run against each other in one process
+type LoopbackNetwork struct {
+	mutex       sync.Mutex
+	links       []*LoopbackLink
+	nextAddress uint32
+}
+
+// One simulated host's connection to a loopback network
+type LoopbackLink struct {
+	network  *LoopbackNetwork
+	local    net.HardwareAddr
+	received chan Frame
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func NewLoopbackNetwork() *LoopbackNetwork {
+	return &LoopbackNetwork{}
+}
+
+func (n *LoopbackNetwork) Attach() *LoopbackLink {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	// Hand out locally administered addresses, 02:00:00 followed by a counter
+	n.nextAddress++
+	local := net.HardwareAddr{0x02, 0x00, 0x00, byte(n.nextAddress >> 16), byte(n.nextAddress >> 8), byte(n.nextAddress)}
+
+	link := &LoopbackLink{
+		network:  n,
+		local:    local,
+		received: make(chan Frame, LOOPBACK_QUEUE_SIZE),
+		closed:   make(chan struct{}),
+	}
+	n.links = append(n.links, link)
+
+	return link
+}
+
+func (n *LoopbackNetwork) detach(link *LoopbackLink) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	for i := range n.links {
+		if n.links[i] == link {
+			n.links = append(n.links[:i], n.links[i+1:]...)
+			return
+		}
+	}
+}
+
+func (n *LoopbackNetwork) deliver(frame Frame) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	broadcast := bytes.Equal(frame.Destination, GetBroadcastAddress())
+	for _, link := range n.links {
+		// Like a switch, broadcasts go to everyone else and unicast only to the matching address
+		if broadcast && bytes.Equal(link.local, frame.Source) {
+			continue
+		}
+		if !broadcast && !bytes.Equal(link.local, frame.Destination) {
+			continue
+		}
+
+		// Each receiver gets its own copy, so nobody can modify a frame someone else is reading
+		payload := make([]byte, len(frame.Payload))
+		copy(payload, frame.Payload)
+		copied := Frame{Source: frame.Source, Destination: frame.Destination, Payload: payload}
+
+		// Never block the sender, a full queue just loses the frame
+		select {
+		case link.received <- copied:
+		default:
+		}
+	}
+}
+
+func (l *LoopbackLink) Close() {
+	l.once.Do(func() {
+		l.network.detach(l)
+		close(l.closed)
+	})
+}
+
+func (l *LoopbackLink) LocalAddress() net.HardwareAddr {
+	return l.local
+}
+
+func (l *LoopbackLink) BroadcastAddress() net.HardwareAddr {
+	return GetBroadcastAddress()
+}
+
+func (l *LoopbackLink) MTU() int {
+	return LOOPBACK_MTU
+}
+
+func (l *LoopbackLink) SendFrame(frame Frame) error {
+	select {
+	case <-l.closed:
+		return fmt.Errorf("link is closed")
+	default:
+	}
+
+	// A real interface refuses anything bigger than its MTU, so we do too
+	if len(frame.Payload) > l.MTU() {
+		return fmt.Errorf("frame of %d bytes is larger than the MTU", len(frame.Payload))
+	}
+
+	l.network.deliver(frame)
+	return nil
+}
+
+// Takes the next waiting frame without blocking, so a test can step every host in turn from one thread
+func (l *LoopbackLink) TryReceiveFrame() (Frame, bool) {
+	select {
+	case frame := <-l.received:
+		return frame, true
+	default:
+		return Frame{}, false
+	}
+}
+
+func (l *LoopbackLink) ReceiveFrame() (Frame, error) {
+	select {
+	case frame := <-l.received:
+		return frame, nil
+	case <-l.closed:
+		return Frame{}, fmt.Errorf("link is closed")
+	}
+}
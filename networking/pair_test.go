@@ -0,0 +1,201 @@
+package networking
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// How far the fake clock moves between ticks, about as often as a client ticks its connections
+const TEST_TICK = time.Millisecond * 10
+
+// One side of a test pair, with everything it has been told by its connections
+type testPeer struct {
+	link     *LoopbackLink
+	host     *Host
+	table    *ConnectionTable
+	conn     *Connection       // The connection with the other side, once there is one
+	received []string          // Every chat message passed up to us, in the order it arrived
+	packets  []IChessPacket    // Every packet passed up to us, chat included
+	events   []ConnectionEvent // Every event our connections reported, in order
+	sent     []sentPacket      // Every packet our connections sent, including any the test dropped
+}
+
+type sentPacket struct {
+	at     time.Time
+	packet ConnectionPacket
+}
+
+// Sits between a host and its link, noting every packet sent and losing the ones the test picks
+// Some packets are sent straight away rather than on a tick, so this is the only place that sees them all
+type testLink struct {
+	Link
+	pair *testPair
+	peer *testPeer
+}
+
+func (l *testLink) SendFrame(frame Frame) error {
+	// Broadcasts are never picked out
+	if bytes.Equal(frame.Destination, l.BroadcastAddress()) {
+		return l.Link.SendFrame(frame)
+	}
+
+	packet, err := connectionDeserialize(frame.Payload)
+	if err != nil {
+		return err
+	}
+
+	l.peer.sent = append(l.peer.sent, sentPacket{at: l.pair.clock.Now(), packet: packet})
+	if l.pair.drop != nil && l.pair.drop(l.peer, packet) {
+		return nil
+	}
+	return l.Link.SendFrame(frame)
+}
+
+// Two hosts on a loopback network, ticked by hand on a fake clock so every run goes exactly the same way
+type testPair struct {
+	t     *testing.T
+	clock *FakeClock
+	peers [2]*testPeer
+	drop  func(from *testPeer, packet ConnectionPacket) bool // Picks packets to lose on top of any impairment, nil keeps them all
+}
+
+func newTestPair(t *testing.T, features ConnectionFeatures, impairment Impairment) *testPair {
+	network := NewLoopbackNetwork()
+	pair := &testPair{t: t, clock: NewFakeClock(time.Unix(1000, 0))}
+
+	for i := range pair.peers {
+		// Each side gets its own seed, so the two directions don't lose the same frames
+		sideImpairment := impairment
+		sideImpairment.Seed += int64(i)
+
+		peer := &testPeer{link: network.Attach()}
+		peer.host = NewHost(&testLink{Link: NewImpairedLink(peer.link, sideImpairment, pair.clock), pair: pair, peer: peer}, pair.clock)
+		peer.table = NewConnectionTable(peer.host)
+		peer.table.offered = features
+
+		pair.peers[i] = peer
+	}
+
+	return pair
+}
+
+// Opens a connection from the first peer to the second, and waits for both sides to finish the handshake
+func (p *testPair) connect() {
+	p.t.Helper()
+
+	conn, err := p.peers[0].table.Open(p.peers[1].link.LocalAddress(), p.peers[1].host.clientId)
+	if err != nil {
+		p.t.Fatalf("opening connection: %s", err.Error())
+	}
+	p.peers[0].conn = conn
+
+	p.runUntil(time.Second*30, "the handshake to finish", func() bool {
+		return p.peers[1].conn != nil && p.peers[0].conn.state == ESTABLISHED && p.peers[1].conn.state == ESTABLISHED
+	})
+}
+
+func (p *testPair) sendChat(peer *testPeer, messages ...string) {
+	p.t.Helper()
+
+	for _, message := range messages {
+		packet, err := PackageChess(NewChat(message), peer.conn)
+		if err != nil {
+			p.t.Fatalf("packaging chat: %s", err.Error())
+		}
+		peer.conn.QueuePacket(packet)
+	}
+}
+
+// Delivers whatever has arrived, ticks every connection the way a client does, then moves the clock on
+func (p *testPair) step() {
+	for _, peer := range p.peers {
+		p.receive(peer)
+	}
+	for _, peer := range p.peers {
+		p.tick(peer)
+	}
+	p.clock.Advance(TEST_TICK)
+}
+
+func (p *testPair) run(duration time.Duration) {
+	for end := p.clock.Now().Add(duration); p.clock.Now().Before(end); {
+		p.step()
+	}
+}
+
+func (p *testPair) runUntil(limit time.Duration, what string, done func() bool) {
+	p.t.Helper()
+
+	for end := p.clock.Now().Add(limit); !done(); p.step() {
+		if !p.clock.Now().Before(end) {
+			p.t.Fatalf("gave up waiting %s for %s", limit, what)
+		}
+	}
+}
+
+func (p *testPair) receive(peer *testPeer) {
+	for {
+		frame, ok := peer.link.TryReceiveFrame()
+		if !ok {
+			return
+		}
+
+		// Errors are only packets the transport chose to ignore, which the tests check for by what arrives
+		conn, event, packets, _ := HandleFrame(frame, peer.host, peer.table)
+		if conn != nil && peer.conn == nil {
+			peer.conn = conn
+		}
+		peer.record(event)
+
+		peer.packets = append(peer.packets, packets...)
+		for _, packet := range packets {
+			if chat, ok := packet.(ChatPacket); ok {
+				peer.received = append(peer.received, chat.Message)
+			}
+		}
+	}
+}
+
+func (p *testPair) tick(peer *testPeer) {
+	for _, conn := range peer.table.Connections() {
+		peer.record(conn.CheckLoss())
+		peer.record(conn.CheckIdle())
+
+		packets := conn.GetPackets()
+		packets = append(packets, conn.GetAckPackets()...)
+		for _, packet := range packets {
+			err := SendTransport(packet, conn)
+			if err != nil {
+				p.t.Fatalf("sending packet: %s", err.Error())
+			}
+		}
+	}
+	peer.table.Prune()
+}
+
+func (peer *testPeer) record(event ConnectionEvent) {
+	if event != NO_EVENT {
+		peer.events = append(peer.events, event)
+	}
+}
+
+// Every packet of this type the peer has sent, in order
+func (peer *testPeer) sentOfType(packetType ConnectionPacketType) []sentPacket {
+	var packets []sentPacket
+	for _, sent := range peer.sent {
+		if sent.packet.packetType == packetType {
+			packets = append(packets, sent)
+		}
+	}
+	return packets
+}
+
+func (peer *testPeer) heard(event ConnectionEvent) bool {
+	for _, heard := range peer.events {
+		if heard == event {
+			return true
+		}
+	}
+	return false
+}
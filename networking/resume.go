@@ -0,0 +1,144 @@
+package networking
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"net"
+	"project-go/logging"
+	"time"
+)
+
+// How long after a connection drops either side can still pick it back up
+const RESUME_GRACE = time.Second * 60
+
+func (c *Connection) NewConnectionResume() ConnectionPacket {
+	packet := ConnectionPacket{
+		ConnectionHeader: ConnectionHeader{
+			sourceMachine: c.host.clientId,
+			destMachine:   c.destId,
+			sequence:      c.sentSeq,
+			packetType:    CONNECTION_RESUME,
+			window:        c.advertisedWindow(),
+		},
+		data: append(c.offered.serialize(), c.sessionId[:]...),
+	}
+
+	return packet
+}
+
+// The session the peer handed out during the handshake, or nil if it is too old to hand one out
+func sessionDeserialize(data []byte) uuid.UUID {
+	// The session comes after the features
+	if len(data) < 4+len(uuid.UUID{}) {
+		return uuid.Nil
+	}
+
+	session, err := uuid.FromBytes(data[4 : 4+len(uuid.UUID{})])
+	if err != nil {
+		return uuid.Nil
+	}
+	return session
+}
+
+// Whether a dropped connection is being picked back up
+func (c *Connection) Resuming() bool {
+	return c.resuming
+}
+
+func (c *Connection) restart() {
+	// Everything about who the peer is survives, the sequence numbers and windows start over
+	peer, destId, sessionId, opened := c.peer, c.destId, c.sessionId, c.opened
+	resuming, resumeDeadline := c.resuming, c.resumeDeadline
+
+	c.reset()
+
+	c.peer, c.destId, c.sessionId, c.opened = peer, destId, sessionId, opened
+	c.resuming, c.resumeDeadline = resuming, resumeDeadline
+}
+
+func (c *Connection) startResuming() {
+	// The grace window runs from when the connection first dropped, however many attempts it takes
+	if !c.resuming {
+		c.resuming = true
+		c.resumeDeadline = c.host.clock.Now().Add(RESUME_GRACE)
+	}
+}
+
+func (c *Connection) suspend() ConnectionEvent {
+	logging.Debugf("lost the connection, trying to resume session %x\n", c.sessionId)
+
+	// Anything still in flight is lost, the layers above resync once the connection is back
+	c.restart()
+	c.startResuming()
+
+	// The resume is resent like a request until the peer answers or the grace window runs out
+	c.QueuePacket(c.NewConnectionResume())
+	c.state = REQUESTED
+
+	return PEER_LOST
+}
+
+func (c *Connection) handleResume(packet ConnectionPacket, source net.HardwareAddr) error {
+	if packet.destMachine != c.host.clientId {
+		return fmt.Errorf("packet not addressed to us")
+	}
+
+	// Only the peer we had the session with can resume it, and only while we haven't moved on
+	session := sessionDeserialize(packet.data)
+	known := c.sessionId != uuid.Nil && session == c.sessionId && packet.sourceMachine == c.destId
+	if !known || c.state == IDLE || c.state == CLOSING {
+		return c.refuseResume(packet, source)
+	}
+
+	// Both sides are trying to resume at once, whoever opened the connection in the first place goes ahead
+	if c.state == REQUESTED && c.opened {
+		return fmt.Errorf("peer is resuming at the same time as us")
+	}
+
+	// We already took the peer back for this resume, so it is either a late copy or our response was lost
+	// Either way the connection carries on, and the response is only sent again if the peer hasn't acked it yet
+	if c.resumeAnswered && packet.sequence == c.answeredResume && (c.state == RESPONDED || c.state == ESTABLISHED) {
+		logging.Debugf("peer sent resume %d again\n", packet.sequence)
+		return c.resendResponse()
+	}
+
+	logging.Debugf("peer is resuming session %x\n", c.sessionId)
+
+	// Drop whatever was left of the old connection and answer it like a request
+	c.restart()
+	c.startResuming()
+	c.resumeAnswered = true
+	c.answeredResume = packet.sequence
+	c.peer = source
+	c.state = RESPONDED
+	c.lastHeard = c.host.clock.Now()
+	c.expectedRecvSeq = packet.sequence + 1
+
+	c.features = c.offered & featuresDeserialize(packet.data)
+	c.updatePeerWindow(packet.window)
+
+	c.QueuePacket(c.NewConnectionResponse(packet.sequence))
+
+	return nil
+}
+
+func (c *Connection) refuseResume(packet ConnectionPacket, source net.HardwareAddr) error {
+	// The peer will keep trying until its grace window runs out, so tell it there is nothing to come back to
+	logging.Debugf("refusing to resume a session we don't have\n")
+	err := c.replyStray(packet, source, CONNECTION_ABORT)
+	if err != nil {
+		return err
+	}
+
+	return fmt.Errorf("no session to resume")
+}
+
+func (c *Connection) checkResumeTimeout() bool {
+	if !c.resuming || !c.host.clock.Now().After(c.resumeDeadline) {
+		return false
+	}
+
+	logging.Debugf("could not resume the connection in time, giving up\n")
+	c.Abort()
+	return true
+}
@@ -0,0 +1,90 @@
+package networking
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLateResumeDoesNotRestartConnection(t *testing.T) {
+	for _, features := range []ConnectionFeatures{0, DEFAULT_FEATURES} {
+		pair := newTestPair(t, features, Impairment{})
+		pair.connect()
+		opener, responder := pair.peers[0], pair.peers[1]
+
+		// The opener loses the connection and picks it back up
+		opener.record(opener.conn.suspend())
+		pair.runUntil(time.Second, "the connection to resume", func() bool {
+			return opener.heard(PEER_RESUMED) && responder.conn.state == ESTABLISHED
+		})
+
+		resumes := opener.sentOfType(CONNECTION_RESUME)
+		if len(resumes) != 1 {
+			t.Fatalf("features %d: sent %d resumes, expected 1", features, len(resumes))
+		}
+
+		messages := chatMessages("message", 6)
+		pair.sendChat(opener, messages[:3]...)
+		pair.step()
+
+		// A copy of the resume turns up late, in the middle of the new traffic
+		data, err := resumes[0].packet.serialize()
+		if err != nil {
+			t.Fatalf("serializing resume: %s", err.Error())
+		}
+		err = opener.link.SendFrame(Frame{Source: opener.link.LocalAddress(), Destination: responder.link.LocalAddress(), Payload: data})
+		if err != nil {
+			t.Fatalf("sending resume: %s", err.Error())
+		}
+
+		pair.sendChat(opener, messages[3:]...)
+		pair.run(time.Second * 2)
+
+		if responder.conn.state != ESTABLISHED || len(responder.sentOfType(CONNECTION_RESPONSE)) != 2 {
+			t.Errorf("features %d: the late resume was answered again", features)
+		}
+		if !reflect.DeepEqual(responder.received, messages) {
+			t.Errorf("features %d: received %q", features, responder.received)
+		}
+		if expected := []ConnectionEvent{PEER_CONNECTED, PEER_LOST, PEER_RESUMED}; !reflect.DeepEqual(opener.events, expected) {
+			t.Errorf("features %d: opener saw events %v, expected %v", features, opener.events, expected)
+		}
+	}
+}
+
+func TestRepeatedResumeIsAnsweredAgain(t *testing.T) {
+	pair := newTestPair(t, DEFAULT_FEATURES, Impairment{})
+	pair.connect()
+	opener, responder := pair.peers[0], pair.peers[1]
+	responses := len(responder.sentOfType(CONNECTION_RESPONSE))
+
+	// The first answer to the resume is lost, so the opener sends the same resume again
+	lost := false
+	pair.drop = func(from *testPeer, packet ConnectionPacket) bool {
+		if from == responder && packet.packetType == CONNECTION_RESPONSE && !lost {
+			lost = true
+			return true
+		}
+		return false
+	}
+
+	opener.record(opener.conn.suspend())
+	pair.runUntil(time.Second*5, "the connection to resume", func() bool {
+		return opener.heard(PEER_RESUMED) && responder.conn.state == ESTABLISHED
+	})
+
+	if resumes := opener.sentOfType(CONNECTION_RESUME); len(resumes) != 2 || resumes[0].packet.sequence != resumes[1].packet.sequence {
+		t.Errorf("expected the same resume to be sent twice")
+	}
+
+	// Every answer is the same response, the responder never started over
+	answers := responder.sentOfType(CONNECTION_RESPONSE)[responses:]
+	if len(answers) < 2 {
+		t.Fatalf("responded to the resume %d times", len(answers))
+	}
+	for _, answer := range answers {
+		if answer.packet.sequence != answers[0].packet.sequence {
+			t.Errorf("answered with response %d and then %d", answers[0].packet.sequence, answer.packet.sequence)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package networking
+
+import (
+	"time"
+)
+
+// Until we have measured anything, wait this long before declaring a packet lost
+const INITIAL_RTO = time.Second
+
+// Never time out faster than this, it leaves room for the peer to process and for our ticks to notice
+const MIN_RTO = time.Millisecond * 100
+
+// Backing off stops here, so a recovered link is noticed in reasonable time
+const MAX_RTO = time.Second * 10
+
+// How quickly the smoothed values follow new samples, as in RFC 6298
+const RTT_ALPHA = 0.125
+const RTT_BETA = 0.25
+
+// How many variances on top of the smoothed round trip time we wait before declaring a loss
+const RTT_VARIANCE_MULTIPLIER = 4
+
+// Keeps the smoothed round trip time and its variance, and the retransmission timeout they give (Jacobson/Karels)
+type RTTEstimator struct {
+	smoothed time.Duration
+	variance time.Duration
+	timeout  time.Duration
+	backoff  int
+	samples  int
+}
+
+func NewRTTEstimator() RTTEstimator {
+	return RTTEstimator{timeout: INITIAL_RTO}
+}
+
+// Takes a new round trip measurement
+// Only packets that were sent exactly once may be measured, otherwise we can't tell which send was acked (Karn's algorithm)
+func (r *RTTEstimator) Sample(rtt time.Duration) {
+	if r.samples == 0 {
+		// The first measurement is all we know
+		r.smoothed = rtt
+		r.variance = rtt / 2
+	} else {
+		// Variance has to be updated first, since it uses the old smoothed value
+		diff := r.smoothed - rtt
+		if diff < 0 {
+			diff = -diff
+		}
+		r.variance = time.Duration((1-RTT_BETA)*float64(r.variance) + RTT_BETA*float64(diff))
+		r.smoothed = time.Duration((1-RTT_ALPHA)*float64(r.smoothed) + RTT_ALPHA*float64(rtt))
+	}
+	r.samples++
+
+	// A fresh measurement means the link is working again, so any backoff is over
+	r.backoff = 0
+	r.timeout = clampRTO(r.smoothed + RTT_VARIANCE_MULTIPLIER*r.variance)
+}
+
+// Doubles the timeout after a loss, so that a slow link is not flooded with retransmissions
+func (r *RTTEstimator) Backoff() {
+	r.backoff++
+	r.timeout = clampRTO(r.timeout * 2)
+}
+
+func (r *RTTEstimator) Timeout() time.Duration {
+	return r.timeout
+}
+
+// The smoothed round trip time to the peer, the retransmission timeout it gives, and how many times that has been doubled
+// The round trip time is zero until something sent only once has been acked
+func (c *Connection) RTT() (smoothed time.Duration, timeout time.Duration, backoff int) {
+	return c.rtt.smoothed, c.rtt.Timeout(), c.rtt.backoff
+}
+
+func clampRTO(rto time.Duration) time.Duration {
+	if rto < MIN_RTO {
+		return MIN_RTO
+	}
+	if rto > MAX_RTO {
+		return MAX_RTO
+	}
+	return rto
+}
@@ -0,0 +1,113 @@
+package networking
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRTTEstimatorSmoothsSamples(t *testing.T) {
+	rtt := NewRTTEstimator()
+	if rtt.Timeout() != INITIAL_RTO {
+		t.Fatalf("timeout before any sample is %s, expected %s", rtt.Timeout(), INITIAL_RTO)
+	}
+
+	// The first sample is taken as it is, with half of it as the variance
+	rtt.Sample(time.Millisecond * 200)
+	if rtt.smoothed != time.Millisecond*200 || rtt.variance != time.Millisecond*100 {
+		t.Errorf("after one sample smoothed is %s and variance %s", rtt.smoothed, rtt.variance)
+	}
+	if rtt.Timeout() != time.Millisecond*600 {
+		t.Errorf("after one sample timeout is %s, expected 600ms", rtt.Timeout())
+	}
+
+	// Later samples only move the estimate part of the way, variance first
+	rtt.Sample(time.Millisecond * 100)
+	if rtt.variance != time.Millisecond*100 {
+		t.Errorf("variance is %s, expected 100ms", rtt.variance)
+	}
+	if rtt.smoothed != time.Microsecond*187500 {
+		t.Errorf("smoothed is %s, expected 187.5ms", rtt.smoothed)
+	}
+	if rtt.Timeout() != time.Microsecond*587500 {
+		t.Errorf("timeout is %s, expected 587.5ms", rtt.Timeout())
+	}
+}
+
+func TestRTTEstimatorClampsTimeout(t *testing.T) {
+	fast := NewRTTEstimator()
+	for i := 0; i < 20; i++ {
+		fast.Sample(time.Millisecond)
+	}
+	if fast.Timeout() != MIN_RTO {
+		t.Errorf("timeout on a fast link is %s, expected %s", fast.Timeout(), MIN_RTO)
+	}
+
+	slow := NewRTTEstimator()
+	slow.Sample(time.Second * 5)
+	if slow.Timeout() != MAX_RTO {
+		t.Errorf("timeout on a slow link is %s, expected %s", slow.Timeout(), MAX_RTO)
+	}
+}
+
+func TestRTTEstimatorBacksOff(t *testing.T) {
+	rtt := NewRTTEstimator()
+	for _, expected := range []time.Duration{time.Second * 2, time.Second * 4, time.Second * 8, MAX_RTO, MAX_RTO} {
+		rtt.Backoff()
+		if rtt.Timeout() != expected {
+			t.Errorf("after %d backoffs timeout is %s, expected %s", rtt.backoff, rtt.Timeout(), expected)
+		}
+	}
+
+	// Only a new sample ends the backoff, and the timeout comes from the measurements again
+	rtt.Sample(time.Millisecond * 200)
+	if rtt.Timeout() != time.Millisecond*600 || rtt.backoff != 0 {
+		t.Errorf("after a sample timeout is %s with %d backoffs", rtt.Timeout(), rtt.backoff)
+	}
+}
+
+func TestResentPacketsAreNotTimed(t *testing.T) {
+	for _, features := range []ConnectionFeatures{0, FEATURE_SELECTIVE_REPEAT} {
+		pair := newTestPair(t, features, Impairment{})
+		pair.connect()
+		opener, responder := pair.peers[0], pair.peers[1]
+
+		// The first send of the message is lost, so the ack could be for either send
+		lost := false
+		pair.drop = func(from *testPeer, packet ConnectionPacket) bool {
+			if from == opener && packet.packetType == CONNECTION_DATA && !lost {
+				lost = true
+				return true
+			}
+			return false
+		}
+
+		samples := opener.conn.rtt.samples
+		backedOff := clampRTO(opener.conn.rtt.Timeout() * 2)
+		pair.sendChat(opener, "resent")
+		pair.runUntil(time.Second*5, "the resent message to be acked", func() bool {
+			return len(responder.received) == 1 && len(opener.conn.sendWindow) == 0
+		})
+
+		if opener.conn.rtt.samples != samples {
+			t.Errorf("features %d: the ack of a resent packet was timed", features)
+		}
+
+		// Nothing was measured, so the timeout stays backed off
+		if _, timeout, backoff := opener.conn.RTT(); backoff != 1 || timeout != backedOff {
+			t.Errorf("features %d: timeout went back to %s once the resent packet was acked, expected %s", features, timeout, backedOff)
+		}
+
+		// A packet that only went out once is timed as usual, and that ends the backoff
+		pair.sendChat(opener, "sent once")
+		pair.runUntil(time.Second*5, "the second message to be acked", func() bool {
+			return len(responder.received) == 2 && len(opener.conn.sendWindow) == 0
+		})
+
+		if opener.conn.rtt.samples != samples+1 {
+			t.Errorf("features %d: took %d samples from a packet sent once", features, opener.conn.rtt.samples-samples)
+		}
+		if smoothed, timeout, backoff := opener.conn.RTT(); backoff != 0 || timeout >= backedOff || smoothed == 0 {
+			t.Errorf("features %d: round trip %s and timeout %s with %d backoffs after a packet sent once was acked", features, smoothed, timeout, backoff)
+		}
+	}
+}
@@ -0,0 +1,249 @@
+package networking
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"project-go/logging"
+	"project-go/util"
+	"sort"
+	"time"
+)
+
+// Acks only have room to describe this many separate runs of out of order packets, the earliest ones are sent first
+const MAX_SACK_BLOCKS = 4
+
+// A run of packets received out of order, from start to end inclusive
+type sackBlock struct {
+	start uint32
+	end   uint32
+}
+
+func (c *Connection) SetSelectiveRepeat(enabled bool) {
+	if enabled {
+		c.offered |= FEATURE_SELECTIVE_REPEAT
+	} else {
+		c.offered &^= FEATURE_SELECTIVE_REPEAT
+	}
+}
+
+func (c *Connection) SelectiveRepeat() bool {
+	return c.features&FEATURE_SELECTIVE_REPEAT != 0
+}
+
+func (c *Connection) NewSelectiveAck() ConnectionPacket {
+	// The sequence number is the last packet received in order, everything up to it is acknowledged
+	packet := c.NewConnectionAck(c.expectedRecvSeq - 1)
+
+	// Anything received past a gap is described in the data
+	buf := bytes.Buffer{}
+	for _, block := range c.sackBlocks() {
+		binary.Write(&buf, binary.BigEndian, block.start)
+		binary.Write(&buf, binary.BigEndian, block.end)
+	}
+	packet.data = buf.Bytes()
+
+	return packet
+}
+
+func (c *Connection) sackBlocks() []sackBlock {
+	sequences := make([]uint32, 0, len(c.receiveBuffer))
+	for sequence := range c.receiveBuffer {
+		sequences = append(sequences, sequence)
+	}
+	sort.Slice(sequences, func(i, j int) bool {
+		return seqLess(sequences[i], sequences[j])
+	})
+
+	// Merge consecutive sequence numbers into runs
+	blocks := make([]sackBlock, 0)
+	for _, sequence := range sequences {
+		if len(blocks) > 0 && blocks[len(blocks)-1].end+1 == sequence {
+			blocks[len(blocks)-1].end = sequence
+			continue
+		}
+		if len(blocks) == MAX_SACK_BLOCKS {
+			break
+		}
+		blocks = append(blocks, sackBlock{start: sequence, end: sequence})
+	}
+
+	return blocks
+}
+
+func sackBlocksDeserialize(data []byte) ([]sackBlock, error) {
+	if len(data)%8 != 0 {
+		return nil, fmt.Errorf("selective ack blocks are the wrong length")
+	}
+
+	reader := bytes.NewReader(data)
+	blocks := make([]sackBlock, len(data)/8)
+	for i := range blocks {
+		// Start of the run, 4 bytes
+		err := binary.Read(reader, binary.BigEndian, &blocks[i].start)
+		if err != nil {
+			return nil, err
+		}
+
+		// End of the run, 4 bytes
+		err = binary.Read(reader, binary.BigEndian, &blocks[i].end)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return blocks, nil
+}
+
+func (c *Connection) inReceiveWindow(sequence uint32) bool {
+	// Go back N only takes the next packet if there's room for it, or an old one that needs acking again
+	if !c.SelectiveRepeat() {
+		return seqLess(sequence, c.expectedRecvSeq) || (sequence == c.expectedRecvSeq && c.receiveWindow > 0)
+	}
+
+	// Selective repeat buffers anything inside the window we advertised
+	return seqLess(sequence, c.expectedRecvSeq+uint32(c.receiveWindow))
+}
+
+func (c *Connection) handleSelectiveData(packet ConnectionPacket) (*ConnectionPacket, [][]byte) {
+	var received [][]byte
+
+	if packet.sequence == c.expectedRecvSeq {
+		// This fills the gap, so pass it up along with everything buffered behind it
+		received = c.reassemble(received, packet)
+		c.expectedRecvSeq++
+
+		for {
+			buffered, ok := c.receiveBuffer[c.expectedRecvSeq]
+			if !ok {
+				break
+			}
+			delete(c.receiveBuffer, c.expectedRecvSeq)
+			received = c.reassemble(received, buffered)
+			c.expectedRecvSeq++
+		}
+	} else if seqLess(c.expectedRecvSeq, packet.sequence) {
+		// Hold on to it until the packets before it arrive
+		logging.Debugf("buffering out of order packet %d, expected %d\n", packet.sequence, c.expectedRecvSeq)
+		c.receiveBuffer[packet.sequence] = packet
+	}
+
+	// Old packets were already passed up, their ack must have been lost so we just ack again
+	response := c.NewSelectiveAck()
+	return &response, received
+}
+
+func (c *Connection) handleSelectiveAck(packet ConnectionPacket) error {
+	blocks, err := sackBlocksDeserialize(packet.data)
+	if err != nil {
+		return err
+	}
+
+	// Mark everything this ack covers, either cumulatively or in one of its blocks
+	var newest *ConnectionPacket = nil
+	var newlyAcked []ConnectionPacket
+	for i := range c.sendWindow {
+		sent := &c.sendWindow[i]
+		if sent.acked || sent.sendCount == 0 || !sackCovers(packet.sequence, blocks, sent.sequence) {
+			continue
+		}
+
+		sent.acked = true
+		newlyAcked = append(newlyAcked, *sent)
+		if newest == nil || sent.sentAt.After(newest.sentAt) {
+			newest = sent
+		}
+	}
+
+	if newest == nil {
+		logging.Debugf("ack %d acknowledged nothing new\n", packet.sequence)
+		return fmt.Errorf("received duplicate ack")
+	}
+
+	// The most recently sent packet gives the most up to date round trip time
+	c.timeAck(*newest, newlyAcked)
+	c.numLosses = 0
+	c.congestion.Acked(len(newlyAcked))
+
+	// Slide the window past everything acknowledged at the start of it
+	for len(c.sendWindow) > 0 && c.sendWindow[0].acked {
+		c.sendWindow = c.sendWindow[1:]
+	}
+
+	return nil
+}
+
+func sackCovers(cumulative uint32, blocks []sackBlock, sequence uint32) bool {
+	if seqLessEqual(sequence, cumulative) {
+		return true
+	}
+
+	for _, block := range blocks {
+		if seqLessEqual(block.start, sequence) && seqLessEqual(sequence, block.end) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Connection) getSelectivePackets() []ConnectionPacket {
+	now := c.host.clock.Now()
+	end := util.Min(c.sendWindowSize(), len(c.sendWindow))
+
+	// Send anything in the window that has never been sent, or whose timer ran out
+	var packets []ConnectionPacket
+	for i := 0; i < end; i++ {
+		sent := &c.sendWindow[i]
+		if sent.acked || !sent.deadline.IsZero() {
+			continue
+		}
+
+		sent.sentAt = now
+		sent.sendCount++
+		sent.deadline = now.Add(c.rtt.Timeout())
+		packets = append(packets, *sent)
+	}
+
+	if len(packets) > 0 {
+		logging.Debugf("sending %d frames from %d total queue %d\n", len(packets), packets[0].sequence, len(c.sendWindow))
+	}
+
+	return packets
+}
+
+func (c *Connection) checkSelectiveLoss() ConnectionEvent {
+	now := c.host.clock.Now()
+	end := util.Min(c.sendWindowSize(), len(c.sendWindow))
+
+	// Every packet has its own timer, only the ones that ran out are resent
+	lost := false
+	for i := 0; i < end; i++ {
+		sent := &c.sendWindow[i]
+		if sent.acked || sent.deadline.IsZero() || !now.After(sent.deadline) {
+			continue
+		}
+
+		logging.Debugf("packet %d was lost\n", sent.sequence)
+		sent.deadline = time.Time{}
+		lost = true
+	}
+
+	if !lost {
+		return NO_EVENT
+	}
+
+	// Losses in the same check count once, just like go back n losing a whole window
+	c.congestion.Lost(c.packetsInFlight())
+	c.numLosses++
+	c.rtt.Backoff()
+
+	logging.Debugf("we had a loss, timeout is now %s\n", c.rtt.Timeout())
+
+	// Drop the connection if we continue to get losses
+	if c.numLosses > MAX_LOSSES {
+		return c.giveUp()
+	}
+
+	return NO_EVENT
+}
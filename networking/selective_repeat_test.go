@@ -0,0 +1,94 @@
+package networking
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSackBlocksDescribeTheGaps(t *testing.T) {
+	conn := NewConnection(NewHost(NewLoopbackNetwork().Attach(), NewFakeClock(time.Unix(1000, 0))))
+
+	// Start just short of wrapping around, so the runs cross it
+	conn.expectedRecvSeq = 0xfffffffe
+	for _, offset := range []uint32{1, 2, 3, 5, 7, 8, 10, 12} {
+		sequence := conn.expectedRecvSeq + offset
+		conn.receiveBuffer[sequence] = ConnectionPacket{ConnectionHeader: ConnectionHeader{sequence: sequence}}
+	}
+
+	// Consecutive packets are merged into one run, and only the earliest runs fit in the ack
+	expected := []sackBlock{{0xffffffff, 1}, {3, 3}, {5, 6}, {8, 8}}
+	if blocks := conn.sackBlocks(); !reflect.DeepEqual(blocks, expected) {
+		t.Fatalf("got blocks %v, expected %v", blocks, expected)
+	}
+
+	ack := conn.NewSelectiveAck()
+	if ack.sequence != 0xfffffffd {
+		t.Errorf("ack is for %d, expected the last packet received in order", ack.sequence)
+	}
+	blocks, err := sackBlocksDeserialize(ack.data)
+	if err != nil || !reflect.DeepEqual(blocks, expected) {
+		t.Errorf("blocks read back as %v, %v", blocks, err)
+	}
+
+	if _, err := sackBlocksDeserialize(make([]byte, 7)); err == nil {
+		t.Errorf("blocks of the wrong length were accepted")
+	}
+}
+
+func TestSackCovers(t *testing.T) {
+	blocks := []sackBlock{{12, 14}, {20, 20}}
+	for sequence, covered := range map[uint32]bool{9: true, 10: true, 11: false, 12: true, 14: true, 15: false, 20: true, 21: false} {
+		if sackCovers(10, blocks, sequence) != covered {
+			t.Errorf("sequence %d covered is %v, expected %v", sequence, !covered, covered)
+		}
+	}
+}
+
+func TestSelectiveRepeatOnlyResendsWhatWasLost(t *testing.T) {
+	pair := newTestPair(t, FEATURE_SELECTIVE_REPEAT, Impairment{})
+	pair.connect()
+	opener, responder := pair.peers[0], pair.peers[1]
+
+	messages := chatMessages("message", 4)
+	pair.sendChat(opener, messages...)
+
+	// The second message is lost the first time it is sent
+	second := opener.conn.sendWindow[1].sequence
+	lost := false
+	pair.drop = func(from *testPeer, packet ConnectionPacket) bool {
+		if from == opener && packet.packetType == CONNECTION_DATA && packet.sequence == second && !lost {
+			lost = true
+			return true
+		}
+		return false
+	}
+
+	pair.run(TEST_TICK * 5)
+
+	// Everything after the gap is held back until it fills, and the sender hears about it
+	if !reflect.DeepEqual(responder.received, messages[:1]) {
+		t.Fatalf("received %q before the gap was filled", responder.received)
+	}
+	if len(responder.conn.receiveBuffer) != 2 {
+		t.Errorf("%d packets are buffered, expected 2", len(responder.conn.receiveBuffer))
+	}
+	if len(opener.conn.sendWindow) != 3 || opener.conn.sendWindow[0].acked || !opener.conn.sendWindow[1].acked || !opener.conn.sendWindow[2].acked {
+		t.Errorf("only the lost packet should be left unacknowledged")
+	}
+
+	pair.runUntil(time.Second*5, "the gap to be filled", func() bool {
+		return len(responder.received) == len(messages)
+	})
+	pair.run(time.Second)
+
+	if !reflect.DeepEqual(responder.received, messages) {
+		t.Errorf("received %q", responder.received)
+	}
+	if len(responder.conn.receiveBuffer) != 0 {
+		t.Errorf("%d packets are still buffered", len(responder.conn.receiveBuffer))
+	}
+	if sends := len(opener.sentOfType(CONNECTION_DATA)); sends != len(messages)+1 {
+		t.Errorf("data was sent %d times, expected only the lost packet to be resent", sends)
+	}
+}
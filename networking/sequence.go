@@ -0,0 +1,38 @@
+package networking
+
+import (
+	"encoding/binary"
+	"github.com/google/uuid"
+	"math/rand"
+)
+
+// Every connection starts its sequence numbers somewhere random, so packets left over from an earlier connection with
+// the same peer are almost certainly outside the window of the new one
+func initialSequence() uint32 {
+	return rand.Uint32()
+}
+
+// Sequence numbers wrap around, so they are compared by which way round the gap between them is shorter (RFC 1982)
+// This holds as long as fewer than half of all sequence numbers are ever in flight at once
+func seqLess(a uint32, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+func seqLessEqual(a uint32, b uint32) bool {
+	return a == b || seqLess(a, b)
+}
+
+// Until the handshake tells us where the peer's sequence numbers start, there is nothing to check them against
+func (c *Connection) knowsPeerSequence() bool {
+	return c.state != IDLE && c.state != REQUESTED
+}
+
+// Which request a response answers, peers from before this was sent leave it out
+func responseRequestDeserialize(data []byte) (uint32, bool) {
+	// The request comes after the features and the session
+	offset := 4 + len(uuid.UUID{})
+	if len(data) < offset+4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(data[offset:]), true
+}
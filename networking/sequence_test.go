@@ -0,0 +1,159 @@
+package networking
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSeqLessAcrossWrap(t *testing.T) {
+	tests := []struct {
+		a, b uint32
+		less bool
+	}{
+		{1, 2, true},
+		{2, 1, false},
+		{5, 5, false},
+		{0xfffffff0, 0x10, true},
+		{0x10, 0xfffffff0, false},
+		{0xffffffff, 0, true},
+		{0, 0xffffffff, false},
+		{0x7fffffff, 0x80000000, true},
+		// Anything up to half way round is ahead, past that it is behind
+		{0, 0x7fffffff, true},
+		{0, 0x80000001, false},
+		{0x80000001, 0, true},
+	}
+
+	for _, test := range tests {
+		if less := seqLess(test.a, test.b); less != test.less {
+			t.Errorf("seqLess(%#x, %#x) is %v, expected %v", test.a, test.b, less, test.less)
+		}
+		if lessEqual := seqLessEqual(test.a, test.b); lessEqual != (test.less || test.a == test.b) {
+			t.Errorf("seqLessEqual(%#x, %#x) is %v", test.a, test.b, lessEqual)
+		}
+	}
+}
+
+func TestReceiveWindowAcrossWrap(t *testing.T) {
+	conn := NewConnection(NewHost(NewLoopbackNetwork().Attach(), NewFakeClock(time.Unix(1000, 0))))
+	conn.expectedRecvSeq = 0xfffffffc
+	conn.SetReceiveWindow(8)
+
+	tests := []struct {
+		sequence        uint32
+		goBackN         bool // Old packets are taken to be acked again, only the next new one is taken
+		selectiveRepeat bool // Anything inside the window is buffered
+	}{
+		{0xfffffffb, true, true},
+		{0xfffffffc, true, true},
+		{0xfffffffd, false, true},
+		{0xffffffff, false, true},
+		{0, false, true},
+		{3, false, true},
+		{4, false, false},
+		{0x10, false, false},
+	}
+
+	for _, test := range tests {
+		conn.features = 0
+		if in := conn.inReceiveWindow(test.sequence); in != test.goBackN {
+			t.Errorf("go back n: %#x in the window is %v, expected %v", test.sequence, in, test.goBackN)
+		}
+		conn.features = FEATURE_SELECTIVE_REPEAT
+		if in := conn.inReceiveWindow(test.sequence); in != test.selectiveRepeat {
+			t.Errorf("selective repeat: %#x in the window is %v, expected %v", test.sequence, in, test.selectiveRepeat)
+		}
+	}
+}
+
+func TestSequenceNumbersWrapMidConnection(t *testing.T) {
+	for _, features := range []ConnectionFeatures{0, FEATURE_CUMULATIVE_ACKS, FEATURE_SELECTIVE_REPEAT, DEFAULT_FEATURES} {
+		for seed := int64(1); seed <= 3; seed++ {
+			t.Run(fmt.Sprintf("features=%d/seed=%d", features, seed), func(t *testing.T) {
+				pair := newTestPair(t, features, Impairment{Loss: 0.1, Reorder: 0.2, Seed: seed})
+				pair.connect()
+				opener, responder := pair.peers[0], pair.peers[1]
+				pair.run(TEST_TICK * 5)
+
+				// Move both directions to just short of the wrap, as if the connections had started there
+				for _, side := range [][2]*testPeer{{opener, responder}, {responder, opener}} {
+					if len(side[0].conn.sendWindow) != 0 {
+						t.Fatalf("%d packets are still unacknowledged", len(side[0].conn.sendWindow))
+					}
+					side[0].conn.sentSeq = 0xfffffff8
+					side[1].conn.expectedRecvSeq = 0xfffffff8
+				}
+
+				toResponder, toOpener := chatMessages("to responder", 20), chatMessages("to opener", 20)
+				pair.sendChat(opener, toResponder...)
+				pair.sendChat(responder, toOpener...)
+				pair.runUntil(time.Minute, "every message to arrive", func() bool {
+					return len(responder.received) >= len(toResponder) && len(opener.received) >= len(toOpener)
+				})
+				pair.run(time.Minute)
+
+				if !reflect.DeepEqual(responder.received, toResponder) || !reflect.DeepEqual(opener.received, toOpener) {
+					t.Errorf("responder received %q and opener received %q", responder.received, opener.received)
+				}
+				if opener.conn.sentSeq >= 0xfffffff8 || len(opener.conn.sendWindow) != 0 {
+					t.Errorf("sequence numbers stopped at %#x with %d unacknowledged", opener.conn.sentSeq, len(opener.conn.sendWindow))
+				}
+			})
+		}
+	}
+}
+
+func TestPacketsFromEarlierConnectionAreIgnored(t *testing.T) {
+	for _, features := range []ConnectionFeatures{0, FEATURE_CUMULATIVE_ACKS, DEFAULT_FEATURES} {
+		pair := newTestPair(t, features, Impairment{})
+		pair.connect()
+		opener, responder := pair.peers[0], pair.peers[1]
+
+		pair.sendChat(opener, "old")
+		pair.sendChat(responder, "old reply")
+		pair.run(TEST_TICK * 5)
+		stale := append(sentData(opener.sent), sentData(responder.sent)...)
+		if len(stale) != 2 {
+			t.Fatalf("features %d: sent %d data packets, expected 2", features, len(stale))
+		}
+
+		// The same two hosts connect again, starting from new sequence numbers
+		opener.conn.Close()
+		pair.run(time.Second)
+		oldSequence := opener.conn.sentSeq
+		pair.peers[1].conn = nil
+		pair.connect()
+		if opener.conn.sentSeq == oldSequence {
+			t.Fatalf("features %d: the new connection started from the same sequence number", features)
+		}
+
+		// Copies of the old data turn up in both directions once the new connection is up
+		for _, sent := range stale {
+			from, to := opener, responder
+			if sent.packet.sourceMachine == responder.host.clientId {
+				from, to = responder, opener
+			}
+
+			data, err := sent.packet.serialize()
+			if err != nil {
+				t.Fatalf("serializing old packet: %s", err.Error())
+			}
+			err = from.link.SendFrame(Frame{Source: from.link.LocalAddress(), Destination: to.link.LocalAddress(), Payload: data})
+			if err != nil {
+				t.Fatalf("sending old packet: %s", err.Error())
+			}
+		}
+
+		pair.sendChat(opener, "new")
+		pair.run(time.Second)
+
+		if !reflect.DeepEqual(responder.received, []string{"old", "new"}) || !reflect.DeepEqual(opener.received, []string{"old reply"}) {
+			t.Errorf("features %d: responder received %q and opener received %q", features, responder.received, opener.received)
+		}
+		if len(opener.conn.sendWindow) != 0 || opener.conn.state != ESTABLISHED || responder.conn.state != ESTABLISHED {
+			t.Errorf("features %d: the new connection was upset by the old packets", features)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package networking
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"net"
+	"project-go/logging"
+	"time"
+)
+
+// How long we keep trying to deliver everything and close cleanly before just dropping the connection
+const CLOSE_TIMEOUT = time.Second * 10
+
+func (c *Connection) NewConnectionClose() ConnectionPacket {
+	connection := ConnectionPacket{
+		ConnectionHeader: ConnectionHeader{
+			sourceMachine: c.host.clientId,
+			destMachine:   c.destId,
+			sequence:      c.sentSeq,
+			packetType:    CONNECTION_CLOSE,
+			window:        c.advertisedWindow(),
+		},
+		data: nil,
+	}
+
+	return connection
+}
+
+func (c *Connection) NewConnectionAbort() ConnectionPacket {
+	connection := ConnectionPacket{
+		ConnectionHeader: ConnectionHeader{
+			sourceMachine: c.host.clientId,
+			destMachine:   c.destId,
+			sequence:      c.sentSeq,
+			packetType:    CONNECTION_ABORT,
+			window:        c.advertisedWindow(),
+		},
+		data: nil,
+	}
+
+	return connection
+}
+
+func (c *Connection) Close() {
+	// Already on its way out
+	if c.state == CLOSING {
+		return
+	}
+
+	// A connection that never finished opening has nothing to deliver, so there is nothing to wait for
+	if c.state != ESTABLISHED {
+		c.Abort()
+		return
+	}
+
+	// The close goes after everything already queued, and is resent until the peer acknowledges it
+	// Our state is only reset once that happens, or we give up waiting
+	c.QueuePacket(c.NewConnectionClose())
+	c.state = CLOSING
+	c.closeDeadline = c.host.clock.Now().Add(CLOSE_TIMEOUT)
+}
+
+func (c *Connection) Abort() {
+	// Forcefully send an abort packet, since we are about to remove all of our state tracking
+	// If this frame is lost, the other party will eventually figure out that we're gone
+	if c.state != IDLE && c.state != REQUESTED {
+		err := SendTransport(c.NewConnectionAbort(), c)
+		if err != nil {
+			logging.Debugf("error sending connection abort: " + err.Error())
+		}
+	}
+
+	// Remove our connection tracking
+	c.reset()
+}
+
+func (c *Connection) handleClose(packet ConnectionPacket) (ConnectionEvent, error) {
+	if c.state == IDLE || c.state == REQUESTED {
+		return NO_EVENT, fmt.Errorf("we do not have an active connection")
+	}
+
+	// Selective repeat lets the close arrive early, but we only close once everything before it is here
+	if packet.sequence != c.expectedRecvSeq {
+		return NO_EVENT, fmt.Errorf("close received before the packets sent ahead of it")
+	}
+
+	// Our state is about to go, so the ack can't wait for the next tick
+	err := SendTransport(c.NewConnectionAck(packet.sequence), c)
+	if err != nil {
+		logging.Debugf("error acknowledging connection close: " + err.Error())
+	}
+
+	logging.Debugf("peer closed the connection\n")
+	c.reset()
+
+	return PEER_CLOSED, nil
+}
+
+func (c *Connection) ackStrayClose(packet ConnectionPacket, source net.HardwareAddr) error {
+	if packet.destMachine != c.host.clientId {
+		return fmt.Errorf("packet not addressed to us")
+	}
+
+	// We already closed our side, so our ack of their close must have been lost
+	// Ack it again so the peer doesn't have to wait for its close to time out
+	logging.Debugf("acknowledging close %d from a connection we already closed\n", packet.sequence)
+	return c.replyStray(packet, source, CONNECTION_ACK)
+}
+
+func (c *Connection) replyStray(packet ConnectionPacket, source net.HardwareAddr, packetType ConnectionPacketType) error {
+	// The packet doesn't belong to the connection we have, so the reply goes straight back to whoever sent it
+	reply := ConnectionPacket{
+		ConnectionHeader: ConnectionHeader{
+			sourceMachine: c.host.clientId,
+			destMachine:   packet.sourceMachine,
+			sequence:      packet.sequence,
+			packetType:    packetType,
+			window:        c.advertisedWindow(),
+		},
+		data: nil,
+	}
+
+	data, err := reply.serialize()
+	if err != nil {
+		return err
+	}
+
+	return c.host.link.SendFrame(Frame{Source: c.host.link.LocalAddress(), Destination: source, Payload: data})
+}
+
+func (c *Connection) checkCloseTimeout() bool {
+	if c.state != CLOSING || !c.host.clock.Now().After(c.closeDeadline) {
+		return false
+	}
+
+	logging.Debugf("peer never acknowledged our close, aborting\n")
+	c.Abort()
+	return true
+}
+
+func (c *Connection) giveUp() ConnectionEvent {
+	// Whoever closed the connection has already moved on, so there is nothing to report
+	if c.state == CLOSING {
+		c.Abort()
+		return NO_EVENT
+	}
+
+	// We keep resending the resume until the grace window runs out, however long the peer takes
+	if c.state == REQUESTED && c.resuming {
+		c.numLosses = 0
+		return NO_EVENT
+	}
+
+	// A connection that was in use may only have dropped for a moment, so we try to pick it back up
+	if c.sessionId != uuid.Nil && (c.state == ESTABLISHED || c.state == RESPONDED && c.resuming) {
+		return c.suspend()
+	}
+
+	c.Abort()
+	return PEER_TIMED_OUT
+}
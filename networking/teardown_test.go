@@ -0,0 +1,180 @@
+package networking
+
+import (
+	"testing"
+	"time"
+)
+
+// How many times the peer reported this event
+func (peer *testPeer) heardCount(event ConnectionEvent) int {
+	count := 0
+	for _, heard := range peer.events {
+		if heard == event {
+			count++
+		}
+	}
+	return count
+}
+
+func TestCloseWaitsForQueuedPackets(t *testing.T) {
+	for _, features := range []ConnectionFeatures{0, FEATURE_SELECTIVE_REPEAT, DEFAULT_FEATURES} {
+		pair := newTestPair(t, features, Impairment{})
+		pair.connect()
+		opener, responder := pair.peers[0], pair.peers[1]
+
+		pair.sendChat(opener, chatMessages("message", 10)...)
+
+		// The forfeit is the last thing sent before closing, and is lost the first time
+		forfeit := opener.conn.sentSeq
+		packet, err := PackageChess(NewForfeit(), opener.conn)
+		if err != nil {
+			t.Fatalf("packaging forfeit: %s", err.Error())
+		}
+		opener.conn.QueuePacket(packet)
+		lost := false
+		pair.drop = func(from *testPeer, packet ConnectionPacket) bool {
+			isData := packet.packetType == CONNECTION_DATA || packet.packetType == CONNECTION_DATA_ACK
+			if from == opener && isData && packet.sequence == forfeit && !lost {
+				lost = true
+				return true
+			}
+			return false
+		}
+
+		opener.conn.Close()
+		pair.runUntil(time.Second*10, "the connection to close", func() bool {
+			return !opener.conn.IsActive() && responder.heard(PEER_CLOSED)
+		})
+
+		if !lost {
+			t.Fatalf("features %d: the forfeit was never sent", features)
+		}
+
+		// Everything queued ahead of the close arrives before the peer lets go
+		if len(responder.packets) != 11 || len(responder.received) != 10 {
+			t.Fatalf("features %d: received %d packets, %d of them chat", features, len(responder.packets), len(responder.received))
+		}
+		if _, ok := responder.packets[10].(ForfeitPacket); !ok {
+			t.Errorf("features %d: last packet to arrive was %T, expected the forfeit", features, responder.packets[10])
+		}
+
+		// It was a clean close on both sides
+		if aborts := len(opener.sentOfType(CONNECTION_ABORT)) + len(responder.sentOfType(CONNECTION_ABORT)); aborts != 0 {
+			t.Errorf("features %d: sent %d aborts", features, aborts)
+		}
+		if responder.heardCount(PEER_CLOSED) != 1 || responder.heard(PEER_ABORTED) {
+			t.Errorf("features %d: responder saw events %v", features, responder.events)
+		}
+		for _, peer := range pair.peers {
+			if len(peer.table.Connections()) != 0 {
+				t.Errorf("features %d: %s still has %d connections", features, peer.link.LocalAddress(), len(peer.table.Connections()))
+			}
+		}
+	}
+}
+
+func TestCloseAbortsWhenNeverAcknowledged(t *testing.T) {
+	for _, features := range []ConnectionFeatures{0, DEFAULT_FEATURES} {
+		pair := newTestPair(t, features, Impairment{})
+		pair.connect()
+		opener, responder := pair.peers[0], pair.peers[1]
+
+		// The peer answers every probe but never takes anything, so the close can never be delivered
+		setResponderWindow(pair, 0)
+		pair.sendChat(opener, "stuck")
+		closed := pair.clock.Now()
+		opener.conn.Close()
+
+		pair.runUntil(CLOSE_TIMEOUT+time.Second, "the close to give up", func() bool {
+			return !opener.conn.IsActive()
+		})
+
+		if elapsed := pair.clock.Now().Sub(closed); elapsed < CLOSE_TIMEOUT || elapsed > CLOSE_TIMEOUT+TEST_TICK*2 {
+			t.Errorf("features %d: gave up on the close after %s, expected %s", features, elapsed, CLOSE_TIMEOUT)
+		}
+		if aborts := len(opener.sentOfType(CONNECTION_ABORT)); aborts != 1 {
+			t.Errorf("features %d: sent %d aborts, expected 1", features, aborts)
+		}
+
+		pair.run(TEST_TICK * 3)
+		if !responder.heard(PEER_ABORTED) || responder.heard(PEER_CLOSED) {
+			t.Errorf("features %d: responder saw events %v, expected the abort", features, responder.events)
+		}
+		if responder.conn.IsActive() {
+			t.Errorf("features %d: responder still has the connection open", features)
+		}
+	}
+}
+
+func TestCloseAfterTeardownIsAckedAgain(t *testing.T) {
+	pair := newTestPair(t, DEFAULT_FEATURES, Impairment{})
+	pair.connect()
+	opener, responder := pair.peers[0], pair.peers[1]
+
+	// The responder's ack of the close is lost, after it has already let go of the connection
+	close := opener.conn.sentSeq
+	lost := false
+	pair.drop = func(from *testPeer, packet ConnectionPacket) bool {
+		if from == responder && packet.packetType == CONNECTION_ACK && packet.sequence == close && !lost {
+			lost = true
+			return true
+		}
+		return false
+	}
+
+	closed := pair.clock.Now()
+	opener.conn.Close()
+	pair.runUntil(CLOSE_TIMEOUT, "the close to be acknowledged", func() bool {
+		return !opener.conn.IsActive()
+	})
+
+	if !lost {
+		t.Fatalf("the close was never acknowledged")
+	}
+	if closes := len(opener.sentOfType(CONNECTION_CLOSE)); closes < 2 {
+		t.Errorf("close was sent %d times, expected it to be sent again", closes)
+	}
+
+	// The resent close is answered from no connection at all, without opening one or reporting it again
+	acks := 0
+	for _, sent := range responder.sentOfType(CONNECTION_ACK) {
+		if sent.packet.sequence == close {
+			acks++
+		}
+	}
+	if acks != 2 {
+		t.Errorf("close was acknowledged %d times, expected 2", acks)
+	}
+	if responder.heardCount(PEER_CLOSED) != 1 || len(responder.table.Connections()) != 0 {
+		t.Errorf("responder saw events %v and has %d connections", responder.events, len(responder.table.Connections()))
+	}
+
+	// Well before the close would have timed out, and without falling back to an abort
+	if elapsed := pair.clock.Now().Sub(closed); elapsed >= CLOSE_TIMEOUT/2 {
+		t.Errorf("close took %s to be acknowledged", elapsed)
+	}
+	if len(opener.sentOfType(CONNECTION_ABORT)) != 0 {
+		t.Errorf("aborted instead of waiting for the close to be acknowledged")
+	}
+}
+
+func TestAbortIsReportedToPeer(t *testing.T) {
+	pair := newTestPair(t, DEFAULT_FEATURES, Impairment{})
+	pair.connect()
+	opener, responder := pair.peers[0], pair.peers[1]
+
+	// Nothing is waited for, the connection is gone straight away
+	pair.sendChat(opener, "never sent")
+	opener.conn.Abort()
+	if opener.conn.IsActive() {
+		t.Fatalf("connection is still open after aborting")
+	}
+
+	pair.run(TEST_TICK * 3)
+	if !responder.heard(PEER_ABORTED) || responder.heard(PEER_CLOSED) || len(responder.received) != 0 {
+		t.Errorf("responder saw events %v and received %q", responder.events, responder.received)
+	}
+	if responder.conn.IsActive() {
+		t.Errorf("responder still has the connection open")
+	}
+}
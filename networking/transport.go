@@ -3,7 +3,6 @@ package networking
 import (
 	"bytes"
 	"fmt"
-	"net"
 )
 
 type ITransportPacket interface {
@@ -11,15 +10,15 @@ type ITransportPacket interface {
 	serialize() ([]byte, error)
 }
 
-func ParseTransport(data []byte, dest net.HardwareAddr) (ITransportPacket, error) {
+func ParseTransport(frame Frame, link Link) (ITransportPacket, error) {
 	// Determine whether this is a broadcast or connection packet
 	var transportPacket ITransportPacket
 	var err error
 
-	if bytes.Equal(dest, GetBroadcastAddress()) {
-		transportPacket, err = broadcastDeserialize(data)
-	} else if bytes.Equal(dest, mac) {
-		transportPacket, err = connectionDeserialize(data)
+	if bytes.Equal(frame.Destination, link.BroadcastAddress()) {
+		transportPacket, err = broadcastDeserialize(frame.Payload)
+	} else if bytes.Equal(frame.Destination, link.LocalAddress()) {
+		transportPacket, err = connectionDeserialize(frame.Payload)
 	} else {
 		// Ignore, wasn't for us
 		return nil, fmt.Errorf("frame not addressed to us")
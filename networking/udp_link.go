@@ -0,0 +1,253 @@
+package networking
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"project-go/logging"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// Every client listens on this multicast group for broadcasts, the port matches our ethertype
+const UDP_MULTICAST_GROUP = "239.255.95.40"
+const UDP_MULTICAST_PORT = 9528
+
+// How many routers lobby broadcasts may cross
+const UDP_MULTICAST_TTL = 8
+
+// The largest datagram we can receive
+const UDP_MAX_DATAGRAM = 65535
+
+// IPv4 and UDP headers come out of every packet the interface sends
+const UDP_HEADER_OVERHEAD = 20 + 8
+
+// The MTU we assume when there is no interface to ask, the usual one for Ethernet
+const DEFAULT_MTU = 1500
+
+// Carries our frames over UDP instead of raw Ethernet, so no root is needed and frames can be routed
+// Addresses are still 6 bytes, made of the IPv4 address followed by the port
+type UDPLink struct {
+	unicast   *net.UDPConn
+	multicast *net.UDPConn
+	group     *net.UDPAddr
+	local     net.HardwareAddr
+	mtu       int
+	received  chan udpDatagram
+	done      chan struct{} // Closed along with the link, so the read threads stop waiting for anyone to take their datagrams
+	closing   sync.Once
+}
+
+type udpDatagram struct {
+	frame Frame
+	err   error
+}
+
+func NewUDPLink(port string) (*UDPLink, error) {
+	// Default to any free port, each client on a machine needs its own
+	portNumber := 0
+	if port != "" {
+		var err error
+		portNumber, err = strconv.Atoi(port)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %s", port)
+		}
+	}
+
+	// Use the same interface as raw Ethernet would, if we can find it
+	var iface *net.Interface
+	chosen, err := GetIface()
+	if err == nil {
+		iface = &chosen
+	} else {
+		logging.Debug("no default interface, using the system default for multicast: " + err.Error())
+	}
+
+	group := &net.UDPAddr{IP: net.ParseIP(UDP_MULTICAST_GROUP), Port: UDP_MULTICAST_PORT}
+
+	// Every client joins the multicast group to hear broadcasts
+	multicast, err := net.ListenMulticastUDP("udp4", iface, group)
+	if err != nil {
+		return nil, fmt.Errorf("error joining multicast group: %s", err.Error())
+	}
+
+	// Everything else is sent and received on our own port
+	unicast, err := net.ListenUDP("udp4", &net.UDPAddr{Port: portNumber})
+	if err != nil {
+		multicast.Close()
+		return nil, fmt.Errorf("error opening UDP port: %s", err.Error())
+	}
+
+	// Keep our datagrams small enough that IP never has to split them
+	mtu := DEFAULT_MTU
+	if iface != nil && iface.MTU > 0 {
+		mtu = iface.MTU
+	}
+
+	link := &UDPLink{
+		unicast:   unicast,
+		multicast: multicast,
+		group:     group,
+		mtu:       mtu - UDP_HEADER_OVERHEAD,
+		received:  make(chan udpDatagram),
+		done:      make(chan struct{}),
+	}
+	link.setMulticastOptions(iface)
+
+	// Our address is our interface's IP along with the port we ended up on
+	localIp := net.IPv4zero
+	if iface != nil {
+		localIp = interfaceIPv4(*iface)
+	}
+	link.local = udpToHardwareAddr(&net.UDPAddr{IP: localIp, Port: unicast.LocalAddr().(*net.UDPAddr).Port})
+	logging.Log("Listening for UDP on " + hardwareAddrToUDP(link.local).String())
+
+	// Both sockets are read at once, with the results merged for ReceiveFrame
+	go link.readThread(multicast, link.BroadcastAddress())
+	go link.readThread(unicast, link.local)
+
+	return link, nil
+}
+
+func (l *UDPLink) Close() {
+	l.closing.Do(func() {
+		close(l.done)
+		l.unicast.Close()
+		l.multicast.Close()
+	})
+}
+
+func (l *UDPLink) LocalAddress() net.HardwareAddr {
+	return l.local
+}
+
+func (l *UDPLink) BroadcastAddress() net.HardwareAddr {
+	return GetBroadcastAddress()
+}
+
+func (l *UDPLink) MTU() int {
+	return l.mtu
+}
+
+func (l *UDPLink) SendFrame(frame Frame) error {
+	// Broadcasts go to the multicast group, everything else straight to the peer
+	dest := l.group
+	if !isBroadcast(frame.Destination, l.BroadcastAddress()) {
+		dest = hardwareAddrToUDP(frame.Destination)
+	}
+
+	// Always send from our own port, so that replies come back to us
+	logging.Debugf("sending %x to %s\n", frame.Payload, dest.String())
+	_, err := l.unicast.WriteToUDP(frame.Payload, dest)
+	return err
+}
+
+func (l *UDPLink) ReceiveFrame() (Frame, error) {
+	select {
+	case datagram := <-l.received:
+		return datagram.frame, datagram.err
+	case <-l.done:
+		return Frame{}, fmt.Errorf("link is closed")
+	}
+}
+
+func (l *UDPLink) readThread(conn *net.UDPConn, destination net.HardwareAddr) {
+	buf := make([]byte, UDP_MAX_DATAGRAM)
+
+	for true {
+		dataLen, source, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			l.deliver(udpDatagram{err: err})
+			return
+		}
+
+		// Copy the data out, since the buffer is reused for the next datagram
+		payload := make([]byte, dataLen)
+		copy(payload, buf[:dataLen])
+
+		// The source address is wherever the datagram came from, so replies go straight back
+		delivered := l.deliver(udpDatagram{frame: Frame{
+			Source:      udpToHardwareAddr(source),
+			Destination: destination,
+			Payload:     payload,
+		}})
+		if !delivered {
+			return
+		}
+	}
+}
+
+// Hands a datagram to ReceiveFrame, or gives up if the link is closed first, since nobody will ever take it
+func (l *UDPLink) deliver(datagram udpDatagram) bool {
+	select {
+	case l.received <- datagram:
+		return true
+	case <-l.done:
+		return false
+	}
+}
+
+func (l *UDPLink) setMulticastOptions(iface *net.Interface) {
+	// The standard library has no options for sending multicast, so set them on the socket directly
+	rawConn, err := l.unicast.SyscallConn()
+	if err != nil {
+		return
+	}
+
+	rawConn.Control(func(fd uintptr) {
+		// Allow lobby broadcasts to cross a few routers
+		err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_TTL, UDP_MULTICAST_TTL)
+		if err != nil {
+			logging.Debug("error setting multicast ttl: " + err.Error())
+		}
+
+		// Send broadcasts out of the same interface we joined the group on
+		if iface != nil {
+			err = syscall.SetsockoptIPMreqn(int(fd), syscall.IPPROTO_IP, syscall.IP_MULTICAST_IF, &syscall.IPMreqn{Ifindex: int32(iface.Index)})
+			if err != nil {
+				logging.Debug("error setting multicast interface: " + err.Error())
+			}
+		}
+	})
+}
+
+func udpToHardwareAddr(addr *net.UDPAddr) net.HardwareAddr {
+	// The first 4 bytes are the IPv4 address, the last 2 are the port
+	hardwareAddr := make(net.HardwareAddr, 6)
+	copy(hardwareAddr[:4], addr.IP.To4())
+	binary.BigEndian.PutUint16(hardwareAddr[4:], uint16(addr.Port))
+	return hardwareAddr
+}
+
+func hardwareAddrToUDP(addr net.HardwareAddr) *net.UDPAddr {
+	if len(addr) < 6 {
+		return &net.UDPAddr{}
+	}
+
+	return &net.UDPAddr{
+		IP:   net.IPv4(addr[0], addr[1], addr[2], addr[3]),
+		Port: int(binary.BigEndian.Uint16(addr[4:6])),
+	}
+}
+
+func interfaceIPv4(iface net.Interface) net.IP {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return net.IPv4zero
+	}
+
+	for _, addr := range addrs {
+		ip, _, err := net.ParseCIDR(addr.String())
+		if err == nil && ip.To4() != nil {
+			return ip.To4()
+		}
+	}
+
+	return net.IPv4zero
+}
+
+func isBroadcast(addr net.HardwareAddr, broadcast net.HardwareAddr) bool {
+	return bytes.Equal(addr, broadcast)
+}
@@ -4,6 +4,7 @@ import (
 	"project-go/chess"
 	"project-go/logging"
 	"project-go/networking"
+	"time"
 )
 
 func HandlePacket(ctx *Context, packet networking.IChessPacket) ClientState {
@@ -17,6 +18,8 @@ func HandlePacket(ctx *Context, packet networking.IChessPacket) ClientState {
 		return handleLobbyInfo(ctx, casted)
 	case networking.LobbyJoinRequest:
 		return handleLobbyJoinRequest(ctx, casted)
+	case networking.LobbySpectateRequest:
+		return handleLobbySpectateRequest(ctx, casted)
 	case networking.LobbyStartRequest:
 		return handleLobbyStartRequest(ctx, casted)
 	case networking.LobbyStartAccepted:
@@ -25,23 +28,31 @@ func HandlePacket(ctx *Context, packet networking.IChessPacket) ClientState {
 		return handleMovePiece(ctx, casted)
 	case networking.ForfeitPacket:
 		return handleForfeit(ctx, casted)
+	case networking.DropPiecePacket:
+		return handleDropPiece(ctx, casted)
+	case networking.ChatPacket:
+		return handleChat(ctx, casted)
+	case networking.GameSyncPacket:
+		return handleGameSync(ctx, casted)
 	default:
 		return ctx.ClientState
 	}
 }
 
 func handleLobbyCreated(ctx *Context, packet networking.LobbyCreatedPacket) ClientState {
+	listing := ctx.rememberLobby(packet.Name, packet.Variant, 0, packet.SourceAddress)
 	if ctx.ClientState == MENU {
-		logging.Log("New lobby created: " + packet.Name)
+		logging.Log("New lobby created: " + listing.Description())
 	}
 	return ctx.ClientState
 }
 
 func handleLobbyListRequest(ctx *Context, packet networking.LobbyListRequest) ClientState {
 	var response networking.LobbyInfoPacket
-	if ctx.Lobby.hosting && !ctx.Connection.IsActive() {
+	if ctx.Lobby.hosting {
 		// Respond to the broadcast with another broadcast announcing our lobby
-		response = networking.NewLobbyInfo(ctx.Lobby.name)
+		// A game that has already started is still announced, since it can be watched
+		response = networking.NewLobbyInfo(ctx.Lobby.name, ctx.Lobby.variant, ctx.spectatorCount())
 		err := ctx.BroadcastPacket(response)
 		if err != nil {
 			logging.Log("Error broadcasting lobby info.")
@@ -51,8 +62,9 @@ func handleLobbyListRequest(ctx *Context, packet networking.LobbyListRequest) Cl
 }
 
 func handleLobbyInfo(ctx *Context, packet networking.LobbyInfoPacket) ClientState {
+	listing := ctx.rememberLobby(packet.Name, packet.Variant, packet.Spectators, packet.SourceAddress)
 	if ctx.ClientState == MENU {
-		logging.Log("Lobby available at: " + packet.Name)
+		logging.Log("Lobby available at: " + listing.Description())
 	}
 	return ctx.ClientState
 }
@@ -63,11 +75,12 @@ func handleLobbyJoinRequest(ctx *Context, packet networking.LobbyJoinRequest) Cl
 
 		// Try to open a connection with the peer that wants to join
 		// Upon a successful connection, the game will be ready to start
-		err := ctx.Connection.Open(packet.SourceAddress)
+		conn, err := ctx.Connections.Open(packet.SourceAddress, packet.SourceClient)
 		if err != nil {
 			logging.Debug("Error opening connection: " + err.Error())
 			return ctx.ClientState
 		}
+		ctx.Connection = conn
 
 		return LOBBY
 	}
@@ -75,8 +88,38 @@ func handleLobbyJoinRequest(ctx *Context, packet networking.LobbyJoinRequest) Cl
 	return ctx.ClientState
 }
 
+func handleLobbySpectateRequest(ctx *Context, packet networking.LobbySpectateRequest) ClientState {
+	if ctx.Lobby.hosting && packet.Name == ctx.Lobby.name {
+		logging.Logf("Peer %x is asking to watch your game.\n", packet.SourceAddress)
+
+		// Open a connection to the spectator just like a player, the game is sent once it is up
+		conn, err := ctx.Connections.Open(packet.SourceAddress, packet.SourceClient)
+		if err != nil {
+			logging.Debug("Error opening connection: " + err.Error())
+			return ctx.ClientState
+		}
+		ctx.addSpectator(conn)
+	}
+
+	return ctx.ClientState
+}
+
 func handleLobbyStartRequest(ctx *Context, packet networking.LobbyStartRequest) ClientState {
 	if !ctx.Lobby.hosting {
+		// We can't play a variant we don't know the rules for
+		if !packet.Variant.Supported() {
+			logging.Logf("The host wants to play an unsupported variant (%d), leaving the lobby.\n", packet.Variant)
+			ctx.Connection.Close()
+			ctx.Lobby = Lobby{}
+			return MENU
+		}
+		if packet.Variant == chess.CHESS960 && !chess.ValidStartPosition(packet.StartPosition) {
+			logging.Logf("The host wants to start from an invalid position (%d), leaving the lobby.\n", packet.StartPosition)
+			ctx.Connection.Close()
+			ctx.Lobby = Lobby{}
+			return MENU
+		}
+
 		// Tell the lobby host that we're ok to start the game
 		response := networking.NewLobbyStartAccepted()
 		err := ctx.SendPacket(response)
@@ -84,8 +127,10 @@ func handleLobbyStartRequest(ctx *Context, packet networking.LobbyStartRequest)
 			logging.Debug("error sending start lobby: " + err.Error())
 		}
 
-		// Reset the game state before showing the game board
-		ctx.GameState = chess.CreateState()
+		// Reset the game state before showing the game board, using the same setup as the host
+		// The host always plays WHITE
+		ctx.GameState = chess.CreateVariantState(packet.Variant, packet.StartPosition)
+		ctx.PlayerColour = chess.BLACK
 		return THEIR_TURN
 	}
 
@@ -96,21 +141,184 @@ func handleLobbyStartAccepted(ctx *Context, packet networking.LobbyStartAccepted
 	if ctx.Lobby.hosting && ctx.Lobby.Ready {
 		logging.Log("Game is starting")
 		// Reset the game state before showing the game board
-		ctx.GameState = chess.CreateState()
+		ctx.GameState = chess.CreateVariantState(ctx.Lobby.variant, ctx.Lobby.startPosition)
+		ctx.PlayerColour = chess.WHITE
 		return MY_TURN
 	}
 	return ctx.ClientState
 }
 
 func handleMovePiece(ctx *Context, packet networking.MovePiecePacket) ClientState {
+	// A spectator can't follow moves until it has the game they were made in
+	if ctx.ClientState == SPECTATING && !ctx.Lobby.synced {
+		return ctx.ClientState
+	}
+
 	// Move the piece switch turns, we're ready to accept user input again
-	ctx.GameState.MovePiece(packet.SrcPos, packet.DestPos)
+	moved, failedReason := ctx.GameState.MovePiece(packet.SrcPos, packet.DestPos)
+	if !moved {
+		return refuseTheirMove(ctx, failedReason)
+	}
+	ctx.forwardToSpectators(packet)
+	return finishTheirMove(ctx)
+}
+
+func handleDropPiece(ctx *Context, packet networking.DropPiecePacket) ClientState {
+	if ctx.ClientState == SPECTATING && !ctx.Lobby.synced {
+		return ctx.ClientState
+	}
+
+	// Drop the piece switch turns, we're ready to accept user input again
+	dropped, failedReason := ctx.GameState.DropPiece(packet.Piece, packet.DestPos)
+	if !dropped {
+		return refuseTheirMove(ctx, failedReason)
+	}
+	ctx.forwardToSpectators(packet)
+	return finishTheirMove(ctx)
+}
+
+// A move the other side made can't be made on our board, so the two games no longer match
+// Nothing is switched or passed on, we just leave like any other desync
+func refuseTheirMove(ctx *Context, reason string) ClientState {
+	logging.Debug("error making their move: " + reason)
+	if ctx.ClientState == SPECTATING {
+		logging.Log("Unable to follow the game being watched, leaving.")
+		ctx.Lobby = Lobby{}
+	} else {
+		logging.Log("The game no longer matches the other side's, leaving the game.")
+		ctx.Lobby.hosting = false
+	}
+	ctx.Connection.Close()
+	return MENU
+}
+
+func finishTheirMove(ctx *Context) ClientState {
 	ctx.GameState.SwitchTurn()
+
+	// Their move may have ended the game, if so we close the connection the same way as a forfeit
+	outcome := ctx.GameState.Outcome()
+	if outcome.Over {
+		ctx.gameOver(outcome)
+		ctx.Lobby.hosting = false
+		ctx.Connection.Close()
+		return MENU
+	}
+
+	// Spectators only ever watch, whoever's turn it is, so show them the move straight away
+	if ctx.ClientState == SPECTATING {
+		PrintPrompt(ctx)
+		return SPECTATING
+	}
 	return MY_TURN
 }
 
+func handleGameSync(ctx *Context, packet networking.GameSyncPacket) ClientState {
+	if ctx.ClientState == SPECTATING {
+		return handleSpectatedGame(ctx, packet)
+	}
+
+	if ctx.ClientState != MY_TURN && ctx.ClientState != THEIR_TURN {
+		return ctx.ClientState
+	}
+
+	// Both sides played the same game up to where one of them stopped hearing from the other
+	ours := ctx.GameState.History()
+	inSync := packet.Variant == ctx.GameState.Variant() && packet.StartPosition == ctx.GameState.StartPosition()
+	for i := 0; inSync && i < len(ours) && i < len(packet.Moves); i++ {
+		inSync = ours[i] == packet.Moves[i]
+	}
+
+	// Catch up on any moves that were lost with the connection, if we are the ones behind
+	for i := len(ours); inSync && i < len(packet.Moves); i++ {
+		ok, reason := ctx.GameState.Replay(packet.Moves[i])
+		if !ok {
+			logging.Debug("error replaying move: " + reason)
+			inSync = false
+		}
+	}
+
+	if !inSync {
+		logging.Log("The game no longer matches the other side's, leaving the game.")
+		ctx.Lobby.hosting = false
+		ctx.Connection.Close()
+		return MENU
+	}
+
+	// A replayed move may have ended the game
+	outcome := ctx.GameState.Outcome()
+	if outcome.Over {
+		ctx.gameOver(outcome)
+		ctx.Lobby.hosting = false
+		ctx.Connection.Close()
+		return MENU
+	}
+
+	if ctx.GameState.Turn() == ctx.PlayerColour {
+		return MY_TURN
+	}
+	return THEIR_TURN
+}
+
+func handleSpectatedGame(ctx *Context, packet networking.GameSyncPacket) ClientState {
+	if !packet.Variant.Supported() {
+		logging.Logf("The game is an unsupported variant (%d), leaving.\n", packet.Variant)
+		ctx.Lobby = Lobby{}
+		ctx.Connection.Close()
+		return MENU
+	}
+	if packet.Variant == chess.CHESS960 && !chess.ValidStartPosition(packet.StartPosition) {
+		logging.Logf("The game starts from an invalid position (%d), leaving.\n", packet.StartPosition)
+		ctx.Lobby = Lobby{}
+		ctx.Connection.Close()
+		return MENU
+	}
+
+	// The host sends the whole game, so spectators start over from the beginning every time
+	state := chess.CreateVariantState(packet.Variant, packet.StartPosition)
+	for _, move := range packet.Moves {
+		ok, reason := state.Replay(move)
+		if !ok {
+			logging.Debug("error replaying move: " + reason)
+			logging.Log("Unable to follow the game being watched, leaving.")
+			ctx.Lobby = Lobby{}
+			ctx.Connection.Close()
+			return MENU
+		}
+	}
+
+	if !ctx.Lobby.synced {
+		logging.Log("Watching the game, " + state.Turn().String() + " to move.")
+	}
+	ctx.GameState = state
+	ctx.Lobby.variant = packet.Variant
+	ctx.Lobby.synced = true
+
+	// The game may already be over by the time we join
+	outcome := ctx.GameState.Outcome()
+	if outcome.Over {
+		ctx.gameOver(outcome)
+		ctx.Lobby = Lobby{}
+		ctx.Connection.Close()
+		return MENU
+	}
+
+	// Show the board now, since a spectator stays in the same state
+	PrintPrompt(ctx)
+	return SPECTATING
+}
+
+func handleChat(ctx *Context, packet networking.ChatPacket) ClientState {
+	ctx.addChat(ChatLine{Mine: false, Text: packet.Message, Time: time.Now()})
+	return ctx.ClientState
+}
+
 func handleForfeit(ctx *Context, packet networking.ForfeitPacket) ClientState {
-	logging.Log("The other user has forfeit.")
+	if ctx.ClientState == SPECTATING {
+		logging.Log("One of the players has forfeit.")
+	} else {
+		logging.Log("The other user has forfeit.")
+		ctx.forwardToSpectators(packet)
+	}
 	ctx.Lobby.hosting = false
 	ctx.Connection.Close()
 	return MENU
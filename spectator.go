@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net"
+	"project-go/logging"
+	"project-go/networking"
+)
+
+// Someone watching the game we are hosting, they hear every move but can't make any
+type Spectator struct {
+	conn      *networking.Connection
+	peer      net.HardwareAddr // Kept apart from the connection, which forgets its peer once closed
+	connected bool             // The game is only sent once the connection is up, so no move can arrive ahead of it
+}
+
+func (c *Context) addSpectator(conn *networking.Connection) {
+	c.Spectators = append(c.Spectators, &Spectator{conn: conn, peer: conn.Peer()})
+}
+
+func (c *Context) findSpectator(conn *networking.Connection) *Spectator {
+	for _, spectator := range c.Spectators {
+		if spectator.conn == conn {
+			return spectator
+		}
+	}
+	return nil
+}
+
+// How many spectators are watching right now, as advertised with the lobby
+func (c *Context) spectatorCount() int {
+	count := 0
+	for _, spectator := range c.Spectators {
+		if spectator.connected {
+			count++
+		}
+	}
+	return count
+}
+
+func (c *Context) handleSpectatorEvent(spectator *Spectator, event networking.ConnectionEvent) {
+	switch event {
+	case networking.PEER_CONNECTED:
+		logging.Logf("Peer %x is now watching your game.\n", spectator.peer)
+		spectator.connected = true
+		c.syncSpectator(spectator)
+	case networking.PEER_RESUMED:
+		// Moves may have been lost along with the connection, so the spectator starts over from the whole game
+		c.syncSpectator(spectator)
+	case networking.PEER_CLOSED, networking.PEER_ABORTED, networking.PEER_TIMED_OUT:
+		logging.Logf("Peer %x has stopped watching your game.\n", spectator.peer)
+	}
+}
+
+func (c *Context) syncSpectator(spectator *Spectator) {
+	// Until the game starts there is nothing to show, everyone watching is sent it as it begins
+	if !spectator.connected || (c.ClientState != MY_TURN && c.ClientState != THEIR_TURN) {
+		return
+	}
+
+	err := c.SendPacketTo(spectator.conn, networking.NewGameSync(&c.GameState))
+	if err != nil {
+		logging.Debug("error sending game to spectator: " + err.Error())
+	}
+}
+
+func (c *Context) syncSpectators() {
+	for _, spectator := range c.Spectators {
+		c.syncSpectator(spectator)
+	}
+}
+
+// Passes a move or forfeit on to everyone watching, in the same order the players saw it
+func (c *Context) forwardToSpectators(packet networking.IChessPacket) {
+	for _, spectator := range c.Spectators {
+		if !spectator.connected {
+			continue
+		}
+
+		err := c.SendPacketTo(spectator.conn, packet)
+		if err != nil {
+			logging.Debug("error forwarding to spectator: " + err.Error())
+		}
+	}
+}
+
+func (c *Context) closeSpectators() {
+	// Anything already forwarded is still delivered before each connection closes
+	for _, spectator := range c.Spectators {
+		spectator.conn.Close()
+	}
+	c.Spectators = nil
+}
+
+func (c *Context) pruneSpectators() {
+	active := c.Spectators[:0]
+	for _, spectator := range c.Spectators {
+		if spectator.conn.IsActive() {
+			active = append(active, spectator)
+		}
+	}
+	c.Spectators = active
+}
+
+func (c *Context) updateSpectators(oldState ClientState, newState ClientState) {
+	wasPlaying := oldState == MY_TURN || oldState == THEIR_TURN
+	nowPlaying := newState == MY_TURN || newState == THEIR_TURN
+
+	if nowPlaying && !wasPlaying {
+		// The game has just begun, so everyone already watching is sent the starting board
+		c.syncSpectators()
+	} else if newState == MENU {
+		// The lobby is gone, and there is nothing left to watch
+		c.closeSpectators()
+	}
+}
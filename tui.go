@@ -0,0 +1,393 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"project-go/chess"
+	"project-go/logging"
+	"project-go/networking"
+	"project-go/util"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+// Where each panel is drawn on screen, in terminal rows and columns starting from 1
+const (
+	BOARD_ROW    = 3
+	BOARD_COL    = 2
+	SIDE_COL     = 32
+	LIST_COL     = 60
+	PANEL_HEIGHT = 10
+	MESSAGE_ROW  = BOARD_ROW + PANEL_HEIGHT + 1
+)
+
+// How many lines of output we keep around for the message panel
+const MAX_MESSAGES = 200
+
+// The clocks only need redrawing a couple of times a second
+const REDRAW_INTERVAL = time.Millisecond * 500
+
+// Fallback screen size for when the terminal will not tell us
+const DEFAULT_WIDTH = 100
+const DEFAULT_HEIGHT = 30
+
+type TUI struct {
+	ctx      *Context
+	oldState *syscall.Termios
+	cursor   chess.Position
+	selected *chess.Position
+	command  string
+	lastDraw time.Time
+
+	// Output can be logged from any thread, so the message panel is locked
+	lock     sync.Mutex
+	messages []string
+	partial  string
+}
+
+func StartTUI(ctx *Context) (*TUI, error) {
+	// Raw mode lets us see every key press, including the arrow keys
+	oldState, err := util.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		return nil, err
+	}
+
+	// Start the cursor on the WHITE King, since the host always plays WHITE
+	tui := &TUI{ctx: ctx, oldState: oldState, cursor: chess.Position{X: 4, Y: 7}}
+
+	// Capture all output into the message panel, so nothing scribbles over the screen
+	logging.SetOutput(tui.appendOutput)
+
+	// Switch to the alternate screen and hide the terminal cursor
+	os.Stdout.WriteString("\033[?1049h\033[?25l")
+
+	return tui, nil
+}
+
+func (t *TUI) Stop() {
+	logging.SetOutput(nil)
+
+	// Put the terminal back the way we found it
+	os.Stdout.WriteString("\033[?25h\033[?1049l")
+	err := util.RestoreTerminal(int(os.Stdin.Fd()), t.oldState)
+	if err != nil {
+		logging.Log("Error restoring the terminal: " + err.Error())
+	}
+}
+
+func RawInputThread(result chan<- string) {
+	buf := make([]byte, 64)
+
+	// Loop until the program is terminated
+	for true {
+		// Escape sequences like the arrow keys arrive together in a single read
+		dataLen, err := os.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		result <- string(buf[:dataLen])
+	}
+}
+
+func (t *TUI) HandleKeys(keys string) {
+	for i := 0; i < len(keys); i++ {
+		key := keys[i]
+
+		switch {
+		case key == 0x1b && i+2 < len(keys) && keys[i+1] == '[':
+			// An arrow key, which moves the cursor around the board
+			t.moveCursor(keys[i+2])
+			i += 2
+		case key == 0x1b:
+			// Escape on its own cancels whatever we were doing
+			t.selected = nil
+			t.command = ""
+		case key == 0x03:
+			// Ctrl-C no longer sends a signal in raw mode, so quit ourselves
+			t.ctx.changeState(EXITING)
+			return
+		case key == '\r' || key == '\n':
+			t.enter()
+		case key == 0x7f || key == 0x08:
+			// Backspace removes the last character of the command
+			if len(t.command) > 0 {
+				t.command = t.command[:len(t.command)-1]
+			}
+		case key >= 0x20 && key < 0x7f:
+			t.command += string(key)
+		}
+	}
+}
+
+func (t *TUI) Tick() {
+	if time.Since(t.lastDraw) >= REDRAW_INTERVAL {
+		t.Draw()
+	}
+}
+
+func (t *TUI) moveCursor(direction byte) {
+	switch direction {
+	case 'A':
+		t.cursor.Y = util.Max(t.cursor.Y-1, 0)
+	case 'B':
+		t.cursor.Y = util.Min(t.cursor.Y+1, 7)
+	case 'C':
+		t.cursor.X = util.Min(t.cursor.X+1, 7)
+	case 'D':
+		t.cursor.X = util.Max(t.cursor.X-1, 0)
+	}
+}
+
+func (t *TUI) enter() {
+	// A typed command is run exactly as if it was entered on the command line
+	if t.command != "" {
+		command := t.command
+		t.command = ""
+		t.runCommand(command)
+		return
+	}
+
+	// Otherwise, Enter picks up the piece under the cursor, then puts it down
+	if t.ctx.ClientState != MY_TURN {
+		return
+	}
+
+	if t.selected == nil {
+		selected := t.cursor
+		t.selected = &selected
+		return
+	}
+
+	command := fmt.Sprintf(".move %s %s", t.selected.String(), t.cursor.String())
+	t.selected = nil
+	t.runCommand(command)
+}
+
+func (t *TUI) runCommand(command string) {
+	logging.Log("> " + command)
+	t.ctx.handleInput(command)
+}
+
+func (t *TUI) appendOutput(text string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// Output may arrive in pieces, so only complete lines are added to the panel
+	t.partial += strings.ReplaceAll(text, "\r", "")
+	lines := strings.Split(t.partial, "\n")
+	t.partial = lines[len(lines)-1]
+	t.messages = append(t.messages, lines[:len(lines)-1]...)
+
+	if len(t.messages) > MAX_MESSAGES {
+		t.messages = t.messages[len(t.messages)-MAX_MESSAGES:]
+	}
+}
+
+func (t *TUI) Draw() {
+	t.lastDraw = time.Now()
+
+	width, height, err := util.TerminalSize(int(os.Stdout.Fd()))
+	if err != nil || width == 0 || height == 0 {
+		width, height = DEFAULT_WIDTH, DEFAULT_HEIGHT
+	}
+
+	// Build the whole screen up front, so it is written in one go and doesn't flicker
+	screen := strings.Builder{}
+	screen.WriteString("\033[H\033[2J")
+
+	title := "Chess over Ethernet - " + t.ctx.ClientState.String()
+	if t.ctx.ClientState == LOBBY || t.ctx.ClientState == SPECTATING {
+		title += " - " + t.ctx.Lobby.Name() + " (" + t.ctx.Lobby.Variant().String() + ")"
+	}
+	writeAt(&screen, 1, 1, title)
+
+	t.drawBoard(&screen)
+	t.drawSidePanel(&screen)
+	if t.ctx.ClientState == MENU {
+		t.drawLobbies(&screen)
+	} else {
+		t.drawChat(&screen, width)
+	}
+	t.drawMessages(&screen, width, height)
+
+	// The bottom two rows are the available commands and the command being typed
+	writeAt(&screen, height-1, 1, truncate(commandHints(t.ctx), width))
+	writeAt(&screen, height, 1, truncate("> "+t.command+"_", width))
+
+	os.Stdout.WriteString(screen.String())
+}
+
+func (t *TUI) drawBoard(screen *strings.Builder) {
+	highlights := t.ctx.GameState.Highlights()
+	if t.ctx.ClientState == MY_TURN || t.ctx.ClientState == THEIR_TURN {
+		highlights.Cursor = &t.cursor
+		highlights.Selected = t.selected
+	}
+
+	board := t.ctx.GameState.Board()
+	for i, line := range board.Lines(chess.UNICODE_RENDER, highlights) {
+		writeAt(screen, BOARD_ROW+i, BOARD_COL, line)
+	}
+}
+
+func (t *TUI) drawSidePanel(screen *strings.Builder) {
+	row := BOARD_ROW
+	playing := t.ctx.ClientState == MY_TURN || t.ctx.ClientState == THEIR_TURN
+	watching := t.ctx.ClientState == SPECTATING && t.ctx.Lobby.synced
+
+	if playing {
+		writeAt(screen, row, SIDE_COL, "You are "+t.ctx.PlayerColour.String())
+		writeAt(screen, row+1, SIDE_COL, "Variant "+t.ctx.GameState.Variant().String())
+	} else if watching {
+		writeAt(screen, row, SIDE_COL, "You are watching")
+		writeAt(screen, row+1, SIDE_COL, "Variant "+t.ctx.GameState.Variant().String())
+	}
+	if (playing || watching) && t.ctx.Connection.IsActive() {
+		writeAt(screen, row+2, SIDE_COL, linkStatus(t.ctx.Connection))
+	}
+	row += 3
+
+	// Clocks
+	writeAt(screen, row, SIDE_COL, "Clocks")
+	for i, colour := range []chess.Colour{chess.WHITE, chess.BLACK} {
+		marker := "  "
+		if (playing || watching) && t.ctx.GameState.Turn() == colour {
+			marker = "> "
+		}
+		writeAt(screen, row+1+i, SIDE_COL, marker+fmt.Sprintf("%-6s", colour.String())+formatClock(t.ctx.Clock.Elapsed(colour)))
+	}
+	row += 4
+
+	// Move list, newest at the bottom, with both sides' moves on one line
+	moves := t.ctx.GameState.Moves()
+	writeAt(screen, row, SIDE_COL, "Moves")
+	lines := make([]string, 0)
+	for i := 0; i < len(moves); i += 2 {
+		line := fmt.Sprintf("%d. %s", i/2+1, moves[i])
+		if i+1 < len(moves) {
+			line += " " + moves[i+1]
+		}
+		lines = append(lines, line)
+	}
+	space := MESSAGE_ROW - row - 2
+	if len(lines) > space {
+		lines = lines[len(lines)-space:]
+	}
+	for i, line := range lines {
+		writeAt(screen, row+1+i, SIDE_COL, line)
+	}
+}
+
+func (t *TUI) drawLobbies(screen *strings.Builder) {
+	writeAt(screen, BOARD_ROW, LIST_COL, "Lobbies (.list to refresh)")
+
+	// Sort by name so the list doesn't jump around
+	names := make([]string, 0)
+	for name := range t.ctx.Lobbies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		if i >= PANEL_HEIGHT-1 {
+			break
+		}
+		listing := t.ctx.Lobbies[name]
+		writeAt(screen, BOARD_ROW+1+i, LIST_COL, listing.Description())
+	}
+}
+
+func (t *TUI) drawChat(screen *strings.Builder, width int) {
+	writeAt(screen, BOARD_ROW, LIST_COL, "Chat (.say <message>)")
+
+	chat := t.ctx.Chat
+	if len(chat) > PANEL_HEIGHT-1 {
+		chat = chat[len(chat)-(PANEL_HEIGHT-1):]
+	}
+
+	for i, line := range chat {
+		who := "Them: "
+		if line.Mine {
+			who = "You:  "
+		}
+		writeAt(screen, BOARD_ROW+1+i, LIST_COL, truncate(who+line.Text, width-LIST_COL))
+	}
+}
+
+func (t *TUI) drawMessages(screen *strings.Builder, width int, height int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	// Show as many of the most recent messages as fit above the command rows
+	space := height - MESSAGE_ROW - 2
+	if space < 1 {
+		return
+	}
+
+	messages := t.messages
+	if len(messages) > space {
+		messages = messages[len(messages)-space:]
+	}
+
+	for i, message := range messages {
+		writeAt(screen, MESSAGE_ROW+i, 1, truncate(message, width))
+	}
+}
+
+func commandHints(ctx *Context) string {
+	// Claiming the win only makes sense while the opponent is silent
+	claim := ""
+	if ctx.Connection.PeerUnresponsive() {
+		claim = " | .claim"
+	}
+
+	switch ctx.ClientState {
+	case MENU:
+		return ".start <name> [variant] | .list | .join <name> | .watch <name> | Ctrl-C quits"
+	case LOBBY:
+		return ".start | .say <message> | .leave | Ctrl-C quits"
+	case MY_TURN:
+		return "Arrows + Enter to move | .move <src> <dest> | .drop <piece> <dest> | .say | .forfeit" + claim
+	case THEIR_TURN:
+		return "Waiting for their move | .say <message> | .forfeit" + claim
+	case SPECTATING:
+		return "Watching the game | .leave | Ctrl-C quits"
+	default:
+		return ""
+	}
+}
+
+// How the link to the peer is doing, so a slow or lossy one shows before moves start going missing
+func linkStatus(conn *networking.Connection) string {
+	smoothed, timeout, backoff := conn.RTT()
+	status := fmt.Sprintf("RTT %dms RTO %dms", smoothed.Milliseconds(), timeout.Milliseconds())
+	if backoff > 0 {
+		status += fmt.Sprintf(" backoff %d", backoff)
+	}
+	return truncate(status, LIST_COL-SIDE_COL-1)
+}
+
+func writeAt(screen *strings.Builder, row int, col int, text string) {
+	// Move the cursor, then write the text
+	screen.WriteString(fmt.Sprintf("\033[%d;%dH", row, col))
+	screen.WriteString(text)
+}
+
+func truncate(text string, width int) string {
+	if width < 1 {
+		return ""
+	}
+	if utf8.RuneCountInString(text) <= width {
+		return text
+	}
+	return string([]rune(text)[:width])
+}
+
+func formatClock(elapsed time.Duration) string {
+	seconds := int(elapsed.Seconds())
+	return fmt.Sprintf("%02d:%02d", seconds/60, seconds%60)
+}
@@ -0,0 +1,76 @@
+package util
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+type winsize struct {
+	rows    uint16
+	cols    uint16
+	xPixels uint16
+	yPixels uint16
+}
+
+func IsTerminal(fd int) bool {
+	// Only terminals respond to a request for their settings
+	_, err := getTermios(fd)
+	return err == nil
+}
+
+func MakeRaw(fd int) (*syscall.Termios, error) {
+	oldState, err := getTermios(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	// Turn off line buffering, echoing and signal keys so we receive every key press as it happens
+	rawState := *oldState
+	rawState.Lflag &^= syscall.ICANON | syscall.ECHO | syscall.ISIG | syscall.IEXTEN
+	rawState.Iflag &^= syscall.IXON | syscall.ICRNL | syscall.BRKINT | syscall.INPCK | syscall.ISTRIP
+	rawState.Cflag |= syscall.CS8
+
+	// Block until at least one byte is available
+	rawState.Cc[syscall.VMIN] = 1
+	rawState.Cc[syscall.VTIME] = 0
+
+	err = setTermios(fd, &rawState)
+	if err != nil {
+		return nil, err
+	}
+
+	return oldState, nil
+}
+
+func RestoreTerminal(fd int, state *syscall.Termios) error {
+	return setTermios(fd, state)
+}
+
+func TerminalSize(fd int) (int, int, error) {
+	var size winsize
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(&size)))
+	if errno != 0 {
+		return 0, 0, errno
+	}
+
+	return int(size.cols), int(size.rows), nil
+}
+
+func getTermios(fd int) (*syscall.Termios, error) {
+	var termios syscall.Termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TCGETS, uintptr(unsafe.Pointer(&termios)))
+	if errno != 0 {
+		return nil, errno
+	}
+
+	return &termios, nil
+}
+
+func setTermios(fd int, termios *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TCSETS, uintptr(unsafe.Pointer(termios)))
+	if errno != 0 {
+		return errno
+	}
+
+	return nil
+}
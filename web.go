@@ -0,0 +1,341 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"project-go/chess"
+	"project-go/logging"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The web interface only ever listens on this machine
+const WEB_HOST = "127.0.0.1"
+const DEFAULT_WEB_PORT = "8080"
+
+// How many lines of output we keep around to show in the browser
+const MAX_WEB_MESSAGES = 50
+
+//go:embed web/index.html
+var webPage []byte
+
+// Everything the browser needs to draw the page, built on the main thread so it never races with the game
+type WebState struct {
+	State        string           `json:"state"`
+	Hints        string           `json:"hints"`
+	PlayerColour string           `json:"playerColour"`
+	Turn         string           `json:"turn"`
+	Variant      string           `json:"variant"`
+	Board        [8][8]string     `json:"board"`
+	LastMove     []string         `json:"lastMove"`
+	Check        string           `json:"check"`
+	Moves        []string         `json:"moves"`
+	Clocks       map[string]int64 `json:"clocks"`
+	Lobby        string           `json:"lobby"`
+	Hosting      bool             `json:"hosting"`
+	Lobbies      []WebLobby       `json:"lobbies"`
+	Chat         []WebChat        `json:"chat"`
+	Messages     []string         `json:"messages"`
+}
+
+type WebLobby struct {
+	Name       string `json:"name"`
+	Variant    string `json:"variant"`
+	Spectators int    `json:"spectators"`
+}
+
+type WebChat struct {
+	Mine bool   `json:"mine"`
+	Text string `json:"text"`
+}
+
+type WebUI struct {
+	commands    chan<- string
+	lastPublish time.Time
+	hosts       []string // The only Host headers we answer to, so another site can't reach us by rebinding its name
+
+	// The HTTP handlers run on their own threads, so everything below is locked
+	lock        sync.Mutex
+	snapshot    []byte
+	subscribers map[chan []byte]bool
+	messages    []string
+	partial     string
+}
+
+func StartWebUI(port string, commands chan<- string) (*WebUI, error) {
+	web := &WebUI{
+		commands:    commands,
+		snapshot:    []byte("{}"),
+		subscribers: make(map[chan []byte]bool),
+	}
+
+	// Listen before returning, so the caller finds out straight away if the port is taken
+	listener, err := net.Listen("tcp", net.JoinHostPort(WEB_HOST, port))
+	if err != nil {
+		return nil, err
+	}
+
+	// The port may have been picked for us, so take it from the listener
+	_, listenPort, err := net.SplitHostPort(listener.Addr().String())
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	web.hosts = []string{net.JoinHostPort(WEB_HOST, listenPort), net.JoinHostPort("localhost", listenPort)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", web.local(web.handlePage))
+	mux.HandleFunc("/api/state", web.local(web.handleState))
+	mux.HandleFunc("/api/events", web.local(web.handleEvents))
+	mux.HandleFunc("/api/command", web.local(web.handleCommand))
+
+	go func() {
+		err := http.Serve(listener, mux)
+		if err != nil {
+			logging.Log("Web interface stopped: " + err.Error())
+		}
+	}()
+
+	// Keep a copy of all output so the browser can show it too
+	logging.AddTee(web.appendOutput)
+
+	logging.Log("Web interface available at http://" + listener.Addr().String())
+	return web, nil
+}
+
+func (w *WebUI) Tick(ctx *Context) {
+	// The clocks only need updating a couple of times a second
+	if time.Since(w.lastPublish) >= REDRAW_INTERVAL {
+		w.Publish(ctx)
+	}
+}
+
+func (w *WebUI) Publish(ctx *Context) {
+	w.lastPublish = time.Now()
+
+	w.lock.Lock()
+	state := w.buildState(ctx)
+	w.lock.Unlock()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		logging.Debug("error building web state: " + err.Error())
+		return
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.snapshot = data
+
+	// Never block the game on a slow browser, it will catch up with the next update
+	for subscriber := range w.subscribers {
+		select {
+		case subscriber <- data:
+		default:
+		}
+	}
+}
+
+func (w *WebUI) buildState(ctx *Context) WebState {
+	state := WebState{
+		State:        ctx.ClientState.String(),
+		Hints:        commandHints(ctx),
+		PlayerColour: ctx.PlayerColour.String(),
+		Turn:         ctx.GameState.Turn().String(),
+		Variant:      ctx.GameState.Variant().String(),
+		Moves:        ctx.GameState.Moves(),
+		Clocks:       make(map[string]int64),
+		Lobby:        ctx.Lobby.Name(),
+		Hosting:      ctx.Lobby.hosting,
+		Lobbies:      make([]WebLobby, 0),
+		Chat:         make([]WebChat, 0),
+		Messages:     w.messages,
+	}
+
+	board := ctx.GameState.Board()
+	for row := range board.State {
+		for col := range board.State[row] {
+			if board.State[row][col] != nil {
+				state.Board[row][col] = board.State[row][col].Representation()
+			}
+		}
+	}
+
+	highlights := ctx.GameState.Highlights()
+	for _, pos := range highlights.LastMove {
+		state.LastMove = append(state.LastMove, pos.String())
+	}
+	if highlights.Check != nil {
+		state.Check = highlights.Check.String()
+	}
+
+	for _, colour := range []chess.Colour{chess.WHITE, chess.BLACK} {
+		state.Clocks[colour.String()] = ctx.Clock.Elapsed(colour).Milliseconds()
+	}
+
+	for _, listing := range ctx.Lobbies {
+		state.Lobbies = append(state.Lobbies, WebLobby{Name: listing.Name, Variant: listing.Variant.String(), Spectators: listing.Spectators})
+	}
+	sort.Slice(state.Lobbies, func(i, j int) bool {
+		return state.Lobbies[i].Name < state.Lobbies[j].Name
+	})
+
+	for _, line := range ctx.Chat {
+		state.Chat = append(state.Chat, WebChat{Mine: line.Mine, Text: line.Text})
+	}
+
+	return state
+}
+
+func (w *WebUI) appendOutput(text string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	// Output may arrive in pieces, so only complete lines are kept
+	w.partial += text
+	lines := strings.Split(w.partial, "\n")
+	w.partial = lines[len(lines)-1]
+
+	// Copy rather than append, since published snapshots may still be holding the old slice
+	messages := make([]string, 0, len(w.messages)+len(lines)-1)
+	messages = append(messages, w.messages...)
+	messages = append(messages, lines[:len(lines)-1]...)
+	if len(messages) > MAX_WEB_MESSAGES {
+		messages = messages[len(messages)-MAX_WEB_MESSAGES:]
+	}
+	w.messages = messages
+}
+
+// Only serves requests made to this machine by our own page, anything else could be another site driving the game
+func (w *WebUI) local(handler http.HandlerFunc) http.HandlerFunc {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		if !w.allowedHost(r.Host) {
+			http.Error(rw, "unknown host", http.StatusForbidden)
+			return
+		}
+
+		// Browsers send the origin of the page making the request, which has to be our own page
+		origin := r.Header.Get("Origin")
+		if origin != "" && !w.allowedOrigin(origin) {
+			http.Error(rw, "cross origin requests are not allowed", http.StatusForbidden)
+			return
+		}
+
+		handler(rw, r)
+	}
+}
+
+func (w *WebUI) allowedHost(host string) bool {
+	for _, allowed := range w.hosts {
+		if strings.EqualFold(host, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *WebUI) allowedOrigin(origin string) bool {
+	for _, allowed := range w.hosts {
+		if strings.EqualFold(origin, "http://"+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *WebUI) handlePage(rw http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(rw, r)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+	rw.Write(webPage)
+}
+
+func (w *WebUI) handleState(rw http.ResponseWriter, r *http.Request) {
+	w.lock.Lock()
+	snapshot := w.snapshot
+	w.lock.Unlock()
+
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Write(snapshot)
+}
+
+func (w *WebUI) handleEvents(rw http.ResponseWriter, r *http.Request) {
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		http.Error(rw, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	rw.Header().Set("Content-Type", "text/event-stream")
+	rw.Header().Set("Cache-Control", "no-cache")
+
+	// Register for updates, and start off with the current state
+	updates := make(chan []byte, 8)
+	w.lock.Lock()
+	w.subscribers[updates] = true
+	snapshot := w.snapshot
+	w.lock.Unlock()
+
+	defer func() {
+		w.lock.Lock()
+		delete(w.subscribers, updates)
+		w.lock.Unlock()
+	}()
+
+	for {
+		fmt.Fprintf(rw, "data: %s\n\n", snapshot)
+		flusher.Flush()
+
+		select {
+		case snapshot = <-updates:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (w *WebUI) handleCommand(rw http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(rw, "commands must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// A plain form on another site can only post text, so requiring JSON keeps them from sending commands
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		http.Error(rw, "commands must be sent as application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var request struct {
+		Command string `json:"command"`
+	}
+	err = json.NewDecoder(r.Body).Decode(&request)
+	if err != nil {
+		http.Error(rw, "invalid command: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Commands are handled on the main thread, exactly as if they were typed in
+	command := strings.TrimSpace(request.Command)
+	if command == "" {
+		http.Error(rw, "empty command", http.StatusBadRequest)
+		return
+	}
+
+	select {
+	case w.commands <- command:
+		rw.WriteHeader(http.StatusAccepted)
+	case <-time.After(time.Second):
+		http.Error(rw, "the game is busy, try again", http.StatusServiceUnavailable)
+	}
+}
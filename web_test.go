@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebCommandOnlyFromOurPage(t *testing.T) {
+	cases := []struct {
+		name        string
+		host        string
+		origin      string
+		contentType string
+		status      int
+	}{
+		{"our page", "127.0.0.1:8080", "http://127.0.0.1:8080", "application/json", http.StatusAccepted},
+		{"localhost", "localhost:8080", "http://localhost:8080", "application/json; charset=utf-8", http.StatusAccepted},
+		{"no origin", "127.0.0.1:8080", "", "application/json", http.StatusAccepted},
+		{"plain form", "127.0.0.1:8080", "http://127.0.0.1:8080", "text/plain", http.StatusUnsupportedMediaType},
+		{"no content type", "127.0.0.1:8080", "", "", http.StatusUnsupportedMediaType},
+		{"rebound name", "evil.example:8080", "http://evil.example:8080", "application/json", http.StatusForbidden},
+		{"other port", "127.0.0.1:9090", "", "application/json", http.StatusForbidden},
+		{"other site", "127.0.0.1:8080", "http://evil.example", "application/json", http.StatusForbidden},
+	}
+
+	for _, c := range cases {
+		commands := make(chan string, 1)
+		web := &WebUI{commands: commands, hosts: []string{"127.0.0.1:8080", "localhost:8080"}}
+
+		request := httptest.NewRequest(http.MethodPost, "/api/command", strings.NewReader(`{"command": ".forfeit"}`))
+		request.Host = c.host
+		if c.origin != "" {
+			request.Header.Set("Origin", c.origin)
+		}
+		if c.contentType != "" {
+			request.Header.Set("Content-Type", c.contentType)
+		}
+
+		recorder := httptest.NewRecorder()
+		web.local(web.handleCommand)(recorder, request)
+
+		if recorder.Code != c.status {
+			t.Errorf("%s: got status %d, want %d", c.name, recorder.Code, c.status)
+		}
+
+		// Only an accepted request may reach the game
+		received := len(commands) > 0
+		if received != (c.status == http.StatusAccepted) {
+			t.Errorf("%s: command reached the game: %v", c.name, received)
+		}
+	}
+}